@@ -15,6 +15,8 @@ import (
 	"github.com/self-made-boy/youtube-tools/internal/api"
 	"github.com/self-made-boy/youtube-tools/internal/config"
 	"github.com/self-made-boy/youtube-tools/internal/logger"
+	"github.com/self-made-boy/youtube-tools/internal/storage"
+	"github.com/self-made-boy/youtube-tools/internal/ytdlp"
 )
 
 // @title           YouTube Tools API
@@ -29,6 +31,11 @@ import (
 // @BasePath  /api/yt/
 
 // @securityDefinitions.basic  BasicAuth
+
+// @securityDefinitions.apikey  AdminToken
+// @in                          header
+// @name                        Authorization
+// @description                 管理接口令牌，格式为 Bearer <admin_token>
 func main() {
 	// 初始化配置
 	cfg, err := config.Load()
@@ -61,12 +68,38 @@ func main() {
 		zap.Int64("max_file_size", cfg.Ytdlp.MaxFileSize),
 		zap.Strings("audio_formats", cfg.Ytdlp.AudioFormats),
 		zap.Strings("video_formats", cfg.Ytdlp.VideoFormats),
+		zap.String("task_store_dir", cfg.Ytdlp.TaskStoreDir),
+		zap.Int("max_playlist_entries", cfg.Ytdlp.MaxPlaylistEntries),
+		zap.Strings("format_policy", cfg.Ytdlp.FormatPolicy),
+		zap.Int("presets", len(cfg.Ytdlp.Presets)),
+		zap.Bool("webhook_signing", cfg.Webhook.Secret != ""),
+		zap.Int("webhook_max_retries", cfg.Webhook.MaxRetries),
+		zap.Duration("webhook_timeout", cfg.Webhook.Timeout),
+		zap.String("storage_type", cfg.Storage.Type),
 		zap.String("s3_mount", cfg.S3Mount),
 		zap.String("s3_prefix", cfg.S3Prefix),
 	)
 
+	// 检查 yt-dlp 和 ffmpeg，缺少任一程序时所有下载都会失败，直接退出
+	binaries, err := ytdlp.CheckBinaries(cfg.Ytdlp)
+	if err != nil {
+		logger.Fatal("Preflight check failed", zap.Error(err))
+	}
+	logger.Info("Preflight check passed",
+		zap.String("ytdlp_path", binaries.Ytdlp.Path),
+		zap.String("ytdlp_version", binaries.Ytdlp.Version),
+		zap.String("ffmpeg_path", binaries.Ffmpeg.Path),
+		zap.String("ffmpeg_version", binaries.Ffmpeg.Version),
+	)
+
+	// 创建存储，配置错误时所有下载都无法保存结果，直接退出
+	fileStorage, err := storage.New(cfg)
+	if err != nil {
+		logger.Fatal("Failed to create storage", zap.Error(err))
+	}
+
 	// 初始化路由
-	router := api.SetupRouter(cfg, logger)
+	router := api.SetupRouter(cfg, logger, binaries, fileStorage)
 
 	// 创建 HTTP 服务器
 	server := &http.Server{
@@ -19,9 +19,169 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/admin/cache": {
+            "delete": {
+                "security": [
+                    {
+                        "AdminToken": []
+                    }
+                ],
+                "description": "删除指定视频缓存的信息，下次获取信息或下载时重新执行 yt-dlp。\n配置了 admin_token 时需要在 Authorization 头中携带 Bearer 令牌",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "管理"
+                ],
+                "summary": "删除视频信息缓存",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "视频 URL",
+                        "name": "url",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/response.Response"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/handlers.PurgeVideoCacheResp"
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/storage/gc": {
+            "get": {
+                "security": [
+                    {
+                        "AdminToken": []
+                    }
+                ],
+                "description": "按 retention 配置计算将被清理的文件但不删除，包括超过 max_age 未访问的文件、超过 max_total_size 时将被淘汰的文件，\n以及 download_dir 中中断的下载留下的临时文件。\n配置了 admin_token 时需要在 Authorization 头中携带 Bearer 令牌",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "管理"
+                ],
+                "summary": "存储清理试运行",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/response.Response"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/ytdlp.RetentionReport"
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "AdminToken": []
+                    }
+                ],
+                "description": "立即按 retention 配置清理存储和 download_dir，返回实际删除的文件，删除失败的文件带有错误信息。\n配置了 admin_token 时需要在 Authorization 头中携带 Bearer 令牌",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "管理"
+                ],
+                "summary": "立即执行存储清理",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/response.Response"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/ytdlp.RetentionReport"
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    }
+                }
+            }
+        },
         "/download": {
             "post": {
-                "description": "开始下载指定 URL 的视频",
+                "description": "开始下载指定 URL 的视频，使用字幕格式ID时只下载字幕文件。\n指定 start/end 时只下载该片段，片段保存在独立的路径下，任务ID也与完整视频不同。\n指定 preset 时按配置的转码预设编码，不同预设的结果同样保存在独立的路径下。",
                 "consumes": [
                     "application/json"
                 ],
@@ -68,6 +228,12 @@ const docTemplate = `{
                             "$ref": "#/definitions/response.Response"
                         }
                     },
+                    "413": {
+                        "description": "Request Entity Too Large",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -75,18 +241,16 @@ const docTemplate = `{
                         }
                     }
                 }
-            }
-        },
-        "/download/status": {
-            "get": {
-                "description": "获取指定任务 ID 的下载状态",
+            },
+            "delete": {
+                "description": "取消指定任务 ID 的下载，结束 yt-dlp 及 ffmpeg 进程并清理未完成的文件",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
                     "youtube"
                 ],
-                "summary": "获取下载状态",
+                "summary": "取消下载",
                 "parameters": [
                     {
                         "type": "string",
@@ -126,205 +290,1327 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/response.Response"
                         }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    }
+                }
+            }
+        },
+        "/download/events": {
+            "get": {
+                "description": "以 Server-Sent Events 推送指定任务的状态变化，任务结束时发送以最终状态命名的事件（completed、failed、cancelled）后关闭连接。\n每个事件的 id 为任务的 revision，断线重连时通过 Last-Event-ID 头避免重复推送。",
+                "produces": [
+                    "text/event-stream"
+                ],
+                "tags": [
+                    "youtube"
+                ],
+                "summary": "订阅下载进度",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "任务 ID",
+                        "name": "task_id",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "上次收到的事件 ID",
+                        "name": "Last-Event-ID",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.DownloadTaskStatusResp"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    }
+                }
+            }
+        },
+        "/download/file": {
+            "get": {
+                "description": "直接从存储中读取已完成任务的结果文件，支持 Range、If-None-Match、If-Modified-Since 等请求头，播放器可以拖动进度。\n文件名由视频标题生成，inline 为 true 时浏览器直接播放而不是保存。\n开启 signed_url 时需要带上任务下载地址中的 expires 和 signature，签名错误或过期时返回 403；使用 s3 存储时只能通过预签名地址下载",
+                "produces": [
+                    "application/octet-stream"
+                ],
+                "tags": [
+                    "youtube"
+                ],
+                "summary": "下载任务结果文件",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "任务 ID",
+                        "name": "task_id",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "过期时间，Unix 秒，开启 signed_url 时必填",
+                        "name": "expires",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "签名，开启 signed_url 时必填",
+                        "name": "signature",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "使用 inline 而不是 attachment 的 Content-Disposition",
+                        "name": "inline",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "请求的字节范围，如 bytes=0-1023",
+                        "name": "Range",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "206": {
+                        "description": "Partial Content",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "304": {
+                        "description": "文件未变化"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    },
+                    "416": {
+                        "description": "请求的范围无效"
                     }
                 }
             }
         },
-        "/health": {
-            "get": {
-                "description": "获取 API 服务的健康状态",
-                "produces": [
-                    "application/json"
-                ],
-                "tags": [
-                    "系统"
-                ],
-                "summary": "健康检查",
-                "responses": {
-                    "200": {
-                        "description": "OK",
-                        "schema": {
-                            "$ref": "#/definitions/response.Response"
-                        }
-                    }
+        "/download/status": {
+            "get": {
+                "description": "获取指定任务 ID 的下载状态",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "youtube"
+                ],
+                "summary": "获取下载状态",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "任务 ID",
+                        "name": "task_id",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/response.Response"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/handlers.DownloadTaskStatusResp"
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    }
+                }
+            }
+        },
+        "/download/stream": {
+            "get": {
+                "description": "同步下载音频并以分块传输直接返回给客户端，结果不写入存储，适合一次性获取音频。\n需要转换格式时通过 ffmpeg 转换后输出，客户端断开时立即结束下载。响应不支持 Range，开始输出后出错时连接会被中断。",
+                "produces": [
+                    "application/octet-stream"
+                ],
+                "tags": [
+                    "youtube"
+                ],
+                "summary": "流式下载音频",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "视频 URL",
+                        "name": "url",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "音频格式 ID",
+                        "name": "format_id",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "使用 inline 而不是 attachment 的 Content-Disposition",
+                        "name": "inline",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    },
+                    "413": {
+                        "description": "Request Entity Too Large",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    }
+                }
+            }
+        },
+        "/files/{key}": {
+            "get": {
+                "description": "使用本地存储并开启 signed_url 时，下载地址指向该接口，签名错误或过期时返回 403。支持 Range 请求",
+                "produces": [
+                    "application/octet-stream"
+                ],
+                "tags": [
+                    "youtube"
+                ],
+                "summary": "下载文件",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "文件路径",
+                        "name": "key",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "过期时间，Unix 秒",
+                        "name": "expires",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "签名",
+                        "name": "signature",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "206": {
+                        "description": "Partial Content",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    }
+                }
+            }
+        },
+        "/health": {
+            "get": {
+                "description": "获取 API 服务的健康状态及启动时检测到的 yt-dlp、ffmpeg 版本",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "系统"
+                ],
+                "summary": "健康检查",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/response.Response"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/handlers.HealthCheckResp"
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    }
+                }
+            }
+        },
+        "/healthz": {
+            "get": {
+                "description": "进程能够处理请求即返回 200，不检查外部依赖，用于 livenessProbe",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "系统"
+                ],
+                "summary": "存活检查",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    }
+                }
+            }
+        },
+        "/info": {
+            "get": {
+                "description": "获取指定 URL 的视频信息，缓存超过 info_cache_ttl 或指定 refresh 时重新获取",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "youtube"
+                ],
+                "summary": "获取视频信息",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "视频 URL",
+                        "name": "url",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "格式排序规则，逗号分隔，可选 quality、compatible、smallest、original_language",
+                        "name": "policy",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "忽略缓存，重新获取视频信息",
+                        "name": "refresh",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/response.Response"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/ytdlp.VideoInfo"
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    }
+                }
+            }
+        },
+        "/playlist": {
+            "get": {
+                "description": "展开播放列表或频道链接，分页返回其中的视频",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "youtube"
+                ],
+                "summary": "获取播放列表信息",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "播放列表或频道 URL",
+                        "name": "url",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "default": 1,
+                        "description": "页码，从 1 开始",
+                        "name": "page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "default": 50,
+                        "description": "每页条目数，最大 200",
+                        "name": "page_size",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/response.Response"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/ytdlp.PlaylistInfo"
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    }
+                }
+            }
+        },
+        "/playlist/download": {
+            "post": {
+                "description": "为播放列表或频道中的每个视频按格式偏好创建一个下载任务",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "youtube"
+                ],
+                "summary": "批量下载播放列表",
+                "parameters": [
+                    {
+                        "description": "批量下载请求",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.StartPlaylistDownloadRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/response.Response"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/handlers.StartPlaylistDownloadResp"
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/response.Response"
+                        }
+                    }
+                }
+            }
+        },
+        "/readyz": {
+            "get": {
+                "description": "检查 yt-dlp 和 ffmpeg 能否执行、存储可用、download_dir 可写且剩余空间充足、cookies 文件可读、等待队列未满，\n任一检查失败时返回 503，响应中包含每项检查的结果，用于 readinessProbe",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "系统"
+                ],
+                "summary": "就绪检查",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/response.Response"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/ytdlp.ReadinessReport"
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/response.Response"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/ytdlp.ReadinessReport"
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "handlers.DownloadTaskStatusResp": {
+            "type": "object",
+            "properties": {
+                "callbacks": {
+                    "description": "回调投递记录",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/ytdlp.CallbackAttempt"
+                    }
+                },
+                "clip": {
+                    "description": "片段的起止时间，下载完整视频时为空",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/ytdlp.ClipRange"
+                        }
+                    ]
+                },
+                "download_url": {
+                    "description": "下载文件路径",
+                    "type": "string",
+                    "example": "https://xxx.com/123456.m4a"
+                },
+                "download_url_expires_at": {
+                    "description": "开启签名下载地址时下载地址的过期时间，每次查询都会生成新的地址",
+                    "type": "string"
+                },
+                "error": {
+                    "description": "错误信息",
+                    "type": "string",
+                    "example": "Download failed: exit status 1"
+                },
+                "error_code": {
+                    "description": "错误码，FILE_TOO_LARGE：文件超过大小限制；FORMAT_UNAVAILABLE：所选格式已不存在，需要重新获取视频信息",
+                    "type": "string",
+                    "example": "FILE_TOO_LARGE"
+                },
+                "eta": {
+                    "description": "预计时间",
+                    "type": "string",
+                    "example": "10s"
+                },
+                "preset": {
+                    "description": "使用的转码预设名称",
+                    "type": "string",
+                    "example": "mobile-480p"
+                },
+                "processing": {
+                    "description": "下载后对音视频流的处理方式，copy 表示直接复制，transcode 表示转码",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/ytdlp.ProcessingDecision"
+                        }
+                    ]
+                },
+                "progress": {
+                    "description": "下载进度",
+                    "type": "number",
+                    "example": 0.5
+                },
+                "queue_position": {
+                    "description": "排队位置，从 1 开始，0 表示未在排队",
+                    "type": "integer",
+                    "example": 3
+                },
+                "state": {
+                    "description": "下载状态",
+                    "type": "string",
+                    "example": "pending, downloading, completed, failed, cancelled"
+                },
+                "task_id": {
+                    "description": "任务ID",
+                    "type": "string",
+                    "example": "123456"
+                }
+            }
+        },
+        "handlers.HealthCheckResp": {
+            "type": "object",
+            "properties": {
+                "binaries": {
+                    "description": "启动时检测到的外部程序",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/ytdlp.Binaries"
+                        }
+                    ]
+                },
+                "uptime": {
+                    "description": "运行时长",
+                    "type": "string",
+                    "example": "1h2m3s"
+                },
+                "version": {
+                    "description": "服务版本",
+                    "type": "string",
+                    "example": "1.0.0"
+                }
+            }
+        },
+        "handlers.PurgeVideoCacheResp": {
+            "type": "object",
+            "properties": {
+                "purged": {
+                    "description": "缓存是否存在并已删除",
+                    "type": "boolean",
+                    "example": true
+                },
+                "video_id": {
+                    "type": "string",
+                    "example": "dQw4w9WgXcQ"
+                }
+            }
+        },
+        "handlers.StartDownloadRequest": {
+            "type": "object",
+            "required": [
+                "url"
+            ],
+            "properties": {
+                "callback_url": {
+                    "description": "任务结束时回调的地址，会收到带签名的 JSON 通知，不能指向内网地址，配置了 allowed_hosts 时必须在列表中",
+                    "type": "string",
+                    "example": "https://example.com/hooks/yt"
+                },
+                "end": {
+                    "description": "片段结束时间，秒数或 HH:MM:SS，为空时到视频结尾",
+                    "type": "string",
+                    "example": "90"
+                },
+                "format_id": {
+                    "description": "下载的格式，可以是 /info 返回的音频、视频或字幕格式ID",
+                    "type": "string"
+                },
+                "preset": {
+                    "description": "转码预设名称，为空时不使用预设",
+                    "type": "string",
+                    "example": "mobile-480p"
+                },
+                "start": {
+                    "description": "片段开始时间，秒数或 HH:MM:SS，为空时从头开始",
+                    "type": "string",
+                    "example": "00:01:00"
+                },
+                "url": {
+                    "description": "下载的url",
+                    "type": "string"
+                }
+            }
+        },
+        "handlers.StartDownloadResp": {
+            "type": "object",
+            "properties": {
+                "task_id": {
+                    "type": "string"
+                }
+            }
+        },
+        "handlers.StartPlaylistDownloadRequest": {
+            "type": "object",
+            "required": [
+                "ext",
+                "type",
+                "url"
+            ],
+            "properties": {
+                "callback_url": {
+                    "description": "每个任务结束时回调的地址，限制与单个下载相同",
+                    "type": "string",
+                    "example": "https://example.com/hooks/yt"
+                },
+                "ext": {
+                    "description": "目标文件扩展名",
+                    "type": "string",
+                    "example": "mp3"
+                },
+                "limit": {
+                    "description": "最多下载的条目数，0 表示全部",
+                    "type": "integer",
+                    "minimum": 0,
+                    "example": 20
+                },
+                "max_height": {
+                    "description": "视频最大高度，0 表示最高画质",
+                    "type": "integer",
+                    "minimum": 0,
+                    "example": 720
+                },
+                "preset": {
+                    "description": "转码预设名称，应用到每个任务上",
+                    "type": "string",
+                    "example": "podcast-64k-mono"
+                },
+                "type": {
+                    "description": "下载类型，audio 或 video",
+                    "type": "string",
+                    "enum": [
+                        "audio",
+                        "video"
+                    ],
+                    "example": "audio"
+                },
+                "url": {
+                    "description": "播放列表或频道的url",
+                    "type": "string"
+                }
+            }
+        },
+        "handlers.StartPlaylistDownloadResp": {
+            "type": "object",
+            "properties": {
+                "tasks": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/ytdlp.PlaylistDownloadItem"
+                    }
+                }
+            }
+        },
+        "response.Response": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "string"
+                },
+                "data": {},
+                "message": {
+                    "type": "string"
+                }
+            }
+        },
+        "ytdlp.AudioFormat": {
+            "type": "object",
+            "properties": {
+                "abr": {
+                    "description": "音频码率，单位：Kbps",
+                    "type": "number",
+                    "example": 130.5
+                },
+                "acodec": {
+                    "description": "音频编码",
+                    "type": "string",
+                    "example": "opus"
+                },
+                "asr": {
+                    "description": "采样率",
+                    "type": "integer",
+                    "example": 44100
+                },
+                "audio_channels": {
+                    "description": "音频声道数",
+                    "type": "integer",
+                    "example": 2
+                },
+                "ext": {
+                    "description": "音频文件扩展名",
+                    "type": "string",
+                    "example": "m4a"
+                },
+                "filesize": {
+                    "description": "预估文件大小，单位：字节，0 表示未知",
+                    "type": "integer",
+                    "example": 3407872
+                },
+                "format_id": {
+                    "description": "音频格式ID",
+                    "type": "string",
+                    "example": "140"
+                },
+                "language": {
+                    "description": "音频语言",
+                    "type": "string",
+                    "example": "en"
+                }
+            }
+        },
+        "ytdlp.AudioFormatGroup": {
+            "type": "object",
+            "properties": {
+                "ext": {
+                    "description": "音频文件扩展名",
+                    "type": "string",
+                    "example": "m4a"
+                },
+                "formats": {
+                    "description": "音频格式列表",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/ytdlp.AudioFormat"
+                    }
+                }
+            }
+        },
+        "ytdlp.Binaries": {
+            "type": "object",
+            "properties": {
+                "ffmpeg": {
+                    "$ref": "#/definitions/ytdlp.BinaryInfo"
+                },
+                "yt_dlp": {
+                    "$ref": "#/definitions/ytdlp.BinaryInfo"
+                }
+            }
+        },
+        "ytdlp.BinaryInfo": {
+            "type": "object",
+            "properties": {
+                "path": {
+                    "description": "解析后的可执行文件路径",
+                    "type": "string",
+                    "example": "/usr/local/bin/yt-dlp"
+                },
+                "version": {
+                    "description": "版本号",
+                    "type": "string",
+                    "example": "2025.01.15"
+                }
+            }
+        },
+        "ytdlp.CallbackAttempt": {
+            "type": "object",
+            "properties": {
+                "attempt": {
+                    "description": "第几次尝试，从 1 开始",
+                    "type": "integer",
+                    "example": 1
+                },
+                "error": {
+                    "description": "失败原因",
+                    "type": "string"
+                },
+                "status_code": {
+                    "description": "响应状态码，请求未发出时为 0",
+                    "type": "integer",
+                    "example": 200
+                },
+                "success": {
+                    "description": "是否投递成功",
+                    "type": "boolean",
+                    "example": true
+                },
+                "time": {
+                    "description": "尝试时间",
+                    "type": "string"
+                },
+                "url": {
+                    "description": "回调地址",
+                    "type": "string",
+                    "example": "https://example.com/hooks/yt"
+                }
+            }
+        },
+        "ytdlp.ClipRange": {
+            "type": "object",
+            "properties": {
+                "end": {
+                    "description": "结束时间，0 表示到视频结尾",
+                    "type": "integer",
+                    "example": 90
+                },
+                "start": {
+                    "description": "开始时间",
+                    "type": "integer",
+                    "example": 60
                 }
             }
         },
-        "/info": {
-            "get": {
-                "description": "获取指定 URL 的视频信息",
-                "produces": [
-                    "application/json"
-                ],
-                "tags": [
-                    "youtube"
-                ],
-                "summary": "获取视频信息",
-                "parameters": [
-                    {
-                        "type": "string",
-                        "description": "视频 URL",
-                        "name": "url",
-                        "in": "query",
-                        "required": true
-                    }
-                ],
-                "responses": {
-                    "200": {
-                        "description": "OK",
-                        "schema": {
-                            "allOf": [
-                                {
-                                    "$ref": "#/definitions/response.Response"
-                                },
-                                {
-                                    "type": "object",
-                                    "properties": {
-                                        "data": {
-                                            "$ref": "#/definitions/ytdlp.VideoInfo"
-                                        }
-                                    }
-                                }
-                            ]
-                        }
-                    },
-                    "400": {
-                        "description": "Bad Request",
-                        "schema": {
-                            "$ref": "#/definitions/response.Response"
-                        }
-                    },
-                    "500": {
-                        "description": "Internal Server Error",
-                        "schema": {
-                            "$ref": "#/definitions/response.Response"
-                        }
-                    }
+        "ytdlp.PlaylistDownloadItem": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "description": "创建失败的原因",
+                    "type": "string"
+                },
+                "task_id": {
+                    "description": "任务ID，创建失败时为空",
+                    "type": "string",
+                    "example": "123456"
+                },
+                "video_id": {
+                    "description": "视频ID",
+                    "type": "string",
+                    "example": "dQw4w9WgXcQ"
                 }
             }
-        }
-    },
-    "definitions": {
-        "handlers.DownloadTaskStatusResp": {
+        },
+        "ytdlp.PlaylistEntry": {
             "type": "object",
             "properties": {
-                "download_url": {
-                    "description": "下载文件路径",
+                "duration": {
+                    "description": "视频时长",
+                    "type": "integer",
+                    "example": 213
+                },
+                "id": {
+                    "description": "视频ID",
                     "type": "string",
-                    "example": "https://xxx.com/123456.m4a"
+                    "example": "dQw4w9WgXcQ"
                 },
-                "eta": {
-                    "description": "预计时间",
+                "thumbnail": {
+                    "description": "视频缩略图",
                     "type": "string",
-                    "example": "10s"
+                    "example": "https://i.ytimg.com/vi/dQw4w9WgXcQ/hqdefault.jpg"
                 },
-                "progress": {
-                    "description": "下载进度",
-                    "type": "number",
-                    "example": 0.5
+                "title": {
+                    "description": "视频标题",
+                    "type": "string",
+                    "example": "Rick Astley - Never Gonna Give You Up"
                 },
-                "state": {
-                    "description": "下载状态",
+                "url": {
+                    "description": "视频链接",
+                    "type": "string",
+                    "example": "https://www.youtube.com/watch?v=dQw4w9WgXcQ"
+                }
+            }
+        },
+        "ytdlp.PlaylistInfo": {
+            "type": "object",
+            "properties": {
+                "channel": {
+                    "description": "频道名称",
                     "type": "string",
-                    "example": "pending, downloading, completed, failed"
+                    "example": "Rick Astley"
                 },
-                "task_id": {
-                    "description": "任务ID",
+                "entries": {
+                    "description": "当前页的条目",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/ytdlp.PlaylistEntry"
+                    }
+                },
+                "id": {
+                    "description": "播放列表ID",
                     "type": "string",
-                    "example": "123456"
+                    "example": "PLFgquLnL59alCl_2TQvOiD5Vgm1hCaGSI"
+                },
+                "page": {
+                    "description": "当前页码，从 1 开始",
+                    "type": "integer",
+                    "example": 1
+                },
+                "page_size": {
+                    "description": "每页条目数",
+                    "type": "integer",
+                    "example": 50
+                },
+                "title": {
+                    "description": "播放列表标题",
+                    "type": "string",
+                    "example": "Popular Music Videos"
+                },
+                "total": {
+                    "description": "条目总数，最多为 max_playlist_entries",
+                    "type": "integer",
+                    "example": 120
+                },
+                "truncated": {
+                    "description": "播放列表条目超过 max_playlist_entries 时为 true，超出部分不会返回",
+                    "type": "boolean",
+                    "example": false
+                },
+                "webpage_url": {
+                    "description": "播放列表网页URL",
+                    "type": "string",
+                    "example": "https://www.youtube.com/playlist?list=PLFgquLnL59alCl_2TQvOiD5Vgm1hCaGSI"
                 }
             }
         },
-        "handlers.StartDownloadRequest": {
+        "ytdlp.ProcessingDecision": {
             "type": "object",
-            "required": [
-                "url"
-            ],
             "properties": {
-                "format_id": {
-                    "description": "下载的格式",
-                    "type": "string"
+                "audio": {
+                    "description": "音频流的处理方式：copy 或 transcode",
+                    "type": "string",
+                    "example": "copy"
                 },
-                "url": {
-                    "description": "下载的url",
-                    "type": "string"
+                "source_acodec": {
+                    "description": "源音频编码，无法得知时为空",
+                    "type": "string",
+                    "example": "mp4a.40.2"
+                },
+                "source_vcodec": {
+                    "description": "源视频编码，音频任务或无法得知时为空",
+                    "type": "string",
+                    "example": "avc1.640028"
+                },
+                "video": {
+                    "description": "视频流的处理方式：copy 或 transcode，音频任务为空",
+                    "type": "string",
+                    "example": "copy"
                 }
             }
         },
-        "handlers.StartDownloadResp": {
+        "ytdlp.ReadinessCheck": {
             "type": "object",
             "properties": {
-                "task_id": {
-                    "type": "string"
+                "message": {
+                    "description": "检查详情或失败原因",
+                    "type": "string",
+                    "example": "6.1.1"
+                },
+                "name": {
+                    "description": "检查项：yt_dlp、ffmpeg、storage、download_dir、cookies、queue",
+                    "type": "string",
+                    "example": "ffmpeg"
+                },
+                "ok": {
+                    "description": "是否通过",
+                    "type": "boolean",
+                    "example": true
                 }
             }
         },
-        "response.Response": {
+        "ytdlp.ReadinessReport": {
             "type": "object",
             "properties": {
-                "code": {
+                "checks": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/ytdlp.ReadinessCheck"
+                    }
+                },
+                "ready": {
+                    "type": "boolean",
+                    "example": true
+                }
+            }
+        },
+        "ytdlp.RetentionItem": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "description": "删除失败或被跳过的原因",
                     "type": "string"
                 },
-                "data": {},
-                "message": {
+                "key": {
+                    "description": "存储中的 key，download_dir 中的残留文件为本地路径",
+                    "type": "string",
+                    "example": "dQw4w9WgXcQ/audio/48000/dQw4w9WgXcQ.mp3"
+                },
+                "last_access": {
+                    "description": "最后访问时间，没有访问记录时为修改时间",
                     "type": "string"
+                },
+                "reason": {
+                    "description": "清理原因：expired、quota 或 orphan",
+                    "type": "string",
+                    "example": "expired"
+                },
+                "size": {
+                    "description": "文件大小，目录为其中所有文件的大小之和，单位：字节",
+                    "type": "integer",
+                    "example": 3407872
                 }
             }
         },
-        "ytdlp.AudioFormat": {
+        "ytdlp.RetentionReport": {
             "type": "object",
             "properties": {
-                "asr": {
-                    "description": "采样率",
+                "dry_run": {
+                    "description": "是否为试运行，试运行时不删除任何文件",
+                    "type": "boolean",
+                    "example": true
+                },
+                "items": {
+                    "description": "被清理的文件",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/ytdlp.RetentionItem"
+                    }
+                },
+                "reclaimed_bytes": {
+                    "description": "释放的字节数，试运行时为可以释放的字节数",
                     "type": "integer",
-                    "example": 44100
+                    "example": 536870912
+                },
+                "start_time": {
+                    "description": "开始清理的时间",
+                    "type": "string"
+                },
+                "total_bytes": {
+                    "description": "清理前存储中文件的总大小，单位：字节",
+                    "type": "integer",
+                    "example": 1073741824
                 },
+                "total_objects": {
+                    "description": "清理前存储中的文件数",
+                    "type": "integer",
+                    "example": 120
+                }
+            }
+        },
+        "ytdlp.SubtitleFormat": {
+            "type": "object",
+            "properties": {
                 "ext": {
-                    "description": "音频文件扩展名",
+                    "description": "文件扩展名",
                     "type": "string",
-                    "example": "m4a"
+                    "example": "vtt"
                 },
                 "format_id": {
-                    "description": "音频格式ID",
+                    "description": "格式ID",
                     "type": "string",
-                    "example": "140"
+                    "example": "735f5f7674745f5f656e5f5f6d616e75616c"
                 }
             }
         },
-        "ytdlp.AudioFormatGroup": {
+        "ytdlp.SubtitleTrack": {
             "type": "object",
             "properties": {
-                "ext": {
-                    "description": "音频文件扩展名",
-                    "type": "string",
-                    "example": "m4a"
+                "auto": {
+                    "description": "是否为自动生成的字幕",
+                    "type": "boolean",
+                    "example": false
                 },
                 "formats": {
-                    "description": "音频格式列表",
+                    "description": "可下载的字幕格式",
                     "type": "array",
                     "items": {
-                        "$ref": "#/definitions/ytdlp.AudioFormat"
+                        "$ref": "#/definitions/ytdlp.SubtitleFormat"
                     }
+                },
+                "lang": {
+                    "description": "语言代码",
+                    "type": "string",
+                    "example": "en"
+                },
+                "name": {
+                    "description": "语言名称",
+                    "type": "string",
+                    "example": "English"
                 }
             }
         },
         "ytdlp.VideoFormat": {
             "type": "object",
             "properties": {
+                "abr": {
+                    "description": "音频码率，单位：Kbps",
+                    "type": "number",
+                    "example": 130.5
+                },
+                "acodec": {
+                    "description": "音频编码",
+                    "type": "string",
+                    "example": "opus"
+                },
+                "audio_channels": {
+                    "description": "音频声道数",
+                    "type": "integer",
+                    "example": 2
+                },
+                "dynamic_range": {
+                    "description": "动态范围，如 SDR、HDR10、HLG",
+                    "type": "string",
+                    "example": "HDR10"
+                },
                 "ext": {
                     "description": "文件扩展名",
                     "type": "string",
                     "example": "mp4"
                 },
+                "filesize": {
+                    "description": "预估文件大小，单位：字节，0 表示未知",
+                    "type": "integer",
+                    "example": 251658240
+                },
                 "format_id": {
                     "description": "格式ID",
                     "type": "string",
                     "example": "137"
                 },
+                "fps": {
+                    "description": "帧率",
+                    "type": "number",
+                    "example": 60
+                },
+                "language": {
+                    "description": "音频语言",
+                    "type": "string",
+                    "example": "en"
+                },
                 "resolution": {
                     "description": "分辨率",
                     "type": "string",
                     "example": "1920x1080"
+                },
+                "tbr": {
+                    "description": "总码率，单位：Kbps",
+                    "type": "number",
+                    "example": 2500.5
+                },
+                "vbr": {
+                    "description": "视频码率，单位：Kbps",
+                    "type": "number",
+                    "example": 2370
+                },
+                "vcodec": {
+                    "description": "视频编码",
+                    "type": "string",
+                    "example": "vp09.00.50.08"
                 }
             }
         },
@@ -405,6 +1691,24 @@ const docTemplate = `{
                     "type": "integer",
                     "example": 80000
                 },
+                "policy": {
+                    "description": "选择格式时使用的排序规则",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "compatible",
+                        "quality"
+                    ]
+                },
+                "subtitles": {
+                    "description": "字幕，包括上传字幕和自动生成的字幕",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/ytdlp.SubtitleTrack"
+                    }
+                },
                 "tags": {
                     "description": "标签",
                     "type": "array",
@@ -458,6 +1762,12 @@ const docTemplate = `{
         }
     },
     "securityDefinitions": {
+        "AdminToken": {
+            "description": "管理接口令牌，格式为 Bearer \u003cadmin_token\u003e",
+            "type": "apiKey",
+            "name": "Authorization",
+            "in": "header"
+        },
         "BasicAuth": {
             "type": "basic"
         }
@@ -1,7 +1,15 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"path"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -9,6 +17,8 @@ import (
 
 	"github.com/self-made-boy/youtube-tools/internal/api/response"
 	"github.com/self-made-boy/youtube-tools/internal/config"
+	"github.com/self-made-boy/youtube-tools/internal/metrics"
+	"github.com/self-made-boy/youtube-tools/internal/storage"
 	"github.com/self-made-boy/youtube-tools/internal/ytdlp"
 )
 
@@ -17,16 +27,18 @@ type Handler struct {
 	config    *config.Config
 	logger    *zap.Logger
 	ytdlp     *ytdlp.Service
+	binaries  ytdlp.Binaries
 	version   string
 	startTime time.Time
 }
 
 // New 创建一个新的处理器
-func New(cfg *config.Config, logger *zap.Logger, ytdlpService *ytdlp.Service) *Handler {
+func New(cfg *config.Config, logger *zap.Logger, ytdlpService *ytdlp.Service, binaries ytdlp.Binaries) *Handler {
 	return &Handler{
 		config:    cfg,
 		logger:    logger,
 		ytdlp:     ytdlpService,
+		binaries:  binaries,
 		version:   "1.0.0",
 		startTime: time.Now(),
 	}
@@ -34,33 +46,98 @@ func New(cfg *config.Config, logger *zap.Logger, ytdlpService *ytdlp.Service) *H
 
 // 使用 response 包中的 Response 结构体
 
+// HealthCheckResp 表示健康检查的响应
+type HealthCheckResp struct {
+	// 服务版本
+	Version string `json:"version" example:"1.0.0"`
+	// 运行时长
+	Uptime string `json:"uptime" example:"1h2m3s"`
+	// 启动时检测到的外部程序
+	Binaries ytdlp.Binaries `json:"binaries"`
+}
+
 // HealthCheck 处理健康检查请求
 // @Summary 健康检查
-// @Description 获取 API 服务的健康状态
+// @Description 获取 API 服务的健康状态及启动时检测到的 yt-dlp、ffmpeg 版本
 // @Tags 系统
 // @Produce json
-// @Success 200 {object} response.Response
+// @Success 200 {object} response.Response{data=HealthCheckResp}
 // @Router /health [get]
 func (h *Handler) HealthCheck(c *gin.Context) {
-	uptime := time.Since(h.startTime).String()
+	response.Success(c, HealthCheckResp{
+		Version:  h.version,
+		Uptime:   time.Since(h.startTime).String(),
+		Binaries: h.binaries,
+	})
+}
 
+// Healthz 处理存活探针请求
+// @Summary 存活检查
+// @Description 进程能够处理请求即返回 200，不检查外部依赖，用于 livenessProbe
+// @Tags 系统
+// @Produce json
+// @Success 200 {object} response.Response
+// @Router /healthz [get]
+func (h *Handler) Healthz(c *gin.Context) {
 	response.Success(c, map[string]string{
-		"version": h.version,
-		"uptime":  uptime,
+		"status": "ok",
 	})
 }
 
+// Readyz 处理就绪探针请求
+// @Summary 就绪检查
+// @Description 检查 yt-dlp 和 ffmpeg 能否执行、存储可用、download_dir 可写且剩余空间充足、cookies 文件可读、等待队列未满，
+// @Description 任一检查失败时返回 503，响应中包含每项检查的结果，用于 readinessProbe
+// @Tags 系统
+// @Produce json
+// @Success 200 {object} response.Response{data=ytdlp.ReadinessReport}
+// @Failure 503 {object} response.Response{data=ytdlp.ReadinessReport}
+// @Router /readyz [get]
+func (h *Handler) Readyz(c *gin.Context) {
+	report := h.ytdlp.CheckReadiness()
+	if !report.Ready {
+		c.JSON(http.StatusServiceUnavailable, response.Response{
+			Code:    response.NOT_READY,
+			Message: response.GetMessage(response.NOT_READY),
+			Data:    report,
+		})
+		return
+	}
+	response.Success(c, report)
+}
+
+// Metrics 以 Prometheus 文本格式输出指标，采集时更新队列长度和各状态的任务数
+func (h *Handler) Metrics(c *gin.Context) {
+	_, pending, downloading, completed, failed, cancelled := h.ytdlp.GetActiveTasksCount()
+	metrics.SetTasks(map[string]int{
+		"pending":     pending,
+		"downloading": downloading,
+		"completed":   completed,
+		"failed":      failed,
+		"cancelled":   cancelled,
+	})
+	metrics.SetQueueDepth(h.ytdlp.GetQueueLength())
+
+	metrics.Handler().ServeHTTP(c.Writer, c.Request)
+}
+
 // GetVideoInfoRequest 表示获取视频信息的请求
 type GetVideoInfoRequest struct {
 	URL string `form:"url" binding:"required"`
+	// 逗号分隔的格式排序规则，为空时使用配置的规则
+	Policy string `form:"policy"`
+	// 为 true 时忽略缓存，重新获取视频信息
+	Refresh bool `form:"refresh"`
 }
 
 // GetVideoInfo 处理获取视频信息请求
 // @Summary 获取视频信息
-// @Description 获取指定 URL 的视频信息
+// @Description 获取指定 URL 的视频信息，缓存超过 info_cache_ttl 或指定 refresh 时重新获取
 // @Tags youtube
 // @Produce json
 // @Param url query string true "视频 URL"
+// @Param policy query string false "格式排序规则，逗号分隔，可选 quality、compatible、smallest、original_language"
+// @Param refresh query bool false "忽略缓存，重新获取视频信息"
 // @Success 200 {object} response.Response{data=ytdlp.VideoInfo}
 // @Failure 400 {object} response.Response
 // @Failure 500 {object} response.Response
@@ -72,15 +149,25 @@ func (h *Handler) GetVideoInfo(c *gin.Context) {
 		return
 	}
 	// 检查URL是否有效
-	url, _, err := h.ytdlp.CheckUrl(req.URL)
+	url, _, err := h.ytdlp.CheckVideoUrl(req.URL)
+	if err != nil {
+		response.BadRequest(c, response.INVALID_REQUEST, err)
+		return
+	}
+
+	policy, err := ytdlp.ParseFormatPolicy(req.Policy)
 	if err != nil {
 		response.BadRequest(c, response.INVALID_REQUEST, err)
 		return
 	}
 
 	// 获取视频信息
-	info, err := h.ytdlp.GetVideoInfo(url)
+	info, err := h.ytdlp.GetVideoInfo(url, policy, req.Refresh)
 	if err != nil {
+		if errors.Is(err, ytdlp.ErrVideoUnavailable) {
+			response.BadRequest(c, response.VIDEO_INFO_ERROR, err)
+			return
+		}
 		response.Fail(c, http.StatusInternalServerError, response.VIDEO_INFO_ERROR, err)
 		return
 	}
@@ -93,8 +180,16 @@ func (h *Handler) GetVideoInfo(c *gin.Context) {
 type StartDownloadRequest struct {
 	// 下载的url
 	URL string `json:"url" binding:"required"`
-	// 下载的格式
+	// 下载的格式，可以是 /info 返回的音频、视频或字幕格式ID
 	FormatId string `json:"format_id" binding:"omitempty"`
+	// 任务结束时回调的地址，会收到带签名的 JSON 通知，不能指向内网地址，配置了 allowed_hosts 时必须在列表中
+	CallbackURL string `json:"callback_url" binding:"omitempty,url" example:"https://example.com/hooks/yt"`
+	// 片段开始时间，秒数或 HH:MM:SS，为空时从头开始
+	Start string `json:"start" binding:"omitempty" example:"00:01:00"`
+	// 片段结束时间，秒数或 HH:MM:SS，为空时到视频结尾
+	End string `json:"end" binding:"omitempty" example:"90"`
+	// 转码预设名称，为空时不使用预设
+	Preset string `json:"preset" binding:"omitempty" example:"mobile-480p"`
 }
 
 // StartDownloadResp 表示开始下载的响应
@@ -104,13 +199,16 @@ type StartDownloadResp struct {
 
 // StartDownload 处理开始下载请求
 // @Summary 开始下载视频
-// @Description 开始下载指定 URL 的视频
+// @Description 开始下载指定 URL 的视频，使用字幕格式ID时只下载字幕文件。
+// @Description 指定 start/end 时只下载该片段，片段保存在独立的路径下，任务ID也与完整视频不同。
+// @Description 指定 preset 时按配置的转码预设编码，不同预设的结果同样保存在独立的路径下。
 // @Tags youtube
 // @Accept json
 // @Produce json
 // @Param request body StartDownloadRequest true "下载请求"
 // @Success 200 {object} response.Response{data=StartDownloadResp}
 // @Failure 400 {object} response.Response
+// @Failure 413 {object} response.Response
 // @Failure 500 {object} response.Response
 // @Router /download [post]
 func (h *Handler) StartDownload(c *gin.Context) {
@@ -121,20 +219,58 @@ func (h *Handler) StartDownload(c *gin.Context) {
 	}
 
 	// 检查URL是否有效
-	_, _, err := h.ytdlp.CheckUrl(req.URL)
+	url, _, err := h.ytdlp.CheckVideoUrl(req.URL)
 	if err != nil {
 		response.BadRequest(c, response.INVALID_REQUEST, err)
 		return
 	}
-	_, _, _, audioErr := h.ytdlp.ParseAudioFormatID(req.FormatId)
-	_, _, _, videoErr := h.ytdlp.ParseVideoFormatID(req.FormatId)
-	if audioErr != nil && videoErr != nil {
-		response.BadRequest(c, response.INVALID_REQUEST, videoErr)
+	if h.ytdlp.IsSubtitleFormatID(req.FormatId) {
+		if _, _, _, err := h.ytdlp.ParseSubtitleFormatID(req.FormatId); err != nil {
+			response.BadRequest(c, response.INVALID_REQUEST, err)
+			return
+		}
+	} else {
+		_, _, _, audioErr := h.ytdlp.ParseAudioFormatID(req.FormatId)
+		_, _, _, videoErr := h.ytdlp.ParseVideoFormatID(req.FormatId)
+		if audioErr != nil && videoErr != nil {
+			response.BadRequest(c, response.INVALID_REQUEST, videoErr)
+			return
+		}
+	}
+	clip, err := ytdlp.ParseClipRange(req.Start, req.End)
+	if err != nil {
+		response.BadRequest(c, response.INVALID_CLIP, err)
 		return
 	}
+
 	// 开始下载
-	taskID, err := h.ytdlp.StartDownload(req.URL, req.FormatId)
+	taskID, err := h.ytdlp.StartDownload(url, req.FormatId, ytdlp.DownloadOptions{
+		CallbackURL: req.CallbackURL,
+		Clip:        clip,
+		Preset:      req.Preset,
+	})
 	if err != nil {
+		if errors.Is(err, ytdlp.ErrInvalidClip) {
+			response.BadRequest(c, response.INVALID_CLIP, err)
+			return
+		}
+		if errors.Is(err, ytdlp.ErrInvalidPreset) {
+			response.BadRequest(c, response.INVALID_PRESET, err)
+			return
+		}
+		if errors.Is(err, ytdlp.ErrInvalidCallbackURL) {
+			response.BadRequest(c, response.INVALID_CALLBACK, err)
+			return
+		}
+		if errors.Is(err, ytdlp.ErrFileTooLarge) {
+			response.Fail(c, http.StatusRequestEntityTooLarge, response.FILE_TOO_LARGE, err)
+			return
+		}
+		// 预估大小或解析片段时获取视频信息失败，与 /info 相同处理
+		if errors.Is(err, ytdlp.ErrVideoUnavailable) {
+			response.BadRequest(c, response.VIDEO_INFO_ERROR, err)
+			return
+		}
 		response.Fail(c, http.StatusInternalServerError, response.DOWNLOAD_ERROR, err)
 		return
 	}
@@ -149,13 +285,29 @@ type DownloadTaskStatusResp struct {
 	// 任务ID
 	TaskID string `json:"task_id" example:"123456"`
 	// 下载状态
-	State string `json:"state" example:"pending, downloading, completed, failed"`
+	State string `json:"state" example:"pending, downloading, completed, failed, cancelled"`
 	// 下载进度
 	Progress float64 `json:"progress" example:"0.5"`
 	// 预计时间
 	ETA string `json:"eta" example:"10s"`
+	// 排队位置，从 1 开始，0 表示未在排队
+	QueuePosition int `json:"queue_position" example:"3"`
 	// 下载文件路径
 	DownloadUrl string `json:"download_url" example:"https://xxx.com/123456.m4a"`
+	// 开启签名下载地址时下载地址的过期时间，每次查询都会生成新的地址
+	DownloadUrlExpiresAt *time.Time `json:"download_url_expires_at,omitempty"`
+	// 错误信息
+	Error string `json:"error,omitempty" example:"Download failed: exit status 1"`
+	// 错误码，FILE_TOO_LARGE：文件超过大小限制；FORMAT_UNAVAILABLE：所选格式已不存在，需要重新获取视频信息
+	ErrorCode string `json:"error_code,omitempty" example:"FILE_TOO_LARGE"`
+	// 片段的起止时间，下载完整视频时为空
+	Clip *ytdlp.ClipRange `json:"clip,omitempty"`
+	// 使用的转码预设名称
+	Preset string `json:"preset,omitempty" example:"mobile-480p"`
+	// 下载后对音视频流的处理方式，copy 表示直接复制，transcode 表示转码
+	Processing *ytdlp.ProcessingDecision `json:"processing,omitempty"`
+	// 回调投递记录
+	Callbacks []ytdlp.CallbackAttempt `json:"callbacks,omitempty"`
 }
 
 // GetDownloadStatus 处理获取下载状态请求
@@ -183,11 +335,583 @@ func (h *Handler) GetDownloadStatus(c *gin.Context) {
 		return
 	}
 
-	response.Success(c, DownloadTaskStatusResp{
-		TaskID:      task.ID,
-		State:       task.State,
-		Progress:    task.Progress,
-		ETA:         task.ETA,
-		DownloadUrl: task.DownloadUrl,
+	response.Success(c, h.buildTaskStatusResp(task))
+}
+
+// buildTaskStatusResp 根据下载任务构建状态响应
+func (h *Handler) buildTaskStatusResp(task *ytdlp.DownloadTask) DownloadTaskStatusResp {
+	downloadUrl, expiresAt := h.ytdlp.GetDownloadURL(task)
+	return DownloadTaskStatusResp{
+		TaskID:               task.ID,
+		State:                task.State,
+		Progress:             task.Progress,
+		ETA:                  task.ETA,
+		QueuePosition:        h.ytdlp.GetQueuePosition(task.ID),
+		DownloadUrl:          downloadUrl,
+		DownloadUrlExpiresAt: expiresAt,
+		Error:                task.Error,
+		ErrorCode:            task.ErrorCode,
+		Clip:                 task.Clip,
+		Preset:               task.Preset,
+		Processing:           task.Processing,
+		Callbacks:            task.Callbacks,
+	}
+}
+
+// sseHeartbeatInterval SSE 心跳注释的发送间隔，避免代理因连接空闲而断开
+const sseHeartbeatInterval = 15 * time.Second
+
+// StreamDownloadEvents 处理下载进度事件流请求
+// @Summary 订阅下载进度
+// @Description 以 Server-Sent Events 推送指定任务的状态变化，任务结束时发送以最终状态命名的事件（completed、failed、cancelled）后关闭连接。
+// @Description 每个事件的 id 为任务的 revision，断线重连时通过 Last-Event-ID 头避免重复推送。
+// @Tags youtube
+// @Produce text/event-stream
+// @Param task_id query string true "任务 ID"
+// @Param Last-Event-ID header string false "上次收到的事件 ID"
+// @Success 200 {object} DownloadTaskStatusResp
+// @Failure 400 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Router /download/events [get]
+func (h *Handler) StreamDownloadEvents(c *gin.Context) {
+	taskID := c.Query("task_id")
+
+	if taskID == "" {
+		response.FailWithMessage(c, http.StatusBadRequest, response.INVALID_TASK_ID, "Task ID is required")
+		return
+	}
+
+	// 订阅任务状态变化
+	task, events, unsubscribe, err := h.ytdlp.SubscribeTask(taskID)
+	if err != nil {
+		response.NotFound(c, response.TASK_NOT_FOUND, err)
+		return
+	}
+	defer unsubscribe()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	// 立即发送响应头，重连后没有新事件时客户端也能确认连接已建立
+	c.Writer.Flush()
+
+	// 客户端已经收到的最后一个事件
+	lastEventID, _ := strconv.ParseInt(c.GetHeader("Last-Event-ID"), 10, 64)
+
+	// 先推送当前状态，客户端重连时如果已经收到过则跳过
+	if task.Revision != lastEventID {
+		if finished := h.writeTaskEvent(c, task); finished {
+			return
+		}
+	} else if ytdlp.IsTaskFinished(task) {
+		return
+	}
+	lastSent := task.Revision
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := io.WriteString(c.Writer, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		case event := <-events:
+			if event.Revision <= lastSent {
+				continue
+			}
+			lastSent = event.Revision
+			if finished := h.writeTaskEvent(c, &event); finished {
+				return
+			}
+		}
+	}
+}
+
+// writeTaskEvent 写入一个 SSE 事件，返回任务是否已结束
+func (h *Handler) writeTaskEvent(c *gin.Context, task *ytdlp.DownloadTask) bool {
+	finished := ytdlp.IsTaskFinished(task)
+	event := "progress"
+	if finished {
+		event = task.State
+	}
+
+	data, err := json.Marshal(h.buildTaskStatusResp(task))
+	if err != nil {
+		h.logger.Error("Failed to marshal task event", zap.String("task_id", task.ID), zap.Error(err))
+		return true
+	}
+
+	fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", task.Revision, event, data)
+	c.Writer.Flush()
+	return finished
+}
+
+// CancelDownload 处理取消下载请求
+// @Summary 取消下载
+// @Description 取消指定任务 ID 的下载，结束 yt-dlp 及 ffmpeg 进程并清理未完成的文件
+// @Tags youtube
+// @Produce json
+// @Param task_id query string true "任务 ID"
+// @Success 200 {object} response.Response{data=DownloadTaskStatusResp}
+// @Failure 400 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Failure 409 {object} response.Response
+// @Router /download [delete]
+func (h *Handler) CancelDownload(c *gin.Context) {
+	taskID := c.Query("task_id")
+
+	if taskID == "" {
+		response.FailWithMessage(c, http.StatusBadRequest, response.INVALID_TASK_ID, "Task ID is required")
+		return
+	}
+
+	// 取消下载
+	task, err := h.ytdlp.CancelDownload(taskID)
+	if err != nil {
+		if errors.Is(err, ytdlp.ErrTaskNotCancellable) {
+			response.Fail(c, http.StatusConflict, response.TASK_NOT_CANCELLABLE, err)
+			return
+		}
+		response.NotFound(c, response.TASK_NOT_FOUND, err)
+		return
+	}
+
+	response.Success(c, h.buildTaskStatusResp(task))
+}
+
+// ServeSignedFile 处理签名下载地址的文件下载请求
+// @Summary 下载文件
+// @Description 使用本地存储并开启 signed_url 时，下载地址指向该接口，签名错误或过期时返回 403。支持 Range 请求
+// @Tags youtube
+// @Produce octet-stream
+// @Param key path string true "文件路径"
+// @Param expires query int true "过期时间，Unix 秒"
+// @Param signature query string true "签名"
+// @Success 200 {file} file
+// @Success 206 {file} file
+// @Failure 403 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Router /files/{key} [get]
+func (h *Handler) ServeSignedFile(c *gin.Context) {
+	key := strings.TrimPrefix(c.Param("key"), "/")
+
+	reader, info, err := h.ytdlp.OpenSignedFile(c.Request.Context(), key, c.Query("expires"), c.Query("signature"))
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrURLExpired):
+			response.Fail(c, http.StatusForbidden, response.URL_EXPIRED, err)
+		case errors.Is(err, storage.ErrInvalidSignature):
+			response.Fail(c, http.StatusForbidden, response.INVALID_SIGNATURE, err)
+		case errors.Is(err, storage.ErrNotFound):
+			response.NotFound(c, response.FILE_NOT_FOUND, err)
+		default:
+			response.ServerError(c, err)
+		}
+		return
+	}
+	defer reader.Close()
+
+	serveObject(c, reader, info, ytdlp.ContentTypeByExt(path.Ext(key)))
+}
+
+// DownloadFile 处理下载任务结果文件请求
+// @Summary 下载任务结果文件
+// @Description 直接从存储中读取已完成任务的结果文件，支持 Range、If-None-Match、If-Modified-Since 等请求头，播放器可以拖动进度。
+// @Description 文件名由视频标题生成，inline 为 true 时浏览器直接播放而不是保存。
+// @Description 开启 signed_url 时需要带上任务下载地址中的 expires 和 signature，签名错误或过期时返回 403；使用 s3 存储时只能通过预签名地址下载
+// @Tags youtube
+// @Produce octet-stream
+// @Param task_id query string true "任务 ID"
+// @Param expires query int false "过期时间，Unix 秒，开启 signed_url 时必填"
+// @Param signature query string false "签名，开启 signed_url 时必填"
+// @Param inline query bool false "使用 inline 而不是 attachment 的 Content-Disposition"
+// @Param Range header string false "请求的字节范围，如 bytes=0-1023"
+// @Success 200 {file} file
+// @Success 206 {file} file
+// @Success 304 "文件未变化"
+// @Failure 400 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Failure 409 {object} response.Response
+// @Failure 416 "请求的范围无效"
+// @Router /download/file [get]
+func (h *Handler) DownloadFile(c *gin.Context) {
+	taskID := c.Query("task_id")
+
+	if taskID == "" {
+		response.FailWithMessage(c, http.StatusBadRequest, response.INVALID_TASK_ID, "Task ID is required")
+		return
+	}
+
+	file, err := h.ytdlp.OpenTaskFile(c.Request.Context(), taskID, c.Query("expires"), c.Query("signature"))
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrURLExpired):
+			response.Fail(c, http.StatusForbidden, response.URL_EXPIRED, err)
+		case errors.Is(err, storage.ErrInvalidSignature):
+			response.Fail(c, http.StatusForbidden, response.INVALID_SIGNATURE, err)
+		case errors.Is(err, ytdlp.ErrTaskNotFound):
+			response.NotFound(c, response.TASK_NOT_FOUND, err)
+		case errors.Is(err, ytdlp.ErrTaskNotCompleted):
+			response.Fail(c, http.StatusConflict, response.TASK_NOT_COMPLETED, err)
+		case errors.Is(err, storage.ErrNotFound):
+			response.NotFound(c, response.FILE_NOT_FOUND, err)
+		default:
+			response.ServerError(c, err)
+		}
+		return
+	}
+	defer file.Reader.Close()
+
+	disposition := "attachment"
+	if c.Query("inline") == "true" {
+		disposition = "inline"
+	}
+	// 非 ASCII 的文件名按 RFC 2231 编码为 filename*
+	if value := mime.FormatMediaType(disposition, map[string]string{"filename": file.Filename}); value != "" {
+		c.Header("Content-Disposition", value)
+	} else {
+		c.Header("Content-Disposition", disposition)
+	}
+
+	serveObject(c, file.Reader, file.Info, file.ContentType)
+}
+
+// StreamDownloadRequest 表示流式下载的请求
+type StreamDownloadRequest struct {
+	URL      string `form:"url" binding:"required"`
+	FormatId string `form:"format_id" binding:"required"`
+	Inline   bool   `form:"inline"`
+}
+
+// StreamDownload 处理流式下载请求
+// @Summary 流式下载音频
+// @Description 同步下载音频并以分块传输直接返回给客户端，结果不写入存储，适合一次性获取音频。
+// @Description 需要转换格式时通过 ffmpeg 转换后输出，客户端断开时立即结束下载。响应不支持 Range，开始输出后出错时连接会被中断。
+// @Tags youtube
+// @Produce octet-stream
+// @Param url query string true "视频 URL"
+// @Param format_id query string true "音频格式 ID"
+// @Param inline query bool false "使用 inline 而不是 attachment 的 Content-Disposition"
+// @Success 200 {file} file
+// @Failure 400 {object} response.Response
+// @Failure 413 {object} response.Response
+// @Failure 429 {object} response.Response
+// @Failure 500 {object} response.Response
+// @Router /download/stream [get]
+func (h *Handler) StreamDownload(c *gin.Context) {
+	var req StreamDownloadRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		response.BadRequest(c, response.INVALID_REQUEST, err)
+		return
+	}
+
+	url, _, err := h.ytdlp.CheckVideoUrl(req.URL)
+	if err != nil {
+		response.BadRequest(c, response.INVALID_REQUEST, err)
+		return
+	}
+	if _, _, _, err := h.ytdlp.ParseAudioFormatID(req.FormatId); err != nil {
+		response.BadRequest(c, response.INVALID_REQUEST, err)
+		return
+	}
+
+	stream, err := h.ytdlp.OpenAudioStream(c.Request.Context(), url, req.FormatId)
+	if err != nil {
+		switch {
+		case errors.Is(err, ytdlp.ErrStreamNotSupported):
+			response.BadRequest(c, response.INVALID_REQUEST, err)
+		case errors.Is(err, ytdlp.ErrFileTooLarge):
+			response.Fail(c, http.StatusRequestEntityTooLarge, response.FILE_TOO_LARGE, err)
+		case errors.Is(err, ytdlp.ErrTooManyStreams):
+			response.Fail(c, http.StatusTooManyRequests, response.TOO_MANY_STREAMS, err)
+		default:
+			response.Fail(c, http.StatusInternalServerError, response.DOWNLOAD_ERROR, err)
+		}
+		return
+	}
+
+	disposition := "attachment"
+	if req.Inline {
+		disposition = "inline"
+	}
+	if value := mime.FormatMediaType(disposition, map[string]string{"filename": stream.Filename}); value != "" {
+		c.Header("Content-Disposition", value)
+	} else {
+		c.Header("Content-Disposition", disposition)
+	}
+	c.Header("Content-Type", stream.ContentType)
+	c.Header("Cache-Control", "no-store")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	// 已确认有输出，立即发送响应头，播放器不必等待缓冲区写满
+	c.Writer.Flush()
+
+	written, copyErr := io.Copy(c.Writer, stream)
+	closeErr := stream.Close()
+	// 客户端已断开，子进程已随请求上下文结束
+	if c.Request.Context().Err() != nil {
+		h.logger.Info("Stream client disconnected",
+			zap.String("url", url),
+			zap.Int64("bytes", written))
+		return
+	}
+	if err := errors.Join(copyErr, closeErr); err != nil {
+		h.logger.Error("Stream aborted",
+			zap.String("url", url),
+			zap.String("format", req.FormatId),
+			zap.Int64("bytes", written),
+			zap.Error(err))
+		abortResponse(c)
+		return
+	}
+	h.logger.Info("Stream completed",
+		zap.String("url", url),
+		zap.String("format", req.FormatId),
+		zap.Int64("bytes", written))
+}
+
+// abortResponse 直接关闭连接，不发送分块传输的结束标记，客户端能够发现响应不完整
+func abortResponse(c *gin.Context) {
+	hijacker, ok := c.Writer.(http.Hijacker)
+	if !ok {
+		return
+	}
+	conn, _, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	conn.Close()
+}
+
+// serveObject 输出存储中的对象，由 http.ServeContent 处理 Range 和条件请求
+func serveObject(c *gin.Context, reader io.ReadSeeker, info storage.ObjectInfo, contentType string) {
+	if info.ETag != "" {
+		c.Header("ETag", info.ETag)
+	}
+	c.Header("Content-Type", contentType)
+	http.ServeContent(c.Writer, c.Request, path.Base(info.Key), info.ModTime, reader)
+}
+
+// GetPlaylistInfoRequest 表示获取播放列表信息的请求
+type GetPlaylistInfoRequest struct {
+	URL      string `form:"url" binding:"required"`
+	Page     int    `form:"page" binding:"omitempty,min=1"`
+	PageSize int    `form:"page_size" binding:"omitempty,min=1,max=200"`
+}
+
+// GetPlaylistInfo 处理获取播放列表信息请求
+// @Summary 获取播放列表信息
+// @Description 展开播放列表或频道链接，分页返回其中的视频
+// @Tags youtube
+// @Produce json
+// @Param url query string true "播放列表或频道 URL"
+// @Param page query int false "页码，从 1 开始" default(1)
+// @Param page_size query int false "每页条目数，最大 200" default(50)
+// @Success 200 {object} response.Response{data=ytdlp.PlaylistInfo}
+// @Failure 400 {object} response.Response
+// @Failure 500 {object} response.Response
+// @Router /playlist [get]
+func (h *Handler) GetPlaylistInfo(c *gin.Context) {
+	var req GetPlaylistInfoRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		response.BadRequest(c, response.INVALID_REQUEST, err)
+		return
+	}
+	if req.Page == 0 {
+		req.Page = 1
+	}
+	if req.PageSize == 0 {
+		req.PageSize = 50
+	}
+
+	// 检查URL是否有效
+	url, _, err := h.ytdlp.CheckPlaylistUrl(req.URL)
+	if err != nil {
+		response.BadRequest(c, response.INVALID_REQUEST, err)
+		return
+	}
+
+	// 获取播放列表信息
+	info, err := h.ytdlp.GetPlaylistInfo(url, req.Page, req.PageSize)
+	if err != nil {
+		response.Fail(c, http.StatusInternalServerError, response.PLAYLIST_INFO_ERROR, err)
+		return
+	}
+
+	response.Success(c, info)
+}
+
+// StartPlaylistDownloadRequest 表示批量下载播放列表的请求
+type StartPlaylistDownloadRequest struct {
+	// 播放列表或频道的url
+	URL string `json:"url" binding:"required"`
+	// 下载类型，audio 或 video
+	Type string `json:"type" binding:"required,oneof=audio video" example:"audio"`
+	// 目标文件扩展名
+	Ext string `json:"ext" binding:"required" example:"mp3"`
+	// 视频最大高度，0 表示最高画质
+	MaxHeight int `json:"max_height" binding:"omitempty,min=0" example:"720"`
+	// 最多下载的条目数，0 表示全部
+	Limit int `json:"limit" binding:"omitempty,min=0" example:"20"`
+	// 每个任务结束时回调的地址，限制与单个下载相同
+	CallbackURL string `json:"callback_url" binding:"omitempty,url" example:"https://example.com/hooks/yt"`
+	// 转码预设名称，应用到每个任务上
+	Preset string `json:"preset" binding:"omitempty" example:"podcast-64k-mono"`
+}
+
+// StartPlaylistDownloadResp 表示批量下载播放列表的响应
+type StartPlaylistDownloadResp struct {
+	Tasks []ytdlp.PlaylistDownloadItem `json:"tasks"`
+}
+
+// StartPlaylistDownload 处理批量下载播放列表请求
+// @Summary 批量下载播放列表
+// @Description 为播放列表或频道中的每个视频按格式偏好创建一个下载任务
+// @Tags youtube
+// @Accept json
+// @Produce json
+// @Param request body StartPlaylistDownloadRequest true "批量下载请求"
+// @Success 200 {object} response.Response{data=StartPlaylistDownloadResp}
+// @Failure 400 {object} response.Response
+// @Failure 500 {object} response.Response
+// @Router /playlist/download [post]
+func (h *Handler) StartPlaylistDownload(c *gin.Context) {
+	var req StartPlaylistDownloadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, response.INVALID_REQUEST, err)
+		return
+	}
+
+	// 检查URL是否有效
+	url, _, err := h.ytdlp.CheckPlaylistUrl(req.URL)
+	if err != nil {
+		response.BadRequest(c, response.INVALID_REQUEST, err)
+		return
+	}
+
+	pref := ytdlp.PlaylistFormatPreference{
+		Type:      req.Type,
+		Ext:       req.Ext,
+		MaxHeight: req.MaxHeight,
+	}
+	if _, err := h.ytdlp.BuildPreferredFormatID(pref); err != nil {
+		response.BadRequest(c, response.INVALID_REQUEST, err)
+		return
+	}
+
+	// 为每个视频创建下载任务
+	tasks, err := h.ytdlp.StartPlaylistDownload(url, pref, req.Limit, ytdlp.DownloadOptions{
+		CallbackURL: req.CallbackURL,
+		Preset:      req.Preset,
+	})
+	if err != nil {
+		if errors.Is(err, ytdlp.ErrInvalidPreset) {
+			response.BadRequest(c, response.INVALID_PRESET, err)
+			return
+		}
+		if errors.Is(err, ytdlp.ErrInvalidCallbackURL) {
+			response.BadRequest(c, response.INVALID_CALLBACK, err)
+			return
+		}
+		response.Fail(c, http.StatusInternalServerError, response.PLAYLIST_INFO_ERROR, err)
+		return
+	}
+
+	response.Success(c, StartPlaylistDownloadResp{
+		Tasks: tasks,
+	})
+}
+
+// PurgeVideoCacheResp 表示删除视频信息缓存的响应
+type PurgeVideoCacheResp struct {
+	VideoID string `json:"video_id" example:"dQw4w9WgXcQ"`
+	// 缓存是否存在并已删除
+	Purged bool `json:"purged" example:"true"`
+}
+
+// PurgeVideoCache 处理删除视频信息缓存请求
+// @Summary 删除视频信息缓存
+// @Description 删除指定视频缓存的信息，下次获取信息或下载时重新执行 yt-dlp。
+// @Description 配置了 admin_token 时需要在 Authorization 头中携带 Bearer 令牌
+// @Tags 管理
+// @Produce json
+// @Security AdminToken
+// @Param url query string true "视频 URL"
+// @Success 200 {object} response.Response{data=PurgeVideoCacheResp}
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 500 {object} response.Response
+// @Router /admin/cache [delete]
+func (h *Handler) PurgeVideoCache(c *gin.Context) {
+	rawURL := c.Query("url")
+	if rawURL == "" {
+		response.FailWithMessage(c, http.StatusBadRequest, response.INVALID_REQUEST, "URL is required")
+		return
+	}
+
+	_, videoID, err := h.ytdlp.CheckVideoUrl(rawURL)
+	if err != nil {
+		response.BadRequest(c, response.INVALID_REQUEST, err)
+		return
+	}
+
+	purged, err := h.ytdlp.PurgeVideoInfo(videoID)
+	if err != nil {
+		response.ServerError(c, err)
+		return
+	}
+
+	response.Success(c, PurgeVideoCacheResp{
+		VideoID: videoID,
+		Purged:  purged,
 	})
 }
+
+// GetRetentionReport 处理存储清理试运行请求
+// @Summary 存储清理试运行
+// @Description 按 retention 配置计算将被清理的文件但不删除，包括超过 max_age 未访问的文件、超过 max_total_size 时将被淘汰的文件，
+// @Description 以及 download_dir 中中断的下载留下的临时文件。
+// @Description 配置了 admin_token 时需要在 Authorization 头中携带 Bearer 令牌
+// @Tags 管理
+// @Produce json
+// @Security AdminToken
+// @Success 200 {object} response.Response{data=ytdlp.RetentionReport}
+// @Failure 401 {object} response.Response
+// @Failure 500 {object} response.Response
+// @Router /admin/storage/gc [get]
+func (h *Handler) GetRetentionReport(c *gin.Context) {
+	report, err := h.ytdlp.RunRetention(c.Request.Context(), true)
+	if err != nil {
+		response.ServerError(c, err)
+		return
+	}
+	response.Success(c, report)
+}
+
+// RunRetention 处理立即执行存储清理请求
+// @Summary 立即执行存储清理
+// @Description 立即按 retention 配置清理存储和 download_dir，返回实际删除的文件，删除失败的文件带有错误信息。
+// @Description 配置了 admin_token 时需要在 Authorization 头中携带 Bearer 令牌
+// @Tags 管理
+// @Produce json
+// @Security AdminToken
+// @Success 200 {object} response.Response{data=ytdlp.RetentionReport}
+// @Failure 401 {object} response.Response
+// @Failure 500 {object} response.Response
+// @Router /admin/storage/gc [post]
+func (h *Handler) RunRetention(c *gin.Context) {
+	report, err := h.ytdlp.RunRetention(c.Request.Context(), false)
+	if err != nil {
+		response.ServerError(c, err)
+		return
+	}
+	response.Success(c, report)
+}
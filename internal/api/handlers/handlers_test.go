@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/self-made-boy/youtube-tools/internal/config"
+	"github.com/self-made-boy/youtube-tools/internal/storage"
+	"github.com/self-made-boy/youtube-tools/internal/utils"
+	"github.com/self-made-boy/youtube-tools/internal/ytdlp"
+)
+
+// completedTaskID 测试中已完成任务的ID
+var completedTaskID = utils.ToHex("done/audio/48000/done.mp3")
+
+// newTestRouter 创建注册了事件流和指标接口的路由，任务存储中预先保存一个 revision 为 3 的已完成任务
+// 模拟的 yt-dlp 一直运行到被取消
+func newTestRouter(t *testing.T) (*gin.Engine, *ytdlp.Service) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake yt-dlp requires a POSIX shell")
+	}
+
+	dir := t.TempDir()
+	ytdlpPath := filepath.Join(dir, "yt-dlp")
+	if err := os.WriteFile(ytdlpPath, []byte("#!/bin/sh\nexec sleep 30\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	taskStoreDir := filepath.Join(dir, "tasks")
+	store, err := ytdlp.NewFileTaskStore(taskStoreDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save(&ytdlp.DownloadTask{
+		ID:        completedTaskID,
+		URL:       "https://www.youtube.com/watch?v=done",
+		State:     "completed",
+		Progress:  100,
+		StartTime: time.Now().Add(-time.Minute),
+		EndTime:   time.Now(),
+		Revision:  3,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{Ytdlp: config.YtdlpConfig{
+		Path:         ytdlpPath,
+		DownloadDir:  filepath.Join(dir, "work"),
+		TaskStoreDir: taskStoreDir,
+	}}
+	service := ytdlp.New(cfg, zap.NewNop(), storage.NewLocal(filepath.Join(dir, "storage"), ""))
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	h := New(cfg, zap.NewNop(), service, ytdlp.Binaries{})
+	router.GET("/download/events", h.StreamDownloadEvents)
+	router.GET("/metrics", h.Metrics)
+	return router, service
+}
+
+// sseEvent 表示事件流中的一个事件
+type sseEvent struct {
+	id    int64
+	event string
+}
+
+// readEvent 读取下一个事件，跳过心跳注释，连接关闭时返回 io.EOF
+func readEvent(reader *bufio.Reader) (sseEvent, error) {
+	var ev sseEvent
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return ev, err
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case line == "" && ev.event != "":
+			return ev, nil
+		case strings.HasPrefix(line, "id: "):
+			ev.id, _ = strconv.ParseInt(strings.TrimPrefix(line, "id: "), 10, 64)
+		case strings.HasPrefix(line, "event: "):
+			ev.event = strings.TrimPrefix(line, "event: ")
+		}
+	}
+}
+
+// TestHandler_StreamDownloadEvents_Finished 测试已结束任务推送最终状态后关闭，以及按 Last-Event-ID 跳过已收到的事件
+func TestHandler_StreamDownloadEvents_Finished(t *testing.T) {
+	router, _ := newTestRouter(t)
+
+	tests := []struct {
+		name           string
+		taskID         string
+		lastEventID    string
+		expectedStatus int
+		expectedEvents []sseEvent
+	}{
+		{"推送最终状态", completedTaskID, "", http.StatusOK, []sseEvent{{3, "completed"}}},
+		{"已收到旧事件时重新推送", completedTaskID, "2", http.StatusOK, []sseEvent{{3, "completed"}}},
+		{"已收到最终状态时直接关闭", completedTaskID, "3", http.StatusOK, nil},
+		{"任务不存在", utils.ToHex("missing/missing.mp3"), "", http.StatusNotFound, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/download/events?task_id="+tt.taskID, nil)
+			if tt.lastEventID != "" {
+				req.Header.Set("Last-Event-ID", tt.lastEventID)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("status = %d, expected %d", w.Code, tt.expectedStatus)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var events []sseEvent
+			reader := bufio.NewReader(w.Body)
+			for {
+				ev, err := readEvent(reader)
+				if err != nil {
+					break
+				}
+				events = append(events, ev)
+			}
+			if len(events) != len(tt.expectedEvents) {
+				t.Fatalf("events = %+v, expected %+v", events, tt.expectedEvents)
+			}
+			for i := range events {
+				if events[i] != tt.expectedEvents[i] {
+					t.Errorf("event %d = %+v, expected %+v", i, events[i], tt.expectedEvents[i])
+				}
+			}
+		})
+	}
+}
+
+// TestHandler_StreamDownloadEvents_Running 测试进行中的任务按 revision 递增推送，重连时不重复推送，任务结束后关闭连接
+func TestHandler_StreamDownloadEvents_Running(t *testing.T) {
+	router, service := newTestRouter(t)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	taskID, err := service.StartDownload("https://www.youtube.com/watch?v=abc",
+		utils.ToHex("a__mp3__48000__bestaudio"), ytdlp.DownloadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer service.CancelDownload(taskID)
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	open := func(lastEventID int64) *bufio.Reader {
+		req, err := http.NewRequest(http.MethodGet, server.URL+"/download/events?task_id="+taskID, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lastEventID > 0 {
+			req.Header.Set("Last-Event-ID", strconv.FormatInt(lastEventID, 10))
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("status = %d, expected 200", resp.StatusCode)
+		}
+		return bufio.NewReader(resp.Body)
+	}
+
+	first := open(0)
+	current, err := readEvent(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.event != "progress" {
+		t.Fatalf("first event = %+v, expected progress", current)
+	}
+
+	// 带上已收到的事件 ID 重连，不会再次收到该事件
+	second := open(current.id)
+
+	if _, err := service.CancelDownload(taskID); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, reader := range map[string]*bufio.Reader{"first": first, "second": second} {
+		lastID := current.id
+		for {
+			ev, err := readEvent(reader)
+			if err != nil {
+				t.Fatalf("%s stream: %v before cancelled event", name, err)
+			}
+			if ev.id <= lastID {
+				t.Fatalf("%s stream: event %+v not after revision %d", name, ev, lastID)
+			}
+			lastID = ev.id
+			if ev.event == "cancelled" {
+				break
+			}
+		}
+		if ev, err := readEvent(reader); err != io.EOF {
+			t.Errorf("%s stream: got %+v, %v after final event, expected the stream to close", name, ev, err)
+		}
+	}
+}
+
+// TestHandler_Metrics 测试指标输出中已取消的任务单独统计，不计入失败
+func TestHandler_Metrics(t *testing.T) {
+	router, service := newTestRouter(t)
+
+	taskID, err := service.StartDownload("https://www.youtube.com/watch?v=abc",
+		utils.ToHex("a__mp3__48000__bestaudio"), ytdlp.DownloadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.CancelDownload(taskID); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, expected 200", w.Code)
+	}
+
+	for _, line := range []string{
+		`youtube_tools_tasks{state="completed"} 1`,
+		`youtube_tools_tasks{state="cancelled"} 1`,
+		`youtube_tools_tasks{state="failed"} 0`,
+		`youtube_tools_queue_depth 0`,
+	} {
+		if !strings.Contains(w.Body.String(), line+"\n") {
+			t.Errorf("metrics output missing %q", line)
+		}
+	}
+}
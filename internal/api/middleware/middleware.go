@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"crypto/subtle"
 	"fmt"
 	"net/http"
 	"strings"
@@ -11,6 +12,7 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/self-made-boy/youtube-tools/internal/api/response"
+	"github.com/self-made-boy/youtube-tools/internal/metrics"
 )
 
 // shouldSkipLogging 检查是否应该跳过日志记录
@@ -19,8 +21,12 @@ func shouldSkipLogging(path string) bool {
 	if path == "/" {
 		return true
 	}
-	// 跳过健康检查请求
-	if path == "/api/yt/health" {
+	// 跳过健康检查和探针请求
+	if path == "/api/yt/health" || path == "/api/yt/healthz" || path == "/api/yt/readyz" {
+		return true
+	}
+	// 跳过指标采集请求
+	if path == "/metrics" {
 		return true
 	}
 	// 跳过 swagger 相关请求
@@ -44,6 +50,9 @@ func Logger(logger *zap.Logger) gin.HandlerFunc {
 		// 处理请求
 		c.Next()
 
+		// 记录请求指标，跳过日志记录的请求同样计入，路由使用模板而不是实际路径
+		metrics.ObserveHTTPRequest(c.Request.Method, c.FullPath(), c.Writer.Status(), time.Since(start))
+
 		// 检查是否需要跳过日志记录
 		if shouldSkipLogging(c.Request.URL.Path) {
 			return
@@ -124,8 +133,10 @@ func CORS() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, Range")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
+		// 跨域的播放器需要读取文件下载的范围和文件名
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "Content-Length, Content-Range, Content-Disposition, Accept-Ranges, ETag")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(http.StatusNoContent)
@@ -135,3 +146,23 @@ func CORS() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// AdminAuth 创建一个管理接口鉴权中间件，要求 Authorization 头为 Bearer token
+// token 为空时不校验
+func AdminAuth(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token == "" {
+			c.Next()
+			return
+		}
+
+		provided, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			response.FailWithMessage(c, http.StatusUnauthorized, response.UNAUTHORIZED, "Invalid or missing admin token")
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -6,16 +6,35 @@ const (
 	SUCCESS = "SUCCESS"
 
 	// 客户端错误
-	INVALID_REQUEST = "INVALID_REQUEST" // 无效的请求参数
-	INVALID_TASK_ID = "INVALID_TASK_ID" // 无效的任务ID
-	TASK_NOT_FOUND  = "TASK_NOT_FOUND"  // 任务未找到
+	INVALID_REQUEST  = "INVALID_REQUEST"  // 无效的请求参数
+	INVALID_TASK_ID  = "INVALID_TASK_ID"  // 无效的任务ID
+	TASK_NOT_FOUND   = "TASK_NOT_FOUND"   // 任务未找到
+	INVALID_CLIP     = "INVALID_CLIP"     // 无效的片段起止时间
+	INVALID_PRESET   = "INVALID_PRESET"   // 转码预设不存在或不适用于所选格式
+	INVALID_CALLBACK = "INVALID_CALLBACK" // 回调地址不允许
+	UNAUTHORIZED     = "UNAUTHORIZED"     // 缺少或错误的管理员令牌
+
+	// 文件下载相关错误
+	FILE_NOT_FOUND    = "FILE_NOT_FOUND"    // 文件不存在
+	INVALID_SIGNATURE = "INVALID_SIGNATURE" // 下载地址签名错误
+	URL_EXPIRED       = "URL_EXPIRED"       // 下载地址已过期
+
+	// 任务相关错误
+	TASK_NOT_CANCELLABLE = "TASK_NOT_CANCELLABLE" // 任务已结束，无法取消
+	TASK_NOT_COMPLETED   = "TASK_NOT_COMPLETED"   // 任务尚未完成，没有可下载的文件
+	TOO_MANY_STREAMS     = "TOO_MANY_STREAMS"     // 同时进行的流式下载过多
 
 	// 视频相关错误
 	VIDEO_INFO_ERROR = "VIDEO_INFO_ERROR" // 获取视频信息失败
 	DOWNLOAD_ERROR   = "DOWNLOAD_ERROR"   // 下载视频失败
+	FILE_TOO_LARGE   = "FILE_TOO_LARGE"   // 文件超过大小限制
+
+	// 播放列表相关错误
+	PLAYLIST_INFO_ERROR = "PLAYLIST_INFO_ERROR" // 获取播放列表信息失败
 
 	// 服务器错误
 	SERVER_ERROR = "SERVER_ERROR" // 服务器内部错误
+	NOT_READY    = "NOT_READY"    // 服务未就绪
 )
 
 // GetMessage 根据响应码获取对应的消息
@@ -29,12 +48,38 @@ func GetMessage(code string) string {
 		return "Invalid task ID"
 	case TASK_NOT_FOUND:
 		return "Task not found"
+	case INVALID_CLIP:
+		return "Invalid clip start or end time"
+	case INVALID_PRESET:
+		return "Invalid transcoding preset"
+	case INVALID_CALLBACK:
+		return "Callback URL is not allowed"
+	case UNAUTHORIZED:
+		return "Unauthorized"
+	case FILE_NOT_FOUND:
+		return "File not found"
+	case INVALID_SIGNATURE:
+		return "Invalid download link signature"
+	case URL_EXPIRED:
+		return "Download link has expired"
+	case TASK_NOT_CANCELLABLE:
+		return "Task cannot be cancelled"
+	case TASK_NOT_COMPLETED:
+		return "Task has not completed"
+	case TOO_MANY_STREAMS:
+		return "Too many concurrent streams, please retry later"
 	case VIDEO_INFO_ERROR:
 		return "Failed to get video information"
 	case DOWNLOAD_ERROR:
 		return "Failed to download video"
+	case FILE_TOO_LARGE:
+		return "File exceeds the maximum allowed size"
+	case PLAYLIST_INFO_ERROR:
+		return "Failed to get playlist information"
 	case SERVER_ERROR:
 		return "Internal server error"
+	case NOT_READY:
+		return "Service is not ready"
 	default:
 		return "Unknown error"
 	}
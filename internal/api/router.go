@@ -7,6 +7,7 @@ import (
 	"github.com/self-made-boy/youtube-tools/internal/api/handlers"
 	"github.com/self-made-boy/youtube-tools/internal/api/middleware"
 	"github.com/self-made-boy/youtube-tools/internal/config"
+	"github.com/self-made-boy/youtube-tools/internal/storage"
 	"github.com/self-made-boy/youtube-tools/internal/ytdlp"
 
 	_ "github.com/self-made-boy/youtube-tools/docs" // 导入 Swagger 文档
@@ -14,8 +15,8 @@ import (
 	ginSwagger "github.com/swaggo/gin-swagger"
 )
 
-// SetupRouter 设置 API 路由
-func SetupRouter(cfg *config.Config, logger *zap.Logger) *gin.Engine {
+// SetupRouter 设置 API 路由，binaries 为启动时检测到的外部程序，fileStorage 为保存下载结果的存储
+func SetupRouter(cfg *config.Config, logger *zap.Logger, binaries ytdlp.Binaries, fileStorage storage.Storage) *gin.Engine {
 	// 设置 Gin 模式
 	if cfg.Env == "production" {
 		gin.SetMode(gin.ReleaseMode)
@@ -30,22 +31,43 @@ func SetupRouter(cfg *config.Config, logger *zap.Logger) *gin.Engine {
 	router.Use(middleware.CORS())
 
 	// 创建 yt-dlp 服务
-	ytdlpService := ytdlp.New(cfg, logger)
+	ytdlpService := ytdlp.New(cfg, logger, fileStorage)
 
 	// 创建处理器
-	h := handlers.New(cfg, logger, ytdlpService)
+	h := handlers.New(cfg, logger, ytdlpService, binaries)
 
 	// API 路由组
 	api := router.Group("/api/yt/")
 	{
 		// 健康检查
 		api.GET("/health", h.HealthCheck)
+		api.GET("/healthz", h.Healthz)
+		api.GET("/readyz", h.Readyz)
 
 		api.GET("/info", h.GetVideoInfo)
 		api.POST("/download", h.StartDownload)
+		api.DELETE("/download", h.CancelDownload)
 		api.GET("/download/status", h.GetDownloadStatus)
+		api.GET("/download/events", h.StreamDownloadEvents)
+		api.GET("/download/file", h.DownloadFile)
+		api.HEAD("/download/file", h.DownloadFile)
+		api.GET("/download/stream", h.StreamDownload)
+		api.GET("/files/*key", h.ServeSignedFile)
+		api.HEAD("/files/*key", h.ServeSignedFile)
+
+		api.GET("/playlist", h.GetPlaylistInfo)
+		api.POST("/playlist/download", h.StartPlaylistDownload)
+
+		// 管理接口
+		admin := api.Group("/admin", middleware.AdminAuth(cfg.Server.AdminToken))
+		admin.DELETE("/cache", h.PurgeVideoCache)
+		admin.GET("/storage/gc", h.GetRetentionReport)
+		admin.POST("/storage/gc", h.RunRetention)
 	}
 
+	// Prometheus 指标
+	router.GET("/metrics", h.Metrics)
+
 	// Swagger 文档
 	router.GET("/api/yt/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
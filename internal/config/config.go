@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -19,6 +22,18 @@ type Config struct {
 	// yt-dlp 配置
 	Ytdlp YtdlpConfig `yaml:"ytdlp"`
 
+	// 任务回调配置
+	Webhook WebhookConfig `yaml:"webhook"`
+
+	// 就绪检查配置
+	Readiness ReadinessConfig `yaml:"readiness"`
+
+	// 存储配置
+	Storage StorageConfig `yaml:"storage"`
+
+	// 存储清理配置
+	Retention RetentionConfig `yaml:"retention"`
+
 	// s3挂载位置
 	S3Mount string `yaml:"s3_mount"`
 
@@ -32,6 +47,8 @@ type Config struct {
 // ServerConfig 服务器配置
 type ServerConfig struct {
 	Port int `yaml:"port"`
+	// 管理接口的 Bearer 令牌，为空时管理接口不校验令牌
+	AdminToken string `yaml:"admin_token"`
 }
 
 // LogConfig 日志配置
@@ -48,9 +65,87 @@ type YtdlpConfig struct {
 	CookiesPath  string   `yaml:"cookies_path"` // cookies.txt 文件路径
 	Proxy        string   `yaml:"proxy"`        // HTTP/HTTPS/SOCKS代理，例如：http://proxy.example.com:8080
 	MaxDownloads int      `yaml:"max_downloads"`
-	MaxFileSize  int64    `yaml:"max_file_size"` // 单位：字节
-	AudioFormats []string `yaml:"audio_formats"` // aac, alac, flac, m4a, mp3, opus, vorbis, wav
-	VideoFormats []string `yaml:"video_formats"` // avi, flv, mkv, mov, mp4, webm
+	MaxStreams   int      `yaml:"max_streams"`    // 同时进行的流式下载数，为 0 时使用默认值 2
+	MaxFileSize  int64    `yaml:"max_file_size"`  // 单位：字节
+	AudioFormats []string `yaml:"audio_formats"`  // aac, alac, flac, m4a, mp3, opus, vorbis, wav
+	VideoFormats []string `yaml:"video_formats"`  // avi, flv, mkv, mov, mp4, webm
+	TaskStoreDir string   `yaml:"task_store_dir"` // 下载任务持久化目录，为空时任务只保存在内存中
+
+	InfoCacheTTL    time.Duration         `yaml:"info_cache_ttl"`    // 视频信息缓存的有效期，例如 1h，为 0 时使用默认值 1h
+	InfoMemoryCache InfoMemoryCacheConfig `yaml:"info_memory_cache"` // 解析后视频信息的内存缓存
+
+	MaxPlaylistEntries int      `yaml:"max_playlist_entries"` // 播放列表或频道最多展开的条目数
+	FormatPolicy       []string `yaml:"format_policy"`        // 格式排序规则：quality, compatible, smallest, original_language
+
+	Presets map[string]PresetConfig `yaml:"presets"` // 转码预设，键为预设名称，下载时通过 preset 参数选择
+}
+
+// PresetConfig 转码预设，未设置的项保持源文件或目标容器的默认值
+type PresetConfig struct {
+	VideoCodec    string `yaml:"video_codec"`    // ffmpeg 视频编码器，例如 libx264，为空时使用目标容器的默认编码器
+	AudioCodec    string `yaml:"audio_codec"`    // ffmpeg 音频编码器，例如 aac、flac，为空时使用目标容器的默认编码器
+	CRF           *int   `yaml:"crf"`            // 视频质量，数值越小质量越高，0 为无损，与 video_bitrate 二选一
+	VideoBitrate  string `yaml:"video_bitrate"`  // 视频码率，例如 1M
+	AudioBitrate  string `yaml:"audio_bitrate"`  // 音频码率，例如 64k
+	MaxHeight     int    `yaml:"max_height"`     // 最大高度，超过时按比例缩小
+	AudioChannels int    `yaml:"audio_channels"` // 音频声道数，例如 1 为单声道
+	SampleRate    int    `yaml:"sample_rate"`    // 音频采样率，例如 44100
+}
+
+// WebhookConfig 任务回调配置
+type WebhookConfig struct {
+	Secret     string        `yaml:"secret"`      // 回调签名使用的 HMAC 密钥，为空时不签名
+	MaxRetries int           `yaml:"max_retries"` // 投递失败后的最大重试次数
+	Timeout    time.Duration `yaml:"timeout"`     // 单次回调请求的超时时间，例如 10s
+	// 允许的回调域名，支持 *.example.com，为空时允许所有解析到公网地址的域名；列出的域名可以解析到内网地址
+	AllowedHosts []string `yaml:"allowed_hosts"`
+}
+
+// ReadinessConfig 就绪检查配置
+type ReadinessConfig struct {
+	MinFreeSpace   int64 `yaml:"min_free_space"`   // 本地存储目录和 download_dir 的最小剩余空间，单位：字节，为 0 时使用默认值 1GB
+	MaxQueueLength int   `yaml:"max_queue_length"` // 等待队列达到该长度时视为未就绪，为 0 时使用默认值 100
+}
+
+// InfoMemoryCacheConfig 解析后视频信息的内存 LRU 缓存配置，有效期与 info_cache_ttl 相同
+type InfoMemoryCacheConfig struct {
+	MaxEntries int   `yaml:"max_entries"` // 最多缓存的视频数，为 0 时使用默认值 200，为负数时不使用内存缓存
+	MaxBytes   int64 `yaml:"max_bytes"`   // 按 yt-dlp 输出大小估算的最大占用，单位字节，为 0 时使用默认值 256MB
+}
+
+// RetentionConfig 存储中下载结果的保留策略及 download_dir 中残留文件的清理配置
+type RetentionConfig struct {
+	Interval     time.Duration `yaml:"interval"`       // 自动清理的间隔，例如 1h，为 0 时使用默认值 1h，为负数时不自动清理
+	MaxAge       time.Duration `yaml:"max_age"`        // 超过该时间未访问的文件被删除，例如 720h，为 0 时不按时间清理
+	MaxTotalSize int64         `yaml:"max_total_size"` // 存储的总大小上限，单位字节，超过时按最后访问时间从旧到新删除，为 0 时不限制
+	OrphanAge    time.Duration `yaml:"orphan_age"`     // download_dir 中不属于进行中任务的临时目录超过该时间未修改时视为残留，为 0 时使用默认值 1h
+}
+
+// StorageConfig 下载结果和视频信息缓存的存储配置
+type StorageConfig struct {
+	Type      string          `yaml:"type"` // local: 写入 s3_mount 目录；s3: 通过 S3 兼容接口上传，为空时使用 local
+	S3        S3Config        `yaml:"s3"`
+	SignedURL SignedURLConfig `yaml:"signed_url"`
+}
+
+// SignedURLConfig 下载地址签名配置，开启后接口返回有有效期的下载地址而不是 s3_prefix 开头的永久地址
+type SignedURLConfig struct {
+	Enabled bool          `yaml:"enabled"`  // 是否返回签名的下载地址
+	Expiry  time.Duration `yaml:"expiry"`   // 下载地址的有效期，例如 1h，为 0 时使用默认值 1h，s3 存储最长 7 天
+	Secret  string        `yaml:"secret"`   // 本地存储签名使用的密钥
+	BaseURL string        `yaml:"base_url"` // 本地存储签名地址的前缀，指向文件下载接口，例如 https://api.example.com/api/yt/files/
+}
+
+// S3Config S3 兼容存储配置
+type S3Config struct {
+	Endpoint        string `yaml:"endpoint"`          // 例如 https://s3.us-east-2.amazonaws.com 或 http://minio:9000
+	Region          string `yaml:"region"`            // 为空时使用 us-east-1
+	Bucket          string `yaml:"bucket"`            // 存储桶名称
+	AccessKeyID     string `yaml:"access_key_id"`     // 访问密钥ID
+	SecretAccessKey string `yaml:"secret_access_key"` // 访问密钥
+	Prefix          string `yaml:"prefix"`            // 对象 key 前缀，例如 public/ytb/
+	PathStyle       bool   `yaml:"path_style"`        // 使用 endpoint/bucket/key 形式的地址，MinIO 等需要开启
+	PartSize        int64  `yaml:"part_size"`         // 分片上传的分片大小，单位字节，为 0 时使用默认值 16MB，不能小于 5MB
 }
 
 // Load 从YAML配置文件加载配置
@@ -75,9 +170,46 @@ func Load() (*Config, error) {
 		return nil, fmt.Errorf("failed to parse config file %s: %w", configPath, err)
 	}
 
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", configPath, err)
+	}
+
 	return &config, nil
 }
 
+// Validate 检查配置中互相冲突的项
+// download_dir 中的残留临时文件会被存储清理删除，因此不能包含本地存储的 s3_mount 目录和 cookies 文件
+func (c *Config) Validate() error {
+	downloadDir := c.Ytdlp.DownloadDir
+	if downloadDir == "" {
+		return nil
+	}
+	if (c.Storage.Type == "" || c.Storage.Type == "local") && c.S3Mount != "" && containsPath(downloadDir, c.S3Mount) {
+		return fmt.Errorf("ytdlp.download_dir %s must not contain s3_mount %s", downloadDir, c.S3Mount)
+	}
+	if c.Ytdlp.CookiesPath != "" && containsPath(downloadDir, c.Ytdlp.CookiesPath) {
+		return fmt.Errorf("ytdlp.download_dir %s must not contain ytdlp.cookies_path %s", downloadDir, c.Ytdlp.CookiesPath)
+	}
+	return nil
+}
+
+// containsPath 判断 path 是否为 dir 本身或位于 dir 之下
+func containsPath(dir, path string) bool {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
 // getEnv 获取环境变量，如果不存在则返回默认值
 func getEnv(key, defaultValue string) string {
 	value := os.Getenv(key)
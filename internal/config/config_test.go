@@ -0,0 +1,41 @@
+package config
+
+import (
+	"testing"
+)
+
+// TestConfig_Validate 测试 download_dir 不能包含本地存储目录和 cookies 文件
+func TestConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name        string
+		storageType string
+		downloadDir string
+		s3Mount     string
+		cookiesPath string
+		expectErr   bool
+	}{
+		{"目录互不包含", "", "/data/work", "/data/yt", "/data/yt/cookies.txt", false},
+		{"与 s3_mount 相同", "", "/data/yt", "/data/yt", "", true},
+		{"包含 s3_mount", "local", "/data", "/data/yt/", "", true},
+		{"位于 s3_mount 之下", "", "/data/yt/tmp", "/data/yt", "", false},
+		{"包含 cookies 文件", "", "/data/work", "/data/yt", "/data/work/cookies.txt", true},
+		{"s3 存储不使用 s3_mount", "s3", "/data/yt", "/data/yt", "", false},
+		{"前缀相同但不是子目录", "", "/data/yt", "/data/yt2", "/data/yt-cookies.txt", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				S3Mount: tt.s3Mount,
+				Ytdlp: YtdlpConfig{
+					DownloadDir: tt.downloadDir,
+					CookiesPath: tt.cookiesPath,
+				},
+				Storage: StorageConfig{Type: tt.storageType},
+			}
+			if err := cfg.Validate(); (err != nil) != tt.expectErr {
+				t.Errorf("Validate() error = %v, expectErr %v", err, tt.expectErr)
+			}
+		})
+	}
+}
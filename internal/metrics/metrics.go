@@ -0,0 +1,230 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace 所有指标名称的前缀
+const namespace = "youtube_tools"
+
+var (
+	// httpRequestsTotal HTTP 请求数，route 为路由模板，避免按视频或任务ID产生大量标签
+	httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Total number of HTTP requests.",
+	}, []string{"method", "route", "status"})
+
+	// httpRequestDuration HTTP 请求耗时
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency in seconds.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	// infoCacheTotal 视频信息缓存的命中情况
+	infoCacheTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "info_cache_requests_total",
+		Help:      "Total number of video info lookups by cache result.",
+	}, []string{"result"})
+
+	// infoMemoryCacheTotal 解析后视频信息内存缓存的命中情况
+	infoMemoryCacheTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "info_memory_cache_requests_total",
+		Help:      "Total number of in-memory video info lookups by cache result.",
+	}, []string{"result"})
+
+	// infoMemoryCacheEntries 内存缓存中的视频数
+	infoMemoryCacheEntries = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "info_memory_cache_entries",
+		Help:      "Number of video infos in the in-memory cache.",
+	})
+
+	// infoMemoryCacheBytes 内存缓存按 yt-dlp 输出大小估算的占用
+	infoMemoryCacheBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "info_memory_cache_bytes",
+		Help:      "Estimated size of the in-memory video info cache in bytes.",
+	})
+
+	// ytdlpDuration yt-dlp 调用耗时，operation 为 info、playlist 或 download
+	ytdlpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "ytdlp_duration_seconds",
+		Help:      "yt-dlp invocation latency in seconds.",
+		Buckets:   []float64{0.5, 1, 2, 5, 10, 30, 60, 120, 300, 600, 1800},
+	}, []string{"operation"})
+
+	// ytdlpExitsTotal yt-dlp 调用的退出码
+	ytdlpExitsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ytdlp_exits_total",
+		Help:      "Total number of yt-dlp invocations by exit code.",
+	}, []string{"operation", "exit_code"})
+
+	// downloadDuration 成功下载的耗时，kind 为 audio、video 或 subtitle
+	downloadDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "download_duration_seconds",
+		Help:      "Duration of successful downloads in seconds.",
+		Buckets:   []float64{1, 5, 10, 30, 60, 120, 300, 600, 1800, 3600},
+	}, []string{"kind", "ext"})
+
+	// downloadBytesTotal 成功下载的文件大小
+	downloadBytesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "download_bytes_total",
+		Help:      "Total bytes of successfully downloaded files.",
+	}, []string{"kind", "ext"})
+
+	// queueDepth 等待队列中的任务数
+	queueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "queue_depth",
+		Help:      "Number of download tasks waiting in the queue.",
+	})
+
+	// retentionDeletedTotal 存储清理删除的文件数，reason 为 expired、quota 或 orphan
+	retentionDeletedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "retention_deleted_files_total",
+		Help:      "Total number of files deleted by storage retention.",
+	}, []string{"reason"})
+
+	// retentionReclaimedBytesTotal 存储清理释放的字节数
+	retentionReclaimedBytesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "retention_reclaimed_bytes_total",
+		Help:      "Total bytes reclaimed by storage retention.",
+	}, []string{"reason"})
+
+	// retentionLastRun 最近一次存储清理完成的时间
+	retentionLastRun = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "retention_last_run_timestamp_seconds",
+		Help:      "Unix timestamp of the last completed storage retention run.",
+	})
+
+	// storageObjects 存储中的文件数，由存储清理更新
+	storageObjects = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "storage_objects",
+		Help:      "Number of files in storage as of the last retention run.",
+	})
+
+	// storageBytes 存储中文件的总大小，由存储清理更新
+	storageBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "storage_bytes",
+		Help:      "Total size of files in storage in bytes as of the last retention run.",
+	})
+
+	// tasks 各状态的任务数
+	tasks = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "tasks",
+		Help:      "Number of download tasks by state.",
+	}, []string{"state"})
+)
+
+// ObserveHTTPRequest 记录一次 HTTP 请求，未匹配到路由的请求 route 记为 unmatched
+func ObserveHTTPRequest(method, route string, status int, duration time.Duration) {
+	if route == "" {
+		route = "unmatched"
+	}
+	httpRequestsTotal.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	httpRequestDuration.WithLabelValues(method, route).Observe(duration.Seconds())
+}
+
+// ObserveInfoCache 记录一次视频信息缓存查询
+func ObserveInfoCache(hit bool) {
+	if hit {
+		infoCacheTotal.WithLabelValues("hit").Inc()
+	} else {
+		infoCacheTotal.WithLabelValues("miss").Inc()
+	}
+}
+
+// ObserveInfoMemoryCache 记录一次视频信息内存缓存查询
+func ObserveInfoMemoryCache(hit bool) {
+	if hit {
+		infoMemoryCacheTotal.WithLabelValues("hit").Inc()
+	} else {
+		infoMemoryCacheTotal.WithLabelValues("miss").Inc()
+	}
+}
+
+// SetInfoMemoryCacheSize 设置内存缓存中的视频数和估算的占用
+func SetInfoMemoryCacheSize(entries int, bytes int64) {
+	infoMemoryCacheEntries.Set(float64(entries))
+	infoMemoryCacheBytes.Set(float64(bytes))
+}
+
+// ObserveYtdlp 记录一次 yt-dlp 调用的耗时和退出码
+func ObserveYtdlp(operation string, duration time.Duration, err error) {
+	ytdlpDuration.WithLabelValues(operation).Observe(duration.Seconds())
+	ytdlpExitsTotal.WithLabelValues(operation, exitCode(err)).Inc()
+}
+
+// exitCode 返回命令的退出码，被信号结束时为 signal，无法启动时为 error
+func exitCode(err error) string {
+	if err == nil {
+		return "0"
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if code := exitErr.ExitCode(); code >= 0 {
+			return strconv.Itoa(code)
+		}
+		return "signal"
+	}
+	return "error"
+}
+
+// ObserveDownload 记录一次成功的下载
+func ObserveDownload(kind, ext string, duration time.Duration, bytes int64) {
+	downloadDuration.WithLabelValues(kind, ext).Observe(duration.Seconds())
+	downloadBytesTotal.WithLabelValues(kind, ext).Add(float64(bytes))
+}
+
+// SetQueueDepth 设置等待队列中的任务数
+func SetQueueDepth(depth int) {
+	queueDepth.Set(float64(depth))
+}
+
+// SetTasks 设置各状态的任务数
+func SetTasks(counts map[string]int) {
+	for state, count := range counts {
+		tasks.WithLabelValues(state).Set(float64(count))
+	}
+}
+
+// ObserveRetentionDelete 记录存储清理删除的一个文件
+func ObserveRetentionDelete(reason string, bytes int64) {
+	retentionDeletedTotal.WithLabelValues(reason).Inc()
+	retentionReclaimedBytesTotal.WithLabelValues(reason).Add(float64(bytes))
+}
+
+// SetStorageUsage 设置存储中的文件数和总大小，并记录存储清理完成的时间
+func SetStorageUsage(objects int, bytes int64) {
+	storageObjects.Set(float64(objects))
+	storageBytes.Set(float64(bytes))
+	retentionLastRun.SetToCurrentTime()
+}
+
+// Handler 返回以 Prometheus 文本格式输出所有指标的处理器
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
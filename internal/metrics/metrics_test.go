@@ -0,0 +1,90 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scrape 以 Prometheus 文本格式读取当前所有指标
+func scrape(t *testing.T) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("metrics status = %d, expected 200", w.Code)
+	}
+	return w.Body.String()
+}
+
+// TestSetTasks 测试各状态的任务数分别输出，已取消的任务不计入失败
+func TestSetTasks(t *testing.T) {
+	SetTasks(map[string]int{
+		"pending":     2,
+		"downloading": 1,
+		"completed":   5,
+		"failed":      3,
+		"cancelled":   4,
+	})
+	output := scrape(t)
+
+	for _, line := range []string{
+		`youtube_tools_tasks{state="pending"} 2`,
+		`youtube_tools_tasks{state="downloading"} 1`,
+		`youtube_tools_tasks{state="completed"} 5`,
+		`youtube_tools_tasks{state="failed"} 3`,
+		`youtube_tools_tasks{state="cancelled"} 4`,
+	} {
+		if !strings.Contains(output, line+"\n") {
+			t.Errorf("metrics output missing %q", line)
+		}
+	}
+}
+
+// TestObserveHTTPRequest 测试 HTTP 请求按路由模板计数，未匹配的路由记为 unmatched
+func TestObserveHTTPRequest(t *testing.T) {
+	ObserveHTTPRequest(http.MethodGet, "/api/yt/download/status", http.StatusOK, 10*time.Millisecond)
+	ObserveHTTPRequest(http.MethodGet, "", http.StatusNotFound, time.Millisecond)
+	output := scrape(t)
+
+	for _, line := range []string{
+		`youtube_tools_http_requests_total{method="GET",route="/api/yt/download/status",status="200"} 1`,
+		`youtube_tools_http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		`youtube_tools_http_request_duration_seconds_count{method="GET",route="/api/yt/download/status"} 1`,
+	} {
+		if !strings.Contains(output, line+"\n") {
+			t.Errorf("metrics output missing %q", line)
+		}
+	}
+}
+
+// TestExitCode 测试 yt-dlp 退出码标签
+func TestExitCode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires a POSIX shell")
+	}
+
+	tests := []struct {
+		name     string
+		err      error
+		expected string
+	}{
+		{"成功", nil, "0"},
+		{"非零退出码", exec.Command("sh", "-c", "exit 2").Run(), "2"},
+		{"被信号结束", exec.Command("sh", "-c", "kill -9 $$").Run(), "signal"},
+		{"无法启动", errors.New("exec: not found"), "error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCode(tt.err); got != tt.expected {
+				t.Errorf("exitCode(%v) = %s, expected %s", tt.err, got, tt.expected)
+			}
+		})
+	}
+}
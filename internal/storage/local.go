@@ -0,0 +1,211 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LocalStorage 将对象保存在本地目录或挂载的 S3 目录中
+type LocalStorage struct {
+	root      string
+	urlPrefix string
+	// 签名下载地址的密钥和地址前缀，未配置时不能生成签名地址
+	secret        []byte
+	signedBaseURL string
+}
+
+// NewLocal 创建本地存储，root 为保存对象的目录，urlPrefix 为下载地址前缀
+func NewLocal(root, urlPrefix string) *LocalStorage {
+	return &LocalStorage{root: root, urlPrefix: urlPrefix}
+}
+
+// Root 返回保存对象的目录
+func (l *LocalStorage) Root() string {
+	return l.root
+}
+
+// path 返回对象的文件路径，key 中的 .. 不会越过 root
+func (l *LocalStorage) path(key string) string {
+	return filepath.Join(l.root, filepath.FromSlash(path.Clean("/"+key)))
+}
+
+// Put 写入对象
+// 直接写入目标文件而不是先写临时文件再重命名，挂载的 S3 目录通常不支持重命名
+func (l *LocalStorage) Put(ctx context.Context, key string, r io.Reader) error {
+	dst := l.path(key)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("failed to create destination directory: %w", err)
+	}
+
+	file, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("failed to create destination file: %w", err)
+	}
+
+	_, err = io.Copy(file, r)
+	if err == nil {
+		// 确保数据写入磁盘
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dst)
+		return fmt.Errorf("failed to write %s: %w", key, err)
+	}
+	return nil
+}
+
+// Stat 返回对象的元信息，ETag 由修改时间和大小生成
+func (l *LocalStorage) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	stat, err := os.Stat(l.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return ObjectInfo{}, ErrNotFound
+	}
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if stat.IsDir() {
+		return ObjectInfo{}, ErrNotFound
+	}
+	return localObjectInfo(key, stat), nil
+}
+
+// localObjectInfo 根据文件信息返回对象的元信息
+func localObjectInfo(key string, stat fs.FileInfo) ObjectInfo {
+	return ObjectInfo{
+		Key:     key,
+		Size:    stat.Size(),
+		ModTime: stat.ModTime(),
+		ETag:    fmt.Sprintf(`"%x-%x"`, stat.ModTime().UnixNano(), stat.Size()),
+	}
+}
+
+// Open 打开对象读取内容
+func (l *LocalStorage) Open(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	file, err := os.Open(l.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// Delete 删除对象
+func (l *LocalStorage) Delete(ctx context.Context, key string) error {
+	err := os.Remove(l.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// List 遍历 root 下的文件，跳过以 . 开头的目录和文件
+func (l *LocalStorage) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	err := filepath.WalkDir(l.root, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			// 遍历期间被删除的文件
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if p == l.root {
+			return nil
+		}
+		if strings.HasPrefix(entry.Name(), ".") {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(l.root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		stat, err := entry.Info()
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		objects = append(objects, localObjectInfo(key, stat))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", l.root, err)
+	}
+	return objects, nil
+}
+
+// URL 返回对象的下载地址
+func (l *LocalStorage) URL(key string) string {
+	return l.urlPrefix + key
+}
+
+// SignedURL 返回带 HMAC 签名的下载地址，由文件下载接口通过 VerifySignature 校验
+func (l *LocalStorage) SignedURL(key string, expiresAt time.Time) (string, error) {
+	if len(l.secret) == 0 {
+		return "", errors.New("signed url is not configured for local storage")
+	}
+	expires := strconv.FormatInt(expiresAt.Unix(), 10)
+	query := url.Values{
+		"expires":   {expires},
+		"signature": {l.sign(key, expires)},
+	}
+	return l.signedBaseURL + (&url.URL{Path: key}).EscapedPath() + "?" + query.Encode(), nil
+}
+
+// VerifySignature 校验签名下载地址中的 expires 和 signature
+func (l *LocalStorage) VerifySignature(key, expires, signature string) error {
+	if len(l.secret) == 0 {
+		return ErrInvalidSignature
+	}
+	if !hmac.Equal([]byte(signature), []byte(l.sign(key, expires))) {
+		return ErrInvalidSignature
+	}
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if time.Now().Unix() > expiresAt {
+		return ErrURLExpired
+	}
+	return nil
+}
+
+// sign 返回 key 和过期时间的 HMAC-SHA256 签名
+func (l *LocalStorage) sign(key, expires string) string {
+	mac := hmac.New(sha256.New, l.secret)
+	mac.Write([]byte(key + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestLocalStorage_VerifySignature 测试本地存储签名地址的生成和校验
+func TestLocalStorage_VerifySignature(t *testing.T) {
+	local := NewLocal(t.TempDir(), "")
+	local.secret = []byte("secret")
+	local.signedBaseURL = "https://api.example.com/api/yt/files/"
+
+	key := "abc/audio/48000/abc.mp3"
+	signedURL, err := local.SignedURL(key, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(signedURL, local.signedBaseURL+key+"?") {
+		t.Fatalf("SignedURL() = %s", signedURL)
+	}
+	u, _ := url.Parse(signedURL)
+	expires, signature := u.Query().Get("expires"), u.Query().Get("signature")
+	expired := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+
+	tests := []struct {
+		name      string
+		key       string
+		expires   string
+		signature string
+		expected  error
+	}{
+		{"签名正确", key, expires, signature, nil},
+		{"文件路径被修改", "abc/audio/48000/other.mp3", expires, signature, ErrInvalidSignature},
+		{"过期时间被修改", key, expired, signature, ErrInvalidSignature},
+		{"已过期", key, expired, local.sign(key, expired), ErrURLExpired},
+		{"缺少签名", key, expires, "", ErrInvalidSignature},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := local.VerifySignature(tt.key, tt.expires, tt.signature); !errors.Is(err, tt.expected) {
+				t.Errorf("VerifySignature() error = %v, expected %v", err, tt.expected)
+			}
+		})
+	}
+}
+
+// TestLocalStorage_List 测试列出本地存储中的对象，跳过以 . 开头的目录和文件
+func TestLocalStorage_List(t *testing.T) {
+	root := t.TempDir()
+	local := NewLocal(root, "")
+	ctx := context.Background()
+	for _, key := range []string{"abc/abc.json", "abc/audio/48000/abc.mp3", "def/def.json", ".tasks/616263.task.json", "abc/.hidden"} {
+		if err := local.Put(ctx, key, strings.NewReader(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		prefix   string
+		expected []string
+	}{
+		{"全部对象", "", []string{"abc/abc.json", "abc/audio/48000/abc.mp3", "def/def.json"}},
+		{"指定前缀", "abc/", []string{"abc/abc.json", "abc/audio/48000/abc.mp3"}},
+		{"没有对象", "xyz/", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects, err := local.List(ctx, tt.prefix)
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+			var keys []string
+			for _, object := range objects {
+				keys = append(keys, object.Key)
+				if object.Size != int64(len(object.Key)) {
+					t.Errorf("List() object %s size = %d", object.Key, object.Size)
+				}
+			}
+			if strings.Join(keys, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("List() = %v, expected %v", keys, tt.expected)
+			}
+		})
+	}
+}
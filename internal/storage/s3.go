@@ -0,0 +1,463 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/self-made-boy/youtube-tools/internal/config"
+)
+
+const (
+	// defaultPartSize 未配置 part_size 时分片上传的分片大小：16MB
+	defaultPartSize = 16 << 20
+	// minPartSize S3 要求除最后一片外每个分片不小于 5MB
+	minPartSize = 5 << 20
+)
+
+// S3Storage 通过 S3 兼容接口保存对象，小于一个分片的对象直接上传，其余使用分片上传
+type S3Storage struct {
+	endpoint  *url.URL
+	bucket    string
+	prefix    string
+	pathStyle bool
+	partSize  int64
+	urlPrefix string
+	signer    signer
+	client    *http.Client
+}
+
+// NewS3 创建 S3 兼容存储，urlPrefix 为下载地址前缀，为空时使用存储桶地址
+func NewS3(cfg config.S3Config, urlPrefix string) (*S3Storage, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, errors.New("storage.s3.endpoint and storage.s3.bucket are required")
+	}
+	endpoint, err := url.Parse(strings.TrimSuffix(cfg.Endpoint, "/"))
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid storage.s3.endpoint: %s", cfg.Endpoint)
+	}
+
+	region := cfg.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	partSize := cfg.PartSize
+	if partSize == 0 {
+		partSize = defaultPartSize
+	}
+	if partSize < minPartSize {
+		return nil, fmt.Errorf("storage.s3.part_size must be at least %d bytes", minPartSize)
+	}
+
+	s := &S3Storage{
+		endpoint:  endpoint,
+		bucket:    cfg.Bucket,
+		prefix:    cfg.Prefix,
+		pathStyle: cfg.PathStyle,
+		partSize:  partSize,
+		urlPrefix: urlPrefix,
+		signer: signer{
+			accessKeyID:     cfg.AccessKeyID,
+			secretAccessKey: cfg.SecretAccessKey,
+			region:          region,
+		},
+		client: &http.Client{},
+	}
+	if s.urlPrefix == "" {
+		s.urlPrefix = s.objectURL("", nil).String()
+	}
+	return s, nil
+}
+
+// bucketURL 返回存储桶的请求地址
+func (s *S3Storage) bucketURL(query url.Values) *url.URL {
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	if s.pathStyle {
+		u.Path += "/" + s.bucket
+	} else {
+		u.Host = s.bucket + "." + u.Host
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	u.RawQuery = query.Encode()
+	return &u
+}
+
+// objectURL 返回对象的请求地址，key 会加上配置的前缀
+func (s *S3Storage) objectURL(key string, query url.Values) *url.URL {
+	u := s.bucketURL(query)
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.prefix + key
+	return u
+}
+
+// s3Error S3 返回的错误
+type s3Error struct {
+	StatusCode int    `xml:"-"`
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *s3Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("s3 request failed with status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("s3 request failed with status %d: %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// do 签名并发送请求，状态码不是 2xx 时返回错误，404 时返回 ErrNotFound
+func (s *S3Storage) do(ctx context.Context, method string, u *url.URL, header http.Header, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	payloadHash := emptyPayloadHash
+	if len(body) > 0 {
+		payloadHash = sha256Hex(body)
+	}
+	s.signer.sign(req, payloadHash, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	return nil, readS3Error(resp)
+}
+
+// readS3Error 从响应中解析 S3 错误
+func readS3Error(resp *http.Response) error {
+	s3Err := &s3Error{StatusCode: resp.StatusCode}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	xml.Unmarshal(data, s3Err)
+	return s3Err
+}
+
+// contentType 根据扩展名返回对象的 Content-Type
+func contentType(key string) string {
+	if ct := mime.TypeByExtension(path.Ext(key)); ct != "" {
+		return ct
+	}
+	return "application/octet-stream"
+}
+
+// Put 写入对象，内容小于一个分片时直接上传，否则使用分片上传，失败时取消分片上传
+func (s *S3Storage) Put(ctx context.Context, key string, r io.Reader) error {
+	buf := make([]byte, s.partSize)
+	n, err := io.ReadFull(r, buf)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		header := http.Header{"Content-Type": {contentType(key)}}
+		resp, err := s.do(ctx, http.MethodPut, s.objectURL(key, nil), header, buf[:n])
+		if err != nil {
+			return fmt.Errorf("failed to upload %s: %w", key, err)
+		}
+		resp.Body.Close()
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", key, err)
+	}
+
+	uploadID, err := s.createMultipartUpload(ctx, key)
+	if err != nil {
+		return fmt.Errorf("failed to create multipart upload for %s: %w", key, err)
+	}
+	if err := s.uploadParts(ctx, key, uploadID, buf, r); err != nil {
+		// 使用新的 context，请求被取消时也能清理已上传的分片
+		abortCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		s.abortMultipartUpload(abortCtx, key, uploadID)
+		return fmt.Errorf("failed to upload %s: %w", key, err)
+	}
+	return nil
+}
+
+// completedPart 已上传的分片
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// uploadParts 上传 buf 中已读取的第一个分片及 r 中剩余的内容，然后完成分片上传
+func (s *S3Storage) uploadParts(ctx context.Context, key, uploadID string, buf []byte, r io.Reader) error {
+	var parts []completedPart
+	n := len(buf)
+	for partNumber := 1; n > 0; partNumber++ {
+		query := url.Values{
+			"partNumber": {strconv.Itoa(partNumber)},
+			"uploadId":   {uploadID},
+		}
+		resp, err := s.do(ctx, http.MethodPut, s.objectURL(key, query), nil, buf[:n])
+		if err != nil {
+			return fmt.Errorf("part %d: %w", partNumber, err)
+		}
+		resp.Body.Close()
+		parts = append(parts, completedPart{PartNumber: partNumber, ETag: resp.Header.Get("ETag")})
+
+		n, err = io.ReadFull(r, buf)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return err
+		}
+	}
+
+	return s.completeMultipartUpload(ctx, key, uploadID, parts)
+}
+
+// createMultipartUpload 创建分片上传，返回 UploadId
+func (s *S3Storage) createMultipartUpload(ctx context.Context, key string) (string, error) {
+	header := http.Header{"Content-Type": {contentType(key)}}
+	resp, err := s.do(ctx, http.MethodPost, s.objectURL(key, url.Values{"uploads": {""}}), header, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to parse response: %w", err)
+	}
+	if result.UploadID == "" {
+		return "", errors.New("empty upload id in response")
+	}
+	return result.UploadID, nil
+}
+
+// completeMultipartUpload 完成分片上传
+// S3 可能在返回 200 后的响应体中报告错误，需要检查响应内容
+func (s *S3Storage) completeMultipartUpload(ctx context.Context, key, uploadID string, parts []completedPart) error {
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.do(ctx, http.MethodPost, s.objectURL(key, url.Values{"uploadId": {uploadID}}), nil, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var result struct {
+		XMLName xml.Name
+		s3Error
+	}
+	if err := xml.Unmarshal(data, &result); err == nil && result.XMLName.Local == "Error" {
+		result.s3Error.StatusCode = resp.StatusCode
+		return &result.s3Error
+	}
+	return nil
+}
+
+// abortMultipartUpload 取消分片上传并删除已上传的分片
+func (s *S3Storage) abortMultipartUpload(ctx context.Context, key, uploadID string) {
+	resp, err := s.do(ctx, http.MethodDelete, s.objectURL(key, url.Values{"uploadId": {uploadID}}), nil, nil)
+	if err == nil {
+		resp.Body.Close()
+	}
+}
+
+// Stat 返回对象的元信息
+func (s *S3Storage) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	resp, err := s.do(ctx, http.MethodHead, s.objectURL(key, nil), nil, nil)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	resp.Body.Close()
+
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return ObjectInfo{
+		Key:     key,
+		Size:    resp.ContentLength,
+		ModTime: modTime,
+		ETag:    resp.Header.Get("ETag"),
+	}, nil
+}
+
+// Open 打开对象读取内容，大小取自 HEAD 请求，第一次读取时才发起 GET 请求，
+// Seek 到其他位置后的读取使用 Range 请求
+func (s *S3Storage) Open(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	info, err := s.Stat(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return &s3Object{
+		storage: s,
+		ctx:     ctx,
+		key:     key,
+		size:    info.Size,
+	}, nil
+}
+
+// s3Object 可以 Seek 的 S3 对象，读取时按需发起 GET 请求，Seek 改变位置后关闭当前响应，下次读取时从新位置发起 Range 请求
+type s3Object struct {
+	storage *S3Storage
+	ctx     context.Context
+	key     string
+	size    int64
+	offset  int64
+	body    io.ReadCloser
+}
+
+func (o *s3Object) Read(p []byte) (int, error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+	if o.body == nil {
+		var header http.Header
+		if o.offset > 0 {
+			header = http.Header{"Range": {fmt.Sprintf("bytes=%d-", o.offset)}}
+		}
+		resp, err := o.storage.do(o.ctx, http.MethodGet, o.storage.objectURL(o.key, nil), header, nil)
+		if err != nil {
+			return 0, err
+		}
+		o.body = resp.Body
+	}
+	n, err := o.body.Read(p)
+	o.offset += int64(n)
+	return n, err
+}
+
+func (o *s3Object) Seek(offset int64, whence int) (int64, error) {
+	var target int64
+	switch whence {
+	case io.SeekStart:
+		target = offset
+	case io.SeekCurrent:
+		target = o.offset + offset
+	case io.SeekEnd:
+		target = o.size + offset
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if target < 0 {
+		return 0, errors.New("negative position")
+	}
+	if target != o.offset && o.body != nil {
+		o.body.Close()
+		o.body = nil
+	}
+	o.offset = target
+	return target, nil
+}
+
+func (o *s3Object) Close() error {
+	if o.body == nil {
+		return nil
+	}
+	err := o.body.Close()
+	o.body = nil
+	return err
+}
+
+// Delete 删除对象
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, s.objectURL(key, nil), nil, nil)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// List 使用 ListObjectsV2 分页列出对象，返回的 key 不包含配置的前缀
+func (s *S3Storage) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	token := ""
+	for {
+		query := url.Values{
+			"list-type": {"2"},
+			"prefix":    {s.prefix + prefix},
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.do(ctx, http.MethodGet, s.bucketURL(query), nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects: %w", err)
+		}
+
+		var result struct {
+			Contents []struct {
+				Key          string    `xml:"Key"`
+				Size         int64     `xml:"Size"`
+				LastModified time.Time `xml:"LastModified"`
+				ETag         string    `xml:"ETag"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse list response: %w", err)
+		}
+
+		for _, content := range result.Contents {
+			key := strings.TrimPrefix(content.Key, s.prefix)
+			// 目录占位对象和内部文件
+			if key == "" || strings.HasSuffix(key, "/") || isHiddenKey(key) {
+				continue
+			}
+			objects = append(objects, ObjectInfo{
+				Key:     key,
+				Size:    content.Size,
+				ModTime: content.LastModified,
+				ETag:    content.ETag,
+			})
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// URL 返回对象的下载地址
+func (s *S3Storage) URL(key string) string {
+	return s.urlPrefix + key
+}
+
+// SignedURL 返回存储桶中对象的预签名地址，不使用 s3_prefix
+func (s *S3Storage) SignedURL(key string, expiresAt time.Time) (string, error) {
+	now := time.Now()
+	expiry := expiresAt.Sub(now)
+	if expiry <= 0 || expiry > maxPresignExpiry {
+		return "", fmt.Errorf("presigned url expiry must be between 1s and %s", maxPresignExpiry)
+	}
+	return s.signer.presign(http.MethodGet, s.objectURL(key, nil), now, expiry), nil
+}
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/self-made-boy/youtube-tools/internal/config"
+)
+
+var (
+	// ErrNotFound 对象不存在
+	ErrNotFound = errors.New("object not found")
+	// ErrInvalidSignature 下载地址的签名错误
+	ErrInvalidSignature = errors.New("invalid signature")
+	// ErrURLExpired 下载地址已过期
+	ErrURLExpired = errors.New("download url expired")
+)
+
+// maxPresignExpiry S3 预签名地址的最长有效期
+const maxPresignExpiry = 7 * 24 * time.Hour
+
+// ObjectInfo 对象的元信息
+type ObjectInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+	// 对象内容的标识，内容变化时随之变化，带双引号
+	ETag string
+}
+
+// Storage 保存下载结果和视频信息缓存的存储，key 为以 / 分隔的相对路径，如 <videoID>/audio/48000/<videoID>.mp3
+type Storage interface {
+	// Put 写入对象，已存在时覆盖
+	Put(ctx context.Context, key string, r io.Reader) error
+	// Stat 返回对象的元信息，对象不存在时返回 ErrNotFound
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+	// Open 打开对象读取内容，支持 Seek 以便按 Range 读取，对象不存在时返回 ErrNotFound
+	Open(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	// Delete 删除对象，对象不存在时不返回错误
+	Delete(ctx context.Context, key string) error
+	// List 列出 key 以 prefix 开头的所有对象，不包含路径中有以 . 开头的部分的对象
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	// URL 返回对象的下载地址
+	URL(key string) string
+	// SignedURL 返回在 expiresAt 后失效的下载地址
+	SignedURL(key string, expiresAt time.Time) (string, error)
+}
+
+// New 根据配置创建存储
+// type 为 local 或为空时写入 s3_mount 目录，为 s3 时通过 S3 兼容接口上传，下载地址都以 s3_prefix 开头
+// 开启 signed_url 时，本地存储的签名地址指向 base_url，S3 的签名地址为存储桶的预签名地址
+func New(cfg *config.Config) (Storage, error) {
+	signed := cfg.Storage.SignedURL
+	switch cfg.Storage.Type {
+	case "", "local":
+		if cfg.S3Mount == "" {
+			return nil, errors.New("s3_mount is required for local storage")
+		}
+		local := NewLocal(cfg.S3Mount, cfg.S3Prefix)
+		if signed.Enabled {
+			if signed.Secret == "" || signed.BaseURL == "" {
+				return nil, errors.New("storage.signed_url.secret and storage.signed_url.base_url are required for local storage")
+			}
+			local.secret = []byte(signed.Secret)
+			local.signedBaseURL = signed.BaseURL
+		}
+		return local, nil
+	case "s3":
+		if signed.Enabled && signed.Expiry > maxPresignExpiry {
+			return nil, fmt.Errorf("storage.signed_url.expiry must not exceed %s for s3 storage", maxPresignExpiry)
+		}
+		return NewS3(cfg.Storage.S3, cfg.S3Prefix)
+	default:
+		return nil, fmt.Errorf("unsupported storage type: %s", cfg.Storage.Type)
+	}
+}
+
+// isHiddenKey 判断 key 中是否有以 . 开头的部分，这些对象是任务存储或清理索引等内部文件，不出现在 List 的结果中
+func isHiddenKey(key string) bool {
+	for _, part := range strings.Split(key, "/") {
+		if strings.HasPrefix(part, ".") {
+			return true
+		}
+	}
+	return false
+}
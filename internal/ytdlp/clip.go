@@ -0,0 +1,122 @@
+package ytdlp
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidClip 片段的起止时间无效
+var ErrInvalidClip = errors.New("invalid clip range")
+
+// ClipRange 表示视频片段的起止时间，单位：秒
+type ClipRange struct {
+	// 开始时间
+	Start int `json:"start" example:"60"`
+	// 结束时间，0 表示到视频结尾
+	End int `json:"end" example:"90"`
+}
+
+// String 返回 <start>-<end> 形式的片段描述，用于文件路径和 --download-sections
+func (c *ClipRange) String() string {
+	return fmt.Sprintf("%d-%d", c.Start, c.End)
+}
+
+// ParseClipRange 解析片段的起止时间，支持秒数（90）或 HH:MM:SS（00:01:30、1:30）
+// 起止时间都为空、或从 0 开始且没有结束时间时返回 nil，表示下载完整视频；结束时间为空表示到视频结尾
+func ParseClipRange(start, end string) (*ClipRange, error) {
+	if start == "" && end == "" {
+		return nil, nil
+	}
+
+	clip := &ClipRange{}
+	var err error
+	if start != "" {
+		if clip.Start, err = parseTimestamp(start); err != nil {
+			return nil, fmt.Errorf("%w: start: %v", ErrInvalidClip, err)
+		}
+	}
+	if end != "" {
+		if clip.End, err = parseTimestamp(end); err != nil {
+			return nil, fmt.Errorf("%w: end: %v", ErrInvalidClip, err)
+		}
+		if clip.End <= clip.Start {
+			return nil, fmt.Errorf("%w: end must be after start", ErrInvalidClip)
+		}
+	}
+	return clip.normalize(), nil
+}
+
+// normalize 覆盖完整视频的片段返回 nil，避免与完整视频使用不同的任务ID和存储路径
+func (c *ClipRange) normalize() *ClipRange {
+	if c == nil || (c.Start == 0 && c.End == 0) {
+		return nil
+	}
+	return c
+}
+
+// parseTimestamp 将秒数或 [HH:]MM:SS 解析为秒数
+func parseTimestamp(value string) (int, error) {
+	parts := strings.Split(strings.TrimSpace(value), ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("invalid timestamp: %s", value)
+	}
+
+	seconds := 0
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid timestamp: %s", value)
+		}
+		// 除第一段外，分和秒都不能超过 59
+		if i > 0 && n > 59 {
+			return 0, fmt.Errorf("invalid timestamp: %s", value)
+		}
+		seconds = seconds*60 + n
+	}
+	return seconds, nil
+}
+
+// resolveClip 根据视频时长校验片段，并将未指定的结束时间补全为视频时长
+// 返回新的 ClipRange，不修改传入的值；片段从头开始并到达视频结尾时返回 nil，按完整视频下载
+func (s *Service) resolveClip(url string, clip *ClipRange) (*ClipRange, error) {
+	rawInfo, err := s.getRawVideoInfo(url)
+	if err != nil {
+		return nil, err
+	}
+	// 时长可能带小数，向上取整，使结束时间可以覆盖最后不足一秒的部分
+	duration := int(math.Ceil(rawInfo.Duration))
+
+	resolved := *clip
+	if resolved.End == 0 {
+		// 直播等没有时长的视频必须指定结束时间
+		if duration <= 0 {
+			return nil, fmt.Errorf("%w: end is required when video duration is unknown", ErrInvalidClip)
+		}
+		resolved.End = duration
+	}
+	if resolved.End <= resolved.Start {
+		return nil, fmt.Errorf("%w: end must be after start", ErrInvalidClip)
+	}
+	if duration > 0 && resolved.End > duration {
+		return nil, fmt.Errorf("%w: end %ds exceeds video duration %ds", ErrInvalidClip, resolved.End, duration)
+	}
+	// 覆盖完整视频的片段与完整视频使用相同的任务ID和存储路径，也不需要在切割点重新编码
+	if duration > 0 && resolved.Start == 0 && resolved.End >= duration {
+		return nil, nil
+	}
+	return &resolved, nil
+}
+
+// getClipArgs 构建只下载片段的 yt-dlp 参数
+//
+//	--download-sections: 只下载指定时间段
+//	--force-keyframes-at-cuts: 在切割点重新编码关键帧，保证起止时间准确
+func getClipArgs(clip *ClipRange) []string {
+	return []string{
+		"--download-sections", "*" + clip.String(),
+		"--force-keyframes-at-cuts",
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !linux && !darwin
+
+package ytdlp
+
+import (
+	"errors"
+)
+
+// getFreeSpace 在不支持 statfs 的平台上无法获取剩余空间，就绪检查跳过剩余空间的判断
+func getFreeSpace(dir string) (int64, error) {
+	return 0, errors.ErrUnsupported
+}
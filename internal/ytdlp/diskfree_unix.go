@@ -0,0 +1,16 @@
+//go:build linux || darwin
+
+package ytdlp
+
+import (
+	"syscall"
+)
+
+// getFreeSpace 返回目录所在文件系统对非特权用户可用的剩余空间，单位：字节
+func getFreeSpace(dir string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
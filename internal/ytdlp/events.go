@@ -0,0 +1,76 @@
+package ytdlp
+
+import (
+	"sync"
+)
+
+// taskBroker 将任务状态变化分发给订阅者
+// 每个事件都是任务的完整快照，订阅者只关心最新状态，因此通道满时用新事件替换旧事件
+type taskBroker struct {
+	mutex       sync.Mutex
+	subscribers map[string]map[chan DownloadTask]struct{}
+}
+
+// subscribe 订阅任务的状态变化，返回事件通道和取消订阅函数
+func (b *taskBroker) subscribe(taskID string) (chan DownloadTask, func()) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.subscribers == nil {
+		b.subscribers = make(map[string]map[chan DownloadTask]struct{})
+	}
+	if b.subscribers[taskID] == nil {
+		b.subscribers[taskID] = make(map[chan DownloadTask]struct{})
+	}
+
+	ch := make(chan DownloadTask, 1)
+	b.subscribers[taskID][ch] = struct{}{}
+
+	unsubscribe := func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+
+		delete(b.subscribers[taskID], ch)
+		if len(b.subscribers[taskID]) == 0 {
+			delete(b.subscribers, taskID)
+		}
+	}
+	return ch, unsubscribe
+}
+
+// publish 向任务的所有订阅者发送最新快照，不会阻塞
+// 调用方持有 Service.mutex，同一任务的快照按 Revision 顺序发布
+func (b *taskBroker) publish(task DownloadTask) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for ch := range b.subscribers[task.ID] {
+		// 丢弃订阅者尚未读取的旧快照
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- task
+	}
+}
+
+// SubscribeTask 订阅任务的状态变化，返回订阅时的任务快照、后续快照的通道和取消订阅函数
+// 快照的 Revision 单调递增，订阅时刻前后的变化可能同时出现在快照和通道中，调用方需按 Revision 去重
+func (s *Service) SubscribeTask(taskID string) (*DownloadTask, <-chan DownloadTask, func(), error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	task, ok := s.downloads[taskID]
+	if !ok {
+		return nil, nil, nil, ErrTaskNotFound
+	}
+
+	ch, unsubscribe := s.events.subscribe(taskID)
+	snapshot := *task
+	return &snapshot, ch, unsubscribe, nil
+}
+
+// IsTaskFinished 判断任务是否已结束
+func IsTaskFinished(task *DownloadTask) bool {
+	return task.State == "completed" || task.State == "failed" || task.State == "cancelled"
+}
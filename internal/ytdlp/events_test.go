@@ -0,0 +1,250 @@
+package ytdlp
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/self-made-boy/youtube-tools/internal/config"
+	"github.com/self-made-boy/youtube-tools/internal/storage"
+)
+
+// receiveTask 从通道读取一个快照，超时时测试失败
+func receiveTask(t *testing.T, ch <-chan DownloadTask) DownloadTask {
+	t.Helper()
+	select {
+	case task := <-ch:
+		return task
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for task event")
+		return DownloadTask{}
+	}
+}
+
+// expectNoTask 确认通道中没有待读取的快照
+func expectNoTask(t *testing.T, ch <-chan DownloadTask) {
+	t.Helper()
+	select {
+	case task := <-ch:
+		t.Fatalf("unexpected task event: %+v", task)
+	default:
+	}
+}
+
+// TestTaskBroker 测试订阅、取消订阅以及只向同一任务的订阅者分发
+func TestTaskBroker(t *testing.T) {
+	var broker taskBroker
+	first, unsubscribeFirst := broker.subscribe("616263")
+	second, unsubscribeSecond := broker.subscribe("616263")
+	other, unsubscribeOther := broker.subscribe("646566")
+	defer unsubscribeOther()
+
+	broker.publish(DownloadTask{ID: "616263", State: "downloading", Revision: 1})
+	if got := receiveTask(t, first); got.Revision != 1 {
+		t.Errorf("first subscriber revision = %d, expected 1", got.Revision)
+	}
+	if got := receiveTask(t, second); got.Revision != 1 {
+		t.Errorf("second subscriber revision = %d, expected 1", got.Revision)
+	}
+	expectNoTask(t, other)
+
+	unsubscribeFirst()
+	broker.publish(DownloadTask{ID: "616263", State: "downloading", Revision: 2})
+	expectNoTask(t, first)
+	if got := receiveTask(t, second); got.Revision != 2 {
+		t.Errorf("second subscriber revision = %d, expected 2", got.Revision)
+	}
+
+	unsubscribeSecond()
+	if _, ok := broker.subscribers["616263"]; ok {
+		t.Error("subscribers of task not removed after all unsubscribed")
+	}
+	// 没有订阅者时发布不会阻塞
+	broker.publish(DownloadTask{ID: "616263", State: "completed", Revision: 3})
+}
+
+// TestTaskBroker_LatestSnapshot 测试订阅者未及时读取时只保留最新快照
+func TestTaskBroker_LatestSnapshot(t *testing.T) {
+	var broker taskBroker
+	ch, unsubscribe := broker.subscribe("616263")
+	defer unsubscribe()
+
+	for revision := int64(1); revision <= 3; revision++ {
+		broker.publish(DownloadTask{ID: "616263", State: "downloading", Revision: revision})
+	}
+	broker.publish(DownloadTask{ID: "616263", State: "completed", Revision: 4})
+
+	got := receiveTask(t, ch)
+	if got.Revision != 4 || got.State != "completed" {
+		t.Errorf("received revision %d state %s, expected the final snapshot", got.Revision, got.State)
+	}
+	expectNoTask(t, ch)
+}
+
+// TestService_SubscribeTask 测试订阅时返回当前快照，之后的变化按 Revision 递增推送，任务结束时推送最终状态
+func TestService_SubscribeTask(t *testing.T) {
+	service := &Service{
+		config:    &config.Config{},
+		logger:    zap.NewNop(),
+		downloads: make(map[string]*DownloadTask),
+		queue:     newDownloadQueue(),
+		store:     NewMemoryTaskStore(),
+	}
+	task := newTestTask("https://www.youtube.com/watch?v=abc", buildAudioFormatID("mp3", 48000, "bestaudio"))
+	service.downloads[task.ID] = task
+
+	if _, _, _, err := service.SubscribeTask("missing"); !errors.Is(err, ErrTaskNotFound) {
+		t.Fatalf("SubscribeTask(missing) error = %v, expected ErrTaskNotFound", err)
+	}
+
+	snapshot, events, unsubscribe, err := service.SubscribeTask(task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unsubscribe()
+	if snapshot.State != "pending" || snapshot.Revision != task.Revision {
+		t.Errorf("snapshot = %+v, expected the current pending task", snapshot)
+	}
+
+	service.updateTask(task, func(t *DownloadTask) {
+		t.State = "downloading"
+		t.Progress = 50
+	})
+	progress := receiveTask(t, events)
+	if progress.State != "downloading" || progress.Progress != 50 || progress.Revision <= snapshot.Revision {
+		t.Errorf("progress event = %+v, expected downloading at 50%% after revision %d", progress, snapshot.Revision)
+	}
+
+	if _, err := service.CancelDownload(task.ID); err != nil {
+		t.Fatal(err)
+	}
+	final := receiveTask(t, events)
+	if !IsTaskFinished(&final) || final.State != "cancelled" || final.Revision <= progress.Revision {
+		t.Errorf("final event = %+v, expected cancelled after revision %d", final, progress.Revision)
+	}
+	// 快照是副本，不随任务继续变化
+	if snapshot.State != "pending" {
+		t.Errorf("snapshot changed to %s after subscribe", snapshot.State)
+	}
+}
+
+// TestService_UpdateTask_PublishOrder 测试进度更新与取消并发时，订阅者最后收到的总是 Revision 最大的最终状态
+func TestService_UpdateTask_PublishOrder(t *testing.T) {
+	for range 10 {
+		service := &Service{
+			config:    &config.Config{},
+			logger:    zap.NewNop(),
+			downloads: make(map[string]*DownloadTask),
+			queue:     newDownloadQueue(),
+			store:     NewMemoryTaskStore(),
+		}
+		task := newTestTask("https://www.youtube.com/watch?v=abc", buildAudioFormatID("mp3", 48000, "bestaudio"))
+		service.downloads[task.ID] = task
+		_, events, unsubscribe, err := service.SubscribeTask(task.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// 取消时进度更新仍在进行
+		stop := make(chan struct{})
+		started := make(chan struct{})
+		var once sync.Once
+		var wg sync.WaitGroup
+		for range 4 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for n := 0; ; n++ {
+					if n == 2000 {
+						once.Do(func() { close(started) })
+					}
+					select {
+					case <-stop:
+						return
+					default:
+						service.updateTask(task, func(t *DownloadTask) { t.Progress++ })
+					}
+				}
+			}()
+		}
+		<-started
+		if _, err := service.CancelDownload(task.ID); err != nil {
+			t.Fatal(err)
+		}
+		close(stop)
+		wg.Wait()
+
+		var last DownloadTask
+		for drained := false; !drained; {
+			select {
+			case last = <-events:
+			default:
+				drained = true
+			}
+		}
+		unsubscribe()
+
+		service.mutex.RLock()
+		revision := task.Revision
+		service.mutex.RUnlock()
+		if last.Revision != revision {
+			t.Fatalf("last event revision = %d, expected the latest revision %d", last.Revision, revision)
+		}
+	}
+}
+
+// TestService_UpdateTask_ReplacedTask 测试任务取消后重新发起时，上一次执行的更新不再推送和持久化，新任务沿用 Revision
+func TestService_UpdateTask_ReplacedTask(t *testing.T) {
+	service := &Service{
+		config:    &config.Config{},
+		logger:    zap.NewNop(),
+		downloads: make(map[string]*DownloadTask),
+		queue:     newDownloadQueue(),
+		store:     NewMemoryTaskStore(),
+		infoLRU:   newInfoLRU(config.InfoMemoryCacheConfig{}),
+		storage:   storage.NewLocal(t.TempDir(), ""),
+	}
+	url, formatID := "https://www.youtube.com/watch?v=abc", buildAudioFormatID("mp3", 48000, "bestaudio")
+
+	taskID, err := service.StartDownload(url, formatID, DownloadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := service.downloads[taskID]
+	service.updateTask(old, func(t *DownloadTask) { t.State = "downloading" })
+	cancelled, err := service.CancelDownload(taskID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := service.StartDownload(url, formatID, DownloadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	snapshot, events, unsubscribe, err := service.SubscribeTask(taskID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unsubscribe()
+	if snapshot.State != "pending" || snapshot.Revision <= cancelled.Revision {
+		t.Fatalf("restarted task = state %s revision %d, expected pending after revision %d",
+			snapshot.State, snapshot.Revision, cancelled.Revision)
+	}
+
+	// 上一次执行仍在输出进度
+	service.updateTask(old, func(t *DownloadTask) {
+		t.State = "failed"
+		t.Progress = 99
+	})
+	expectNoTask(t, events)
+
+	tasks, err := service.store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 1 || tasks[0].State != "pending" || tasks[0].Revision != snapshot.Revision {
+		t.Errorf("stored tasks = %+v, expected the restarted pending task", tasks)
+	}
+}
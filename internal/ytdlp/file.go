@@ -0,0 +1,179 @@
+package ytdlp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"unicode"
+
+	"go.uber.org/zap"
+
+	"github.com/self-made-boy/youtube-tools/internal/storage"
+	"github.com/self-made-boy/youtube-tools/internal/utils"
+)
+
+// ErrTaskNotCompleted 下载任务尚未完成，没有可以下载的文件
+var ErrTaskNotCompleted = errors.New("download task has not completed")
+
+// maxFilenameLength 下载文件名中视频标题的最大字符数
+const maxFilenameLength = 120
+
+// contentTypes 各扩展名对应的 MIME 类型，系统 MIME 表中通常没有音视频格式
+var contentTypes = map[string]string{
+	// 音频
+	"mp3":  "audio/mpeg",
+	"m4a":  "audio/mp4",
+	"aac":  "audio/aac",
+	"opus": "audio/ogg",
+	"ogg":  "audio/ogg",
+	"flac": "audio/flac",
+	"wav":  "audio/wav",
+	// 视频
+	"mp4":  "video/mp4",
+	"webm": "video/webm",
+	"mkv":  "video/x-matroska",
+	"avi":  "video/x-msvideo",
+	"mov":  "video/quicktime",
+	"flv":  "video/x-flv",
+	// 字幕
+	"vtt":   "text/vtt; charset=utf-8",
+	"srt":   "application/x-subrip; charset=utf-8",
+	"json3": "application/json",
+	// 视频信息
+	"json": "application/json",
+}
+
+// ContentTypeByExt 返回扩展名对应的 MIME 类型，未知扩展名返回 application/octet-stream
+func ContentTypeByExt(ext string) string {
+	if contentType, ok := contentTypes[strings.TrimPrefix(ext, ".")]; ok {
+		return contentType
+	}
+	return "application/octet-stream"
+}
+
+// TaskFile 已完成任务在存储中的结果文件
+type TaskFile struct {
+	Reader      io.ReadSeekCloser
+	Info        storage.ObjectInfo
+	ContentType string
+	// 下载时使用的文件名，由视频标题和扩展名组成
+	Filename string
+}
+
+// OpenTaskFile 打开已完成任务的结果文件
+// 只能打开存在且已完成的任务，任务不存在时返回 ErrTaskNotFound，未完成时返回 ErrTaskNotCompleted
+// 开启 signed_url 时任务ID可以由视频地址推算，需要校验结果文件签名地址中的 expires 和 signature，
+// 签名错误或过期时返回 storage.ErrInvalidSignature 或 storage.ErrURLExpired
+func (s *Service) OpenTaskFile(ctx context.Context, taskID, expires, signature string) (*TaskFile, error) {
+	key, err := utils.FromHex(taskID)
+	if err != nil {
+		return nil, ErrTaskNotFound
+	}
+	// 先校验签名，没有签名时不暴露任务是否存在
+	if err := s.verifyFileSignature(key, expires, signature); err != nil {
+		return nil, err
+	}
+	task, err := s.GetDownloadStatus(taskID)
+	if err != nil {
+		return nil, err
+	}
+	if task.State != "completed" {
+		return nil, ErrTaskNotCompleted
+	}
+
+	info, err := s.storage.Stat(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := s.storage.Open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	s.access.touch(key)
+
+	videoID, _, _ := strings.Cut(key, "/")
+	return &TaskFile{
+		Reader:      reader,
+		Info:        info,
+		ContentType: ContentTypeByExt(path.Ext(key)),
+		Filename:    s.getTaskFilename(videoID, key),
+	}, nil
+}
+
+// verifyFileSignature 开启 signed_url 时校验文件的签名，未开启时不校验
+// 只有本地存储的签名由服务校验，使用对象存储时只能通过存储桶的预签名地址下载
+func (s *Service) verifyFileSignature(key, expires, signature string) error {
+	if !s.config.Storage.SignedURL.Enabled {
+		return nil
+	}
+	local, ok := s.storage.(*storage.LocalStorage)
+	if !ok {
+		return fmt.Errorf("%w: use the presigned download url", storage.ErrInvalidSignature)
+	}
+	return local.VerifySignature(key, expires, signature)
+}
+
+// getTaskFilename 返回结果文件的下载文件名，音视频为 <标题>.<ext>，字幕为 <标题>.<lang>.<ext>
+func (s *Service) getTaskFilename(videoID, key string) string {
+	base := path.Base(key)
+	return s.getFilenameTitle(videoID) + "." + strings.TrimPrefix(base, videoID+".")
+}
+
+// getFilenameTitle 返回下载文件名中的标题部分，没有缓存的视频信息时使用视频ID代替标题
+func (s *Service) getFilenameTitle(videoID string) string {
+	if rawInfo, ok := s.getCachedRawVideoInfo(videoID); ok {
+		if sanitized := sanitizeFilename(rawInfo.Title); sanitized != "" {
+			return sanitized
+		}
+	}
+	return videoID
+}
+
+// getCachedRawVideoInfo 从内存或存储中的缓存获取视频信息，不会执行 yt-dlp
+func (s *Service) getCachedRawVideoInfo(videoID string) (*RawVideoInfo, bool) {
+	if rawInfo, ok := s.infoLRU.get(videoID); ok {
+		return rawInfo, true
+	}
+	content, fetchedAt, ok := s.readCachedInfo(videoID)
+	if !ok {
+		return nil, false
+	}
+	rawInfo, err := ParseRawVideoInfo(content)
+	if err != nil {
+		s.logger.Warn("Failed to parse cached video info", zap.String("video_id", videoID), zap.Error(err))
+		return nil, false
+	}
+	s.infoLRU.add(videoID, rawInfo, int64(len(content)), fetchedAt.Add(s.getInfoCacheTTL()))
+	return rawInfo, true
+}
+
+// sanitizeFilename 将视频标题转换为可以作为文件名的字符串
+// 去掉路径分隔符、Windows 文件名中不允许的字符和控制字符，合并连续空白并截断到 maxFilenameLength 个字符
+func sanitizeFilename(title string) string {
+	var b strings.Builder
+	lastSpace := false
+	count := 0
+	for _, r := range title {
+		if count >= maxFilenameLength {
+			break
+		}
+		if strings.ContainsRune(`/\:*?"<>|`, r) || unicode.IsControl(r) || unicode.IsSpace(r) {
+			r = ' '
+		}
+		if r == ' ' {
+			if lastSpace {
+				continue
+			}
+			lastSpace = true
+		} else {
+			lastSpace = false
+		}
+		b.WriteRune(r)
+		count++
+	}
+	// 去掉首尾的空白和点，避免生成隐藏文件或 Windows 上无效的文件名
+	return strings.Trim(b.String(), " .")
+}
@@ -0,0 +1,149 @@
+package ytdlp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/self-made-boy/youtube-tools/internal/metrics"
+	"github.com/self-made-boy/youtube-tools/internal/storage"
+)
+
+// defaultInfoCacheTTL 未配置 info_cache_ttl 时视频信息缓存的有效期
+// yt-dlp 输出中的格式地址通常在 6 小时后过期，播放量等数据也会变化
+const defaultInfoCacheTTL = time.Hour
+
+// ErrorCodeFormatUnavailable 所选格式在重新获取的视频信息中已不存在时任务记录的错误码
+const ErrorCodeFormatUnavailable = "FORMAT_UNAVAILABLE"
+
+// ErrFormatUnavailable 所选格式已不存在，需要重新获取视频信息后选择格式
+var ErrFormatUnavailable = errors.New("requested format is no longer available")
+
+// staleFormatRegex 下载失败时 yt-dlp 输出的、说明格式地址已过期或所选格式可能已不存在的错误
+var staleFormatRegex = regexp.MustCompile(`HTTP Error 403|Requested format is not available|Signature extraction failed`)
+
+// getInfoCacheTTL 返回视频信息缓存的有效期
+func (s *Service) getInfoCacheTTL() time.Duration {
+	if s.config.Ytdlp.InfoCacheTTL > 0 {
+		return s.config.Ytdlp.InfoCacheTTL
+	}
+	return defaultInfoCacheTTL
+}
+
+// readCachedInfo 读取存储中缓存的视频信息 JSON 及其写入时间，不存在或超过有效期时返回 false
+func (s *Service) readCachedInfo(videoID string) ([]byte, time.Time, bool) {
+	ctx := context.Background()
+	key := getVideoJsonKey(videoID)
+
+	stat, err := s.storage.Stat(ctx, key)
+	if err != nil {
+		metrics.ObserveInfoCache(false)
+		return nil, time.Time{}, false
+	}
+	if age := time.Since(stat.ModTime); age > s.getInfoCacheTTL() {
+		s.logger.Info("Cached video info expired",
+			zap.String("video_id", videoID),
+			zap.Duration("age", age))
+		metrics.ObserveInfoCache(false)
+		return nil, time.Time{}, false
+	}
+
+	reader, err := s.storage.Open(ctx, key)
+	if err != nil {
+		metrics.ObserveInfoCache(false)
+		return nil, time.Time{}, false
+	}
+	defer reader.Close()
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		metrics.ObserveInfoCache(false)
+		return nil, time.Time{}, false
+	}
+	metrics.ObserveInfoCache(true)
+	s.access.touch(key)
+	return content, stat.ModTime, true
+}
+
+// writeCachedInfo 将视频信息 JSON 写入存储，失败时只记录日志
+func (s *Service) writeCachedInfo(videoID string, output []byte) {
+	key := getVideoJsonKey(videoID)
+	if err := s.storage.Put(context.Background(), key, bytes.NewReader(output)); err != nil {
+		s.logger.Error("Failed to write video info to storage", zap.Error(err))
+		return
+	}
+	s.access.touch(key)
+}
+
+// PurgeVideoInfo 删除内存和存储中的视频信息缓存，返回缓存是否存在
+func (s *Service) PurgeVideoInfo(videoID string) (bool, error) {
+	ctx := context.Background()
+	key := getVideoJsonKey(videoID)
+	inMemory := s.infoLRU.remove(videoID)
+
+	if _, err := s.storage.Stat(ctx, key); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return inMemory, nil
+		}
+		return inMemory, err
+	}
+	if err := s.storage.Delete(ctx, key); err != nil {
+		return inMemory, err
+	}
+
+	s.logger.Info("Purged cached video info", zap.String("video_id", videoID))
+	return true, nil
+}
+
+// isStaleFormatError 判断 yt-dlp 的错误输出是否说明缓存的格式信息已失效
+func isStaleFormatError(line string) bool {
+	return staleFormatRegex.MatchString(line)
+}
+
+// refreshFormatInfo 下载因格式信息失效而失败时重新获取视频信息，确认所选格式仍然存在
+// 下载命令会重新解析视频并拿到新的格式地址，格式仍然存在时重新执行一次下载即可；
+// 格式已不存在时返回 ErrFormatUnavailable，任务已重试过或无法获取视频信息时返回其他错误
+func (s *Service) refreshFormatInfo(task *DownloadTask) error {
+	s.mutex.Lock()
+	retried := task.infoRefreshed
+	task.infoRefreshed = true
+	s.mutex.Unlock()
+	if retried {
+		return errors.New("already retried with fresh video info")
+	}
+
+	// 忽略缓存重新获取，同时更新存储和内存中的缓存
+	rawInfo, err := s.executeYtdlpCommand(task.URL, true)
+	if err != nil {
+		return fmt.Errorf("failed to refresh video info: %w", err)
+	}
+	// 字幕没有格式ID
+	if s.IsSubtitleFormatID(task.Format) {
+		return nil
+	}
+
+	originalFormatIDs, err := s.getOriginalFormatIDs(task.Format)
+	if err != nil {
+		return err
+	}
+	// 格式选择器由 yt-dlp 按新的视频信息重新选择
+	if isFormatSelector(originalFormatIDs) {
+		return nil
+	}
+	for _, id := range strings.Split(originalFormatIDs, "+") {
+		if _, ok := rawInfo.findFormat(id); !ok {
+			return fmt.Errorf("%w: %s", ErrFormatUnavailable, id)
+		}
+	}
+
+	s.logger.Warn("Download failed with stale format info, requested format still available",
+		zap.String("task_id", task.ID),
+		zap.String("format", originalFormatIDs))
+	return nil
+}
@@ -0,0 +1,138 @@
+package ytdlp
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/self-made-boy/youtube-tools/internal/config"
+	"github.com/self-made-boy/youtube-tools/internal/metrics"
+)
+
+const (
+	// defaultInfoLRUMaxEntries 未配置 max_entries 时内存中最多缓存的视频数
+	defaultInfoLRUMaxEntries = 200
+	// defaultInfoLRUMaxBytes 未配置 max_bytes 时内存缓存的容量：256MB
+	defaultInfoLRUMaxBytes = 256 << 20
+)
+
+// infoLRU 解析后视频信息的内存 LRU 缓存，位于磁盘缓存之前
+// 缓存的 RawVideoInfo 会被多个请求共享，使用方不能修改
+type infoLRU struct {
+	mutex      sync.Mutex
+	maxEntries int
+	maxBytes   int64
+	bytes      int64
+	ll         *list.List
+	items      map[string]*list.Element
+}
+
+// infoLRUEntry 内存缓存中的一项，size 为 yt-dlp 输出的 JSON 大小，用于估算占用的内存
+type infoLRUEntry struct {
+	videoID   string
+	info      *RawVideoInfo
+	size      int64
+	expiresAt time.Time
+}
+
+// newInfoLRU 根据配置创建内存缓存，max_entries 为负数时不使用内存缓存
+func newInfoLRU(cfg config.InfoMemoryCacheConfig) *infoLRU {
+	if cfg.MaxEntries < 0 {
+		return nil
+	}
+	maxEntries := cfg.MaxEntries
+	if maxEntries == 0 {
+		maxEntries = defaultInfoLRUMaxEntries
+	}
+	maxBytes := cfg.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultInfoLRUMaxBytes
+	}
+	return &infoLRU{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+// get 返回未过期的缓存并将其移到队首，过期的缓存会被删除
+func (l *infoLRU) get(videoID string) (*RawVideoInfo, bool) {
+	if l == nil {
+		return nil, false
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	elem, ok := l.items[videoID]
+	if !ok {
+		metrics.ObserveInfoMemoryCache(false)
+		return nil, false
+	}
+	entry := elem.Value.(*infoLRUEntry)
+	if time.Now().After(entry.expiresAt) {
+		l.removeElement(elem)
+		l.updateMetrics()
+		metrics.ObserveInfoMemoryCache(false)
+		return nil, false
+	}
+
+	l.ll.MoveToFront(elem)
+	metrics.ObserveInfoMemoryCache(true)
+	return entry.info, true
+}
+
+// add 加入或替换缓存，超过条目数或容量时淘汰最久未使用的缓存
+// 单项大于容量的视频信息不缓存
+func (l *infoLRU) add(videoID string, info *RawVideoInfo, size int64, expiresAt time.Time) {
+	if l == nil || size > l.maxBytes {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if elem, ok := l.items[videoID]; ok {
+		l.removeElement(elem)
+	}
+	l.items[videoID] = l.ll.PushFront(&infoLRUEntry{
+		videoID:   videoID,
+		info:      info,
+		size:      size,
+		expiresAt: expiresAt,
+	})
+	l.bytes += size
+
+	for l.ll.Len() > l.maxEntries || l.bytes > l.maxBytes {
+		l.removeElement(l.ll.Back())
+	}
+	l.updateMetrics()
+}
+
+// remove 删除缓存，返回缓存是否存在
+func (l *infoLRU) remove(videoID string) bool {
+	if l == nil {
+		return false
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	elem, ok := l.items[videoID]
+	if !ok {
+		return false
+	}
+	l.removeElement(elem)
+	l.updateMetrics()
+	return true
+}
+
+// removeElement 从链表和索引中删除一项，调用方需持有锁
+func (l *infoLRU) removeElement(elem *list.Element) {
+	entry := l.ll.Remove(elem).(*infoLRUEntry)
+	delete(l.items, entry.videoID)
+	l.bytes -= entry.size
+}
+
+// updateMetrics 更新缓存条目数和占用大小指标，调用方需持有锁
+func (l *infoLRU) updateMetrics() {
+	metrics.SetInfoMemoryCacheSize(l.ll.Len(), l.bytes)
+}
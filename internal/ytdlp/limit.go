@@ -0,0 +1,136 @@
+package ytdlp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ErrorCodeFileTooLarge 文件超过 max_file_size 时任务记录的错误码
+const ErrorCodeFileTooLarge = "FILE_TOO_LARGE"
+
+// ErrFileTooLarge 文件大小超过配置的 max_file_size
+var ErrFileTooLarge = errors.New("file too large")
+
+// fileSizeCheckInterval 下载过程中检查临时文件大小的间隔
+const fileSizeCheckInterval = time.Second
+
+// checkFormatSize 根据缓存的视频信息估算所选格式的大小，超过 max_file_size 时返回 ErrFileTooLarge
+// 无法得知大小的格式不在这里拦截，由下载过程中的检查兜底；片段按时长比例估算
+func (s *Service) checkFormatSize(url, formatID string, clip *ClipRange) error {
+	maxFileSize := s.config.Ytdlp.MaxFileSize
+	// 字幕文件很小，不做预估
+	if maxFileSize <= 0 || s.IsSubtitleFormatID(formatID) {
+		return nil
+	}
+
+	originalFormatIDs, err := s.getOriginalFormatIDs(formatID)
+	if err != nil {
+		return err
+	}
+
+	// bestaudio、bestvideo[height<=720] 等格式选择器无法预先得知大小
+	if isFormatSelector(originalFormatIDs) {
+		return nil
+	}
+
+	rawInfo, err := s.getRawVideoInfo(url)
+	if err != nil {
+		return err
+	}
+
+	var estimated int64
+	for _, id := range strings.Split(originalFormatIDs, "+") {
+		format, ok := rawInfo.findFormat(id)
+		if !ok {
+			return nil
+		}
+		size := format.Size()
+		if size == 0 {
+			return nil
+		}
+		estimated += size
+	}
+
+	if clip != nil && rawInfo.Duration > 0 {
+		estimated = int64(float64(estimated) * float64(clip.End-clip.Start) / rawInfo.Duration)
+	}
+
+	if estimated > maxFileSize {
+		return fmt.Errorf("%w: estimated %d bytes, limit %d bytes", ErrFileTooLarge, estimated, maxFileSize)
+	}
+	return nil
+}
+
+// getOriginalFormatIDs 返回音频或视频格式ID中 yt-dlp 的原始格式ID，视频为 视频ID+音频ID
+func (s *Service) getOriginalFormatIDs(formatID string) (string, error) {
+	if s.IsVideoFormatID(formatID) {
+		_, _, vaFormatID, err := s.ParseVideoFormatID(formatID)
+		return vaFormatID, err
+	}
+	_, _, aFormatID, err := s.ParseAudioFormatID(formatID)
+	return aFormatID, err
+}
+
+// isFormatSelector 判断格式是否为 yt-dlp 格式选择器而不是具体的格式ID
+func isFormatSelector(formatIDs string) bool {
+	return strings.ContainsAny(formatIDs, "[]/") ||
+		strings.Contains(formatIDs, "best") ||
+		strings.Contains(formatIDs, "worst")
+}
+
+// watchFileSize 在下载过程中定期检查任务临时目录的大小，超过 max_file_size 时取消下载
+func (s *Service) watchFileSize(ctx context.Context, cancel context.CancelCauseFunc, task *DownloadTask, workDir string) {
+	maxFileSize := s.config.Ytdlp.MaxFileSize
+	if maxFileSize <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(fileSizeCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			written := workDirUsage(workDir)
+			if written > maxFileSize {
+				s.logger.Warn("Download exceeded max file size, aborting",
+					zap.String("task_id", task.ID),
+					zap.Int64("written", written),
+					zap.Int64("max_file_size", maxFileSize))
+				cancel(fmt.Errorf("%w: wrote %d bytes, limit %d bytes", ErrFileTooLarge, written, maxFileSize))
+				return
+			}
+		}
+	}
+}
+
+// workDirUsage 计算临时目录中已写入的字节数
+// 合并或转码时输入文件和输出文件会同时存在，分别统计后取较大值，避免重复计算
+func workDirUsage(workDir string) int64 {
+	var inputs, outputs int64
+	_ = filepath.WalkDir(workDir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if strings.Contains(d.Name(), ".temp.") {
+			outputs += info.Size()
+		} else {
+			inputs += info.Size()
+		}
+		return nil
+	})
+	return max(inputs, outputs)
+}
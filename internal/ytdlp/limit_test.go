@@ -0,0 +1,181 @@
+package ytdlp
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/self-made-boy/youtube-tools/internal/config"
+	"github.com/self-made-boy/youtube-tools/internal/storage"
+)
+
+// TestService_CheckFormatSize 测试根据视频信息预估所选格式的大小
+func TestService_CheckFormatSize(t *testing.T) {
+	service := New(&config.Config{}, zap.NewNop(), nil)
+	service.infoLRU.add("dQw4w9WgXcQ", &RawVideoInfo{
+		ID:       "dQw4w9WgXcQ",
+		Duration: 100,
+		Formats: []RawFormat{
+			{FormatID: "140", Ext: "m4a", Acodec: "mp4a.40.2", Vcodec: "none", Filesize: 600},
+			{FormatID: "137", Ext: "mp4", Acodec: "none", Vcodec: "avc1", FilesizeApprox: 500},
+			{FormatID: "251", Ext: "webm", Acodec: "opus", Vcodec: "none"},
+		},
+	}, 1, time.Now().Add(time.Minute))
+	const url = "https://www.youtube.com/watch?v=dQw4w9WgXcQ"
+
+	tests := []struct {
+		name        string
+		maxFileSize int64
+		formatID    string
+		clip        *ClipRange
+		expectErr   bool
+	}{
+		{name: "音频未超过限制", maxFileSize: 1000, formatID: buildAudioFormatID("m4a", 44100, "140")},
+		{name: "音频超过限制", maxFileSize: 500, formatID: buildAudioFormatID("m4a", 44100, "140"), expectErr: true},
+		{name: "视频和音频合计超过限制", maxFileSize: 1000, formatID: buildVideoFormatID("mp4", "1080p", "137", "140"), expectErr: true},
+		{name: "片段按时长比例估算", maxFileSize: 1000, formatID: buildVideoFormatID("mp4", "1080p", "137", "140"), clip: &ClipRange{Start: 0, End: 50}},
+		{name: "没有大小信息不拦截", maxFileSize: 1, formatID: buildAudioFormatID("opus", 48000, "251")},
+		{name: "未知格式不拦截", maxFileSize: 1, formatID: buildAudioFormatID("mp3", 48000, "999")},
+		{name: "格式选择器不拦截", maxFileSize: 1, formatID: buildAudioFormatID("mp3", 48000, "bestaudio")},
+		{name: "字幕不拦截", maxFileSize: 1, formatID: buildSubtitleFormatID("vtt", "en", false)},
+		{name: "未配置限制", maxFileSize: 0, formatID: buildAudioFormatID("m4a", 44100, "140")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service.config.Ytdlp.MaxFileSize = tt.maxFileSize
+			err := service.checkFormatSize(url, tt.formatID, tt.clip)
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("checkFormatSize() error = %v, expectErr %v", err, tt.expectErr)
+			}
+			if err != nil && !errors.Is(err, ErrFileTooLarge) {
+				t.Errorf("checkFormatSize() error = %v, expected ErrFileTooLarge", err)
+			}
+		})
+	}
+}
+
+// TestService_CheckFormatSize_VideoUnavailable 测试获取视频信息失败时返回 ErrVideoUnavailable 和 yt-dlp 的错误原因
+func TestService_CheckFormatSize_VideoUnavailable(t *testing.T) {
+	ytdlpPath := writeFakeYtdlp(t, `
+echo "WARNING: [youtube] unable to extract yt initial data" >&2
+echo "ERROR: [youtube] dQw4w9WgXcQ: Video unavailable" >&2
+exit 1
+`)
+	service := New(&config.Config{Ytdlp: config.YtdlpConfig{
+		Path:        ytdlpPath,
+		MaxFileSize: 1000,
+	}}, zap.NewNop(), storage.NewLocal(t.TempDir(), ""))
+
+	err := service.checkFormatSize("https://www.youtube.com/watch?v=dQw4w9WgXcQ", buildAudioFormatID("m4a", 44100, "140"), nil)
+	if !errors.Is(err, ErrVideoUnavailable) {
+		t.Fatalf("checkFormatSize() error = %v, expected ErrVideoUnavailable", err)
+	}
+	if !strings.Contains(err.Error(), "Video unavailable") {
+		t.Errorf("checkFormatSize() error = %v, expected yt-dlp error message", err)
+	}
+}
+
+// writeSizedFile 在 dir 下写入指定大小的文件
+func writeSizedFile(t *testing.T, dir, name string, size int) {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestWorkDirUsage 测试临时目录大小的统计，合并输出与输入文件分别统计取较大值
+func TestWorkDirUsage(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    map[string]int
+		expected int64
+	}{
+		{"空目录", nil, 0},
+		{"下载中的分片", map[string]int{"video.f137.mp4.part": 300, "video.f140.m4a": 200}, 500},
+		{"合并输出小于输入", map[string]int{"video.f137.mp4": 300, "video.f140.m4a": 200, "video.temp.mp4": 400}, 500},
+		{"转码输出大于输入", map[string]int{"video.mp4": 300, "video.temp.mkv": 700}, 700},
+		{"统计子目录", map[string]int{"video.mp4": 100, "frag/video.mp4.part-Frag1": 50}, 150},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, size := range tt.files {
+				writeSizedFile(t, dir, name, size)
+			}
+			if got := workDirUsage(dir); got != tt.expected {
+				t.Errorf("workDirUsage() = %d, expected %d", got, tt.expected)
+			}
+		})
+	}
+
+	if got := workDirUsage(filepath.Join(t.TempDir(), "missing")); got != 0 {
+		t.Errorf("workDirUsage() of missing dir = %d, expected 0", got)
+	}
+}
+
+// TestService_WatchFileSize 测试临时目录超过 max_file_size 时以 ErrFileTooLarge 取消下载
+func TestService_WatchFileSize(t *testing.T) {
+	service := &Service{
+		config: &config.Config{Ytdlp: config.YtdlpConfig{MaxFileSize: 1000}},
+		logger: zap.NewNop(),
+	}
+	task := &DownloadTask{ID: "616263"}
+
+	t.Run("超过限制时取消", func(t *testing.T) {
+		workDir := t.TempDir()
+		writeSizedFile(t, workDir, "video.mp4.part", 600)
+
+		ctx, cancel := context.WithCancelCause(context.Background())
+		defer cancel(nil)
+		done := make(chan struct{})
+		go func() {
+			service.watchFileSize(ctx, cancel, task, workDir)
+			close(done)
+		}()
+
+		writeSizedFile(t, workDir, "video.f140.m4a", 600)
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("watchFileSize did not abort the download")
+		}
+		if err := context.Cause(ctx); !errors.Is(err, ErrFileTooLarge) {
+			t.Errorf("context cause = %v, expected ErrFileTooLarge", err)
+		}
+	})
+
+	t.Run("下载结束时退出", func(t *testing.T) {
+		workDir := t.TempDir()
+		writeSizedFile(t, workDir, "video.mp4.part", 600)
+
+		ctx, cancel := context.WithCancelCause(context.Background())
+		done := make(chan struct{})
+		go func() {
+			service.watchFileSize(ctx, cancel, task, workDir)
+			close(done)
+		}()
+
+		time.Sleep(fileSizeCheckInterval + 100*time.Millisecond)
+		cancel(nil)
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("watchFileSize did not return after the download finished")
+		}
+		if err := context.Cause(ctx); !errors.Is(err, context.Canceled) {
+			t.Errorf("context cause = %v, expected context.Canceled", err)
+		}
+	})
+}
@@ -0,0 +1,202 @@
+package ytdlp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ErrInfoSchema yt-dlp 输出的 JSON 与预期的结构不一致，通常是 yt-dlp 升级后字段类型发生了变化
+var ErrInfoSchema = errors.New("unexpected yt-dlp info schema")
+
+// formatResolutionRegex 从格式描述中提取分辨率
+var formatResolutionRegex = regexp.MustCompile(`(\d+x\d+|\d+p)`)
+
+// RawVideoInfo yt-dlp --dump-json 输出的视频信息，只包含本服务用到的字段
+// 字段类型与 yt-dlp 文档一致，类型不符时解析失败并返回 ErrInfoSchema，而不是静默地得到零值
+type RawVideoInfo struct {
+	ID                   string                   `json:"id"`
+	Title                string                   `json:"title"`
+	FullTitle            string                   `json:"fulltitle"`
+	Description          string                   `json:"description"`
+	Duration             float64                  `json:"duration"`
+	DurationString       string                   `json:"duration_string"`
+	Thumbnail            string                   `json:"thumbnail"`
+	Thumbnails           []RawThumbnail           `json:"thumbnails"`
+	ViewCount            int64                    `json:"view_count"`
+	CommentCount         int64                    `json:"comment_count"`
+	LikeCount            int64                    `json:"like_count"`
+	UploadDate           string                   `json:"upload_date"`
+	Uploader             string                   `json:"uploader"`
+	UploaderID           string                   `json:"uploader_id"`
+	UploaderURL          string                   `json:"uploader_url"`
+	Channel              string                   `json:"channel"`
+	ChannelID            string                   `json:"channel_id"`
+	ChannelURL           string                   `json:"channel_url"`
+	ChannelFollowerCount int64                    `json:"channel_follower_count"`
+	SubscriberCount      int64                    `json:"subscriber_count"`
+	Categories           []string                 `json:"categories"`
+	Tags                 []string                 `json:"tags"`
+	WebpageURL           string                   `json:"webpage_url"`
+	OriginalURL          string                   `json:"original_url"`
+	Language             string                   `json:"language"`
+	LiveStatus           string                   `json:"live_status"`
+	IsLive               bool                     `json:"is_live"`
+	WasLive              bool                     `json:"was_live"`
+	AgeLimit             int                      `json:"age_limit"`
+	Availability         string                   `json:"availability"`
+	Formats              []RawFormat              `json:"formats"`
+	Subtitles            map[string][]RawSubtitle `json:"subtitles"`
+	AutomaticCaptions    map[string][]RawSubtitle `json:"automatic_captions"`
+	Chapters             []RawChapter             `json:"chapters"`
+	Heatmap              []RawHeatmapPoint        `json:"heatmap"`
+}
+
+// RawFormat yt-dlp 输出中的一个格式
+type RawFormat struct {
+	FormatID           string  `json:"format_id"`
+	FormatNote         string  `json:"format_note"`
+	Format             string  `json:"format"`
+	Ext                string  `json:"ext"`
+	Protocol           string  `json:"protocol"`
+	URL                string  `json:"url"`
+	Container          string  `json:"container"`
+	Acodec             string  `json:"acodec"`
+	Vcodec             string  `json:"vcodec"`
+	AudioExt           string  `json:"audio_ext"`
+	VideoExt           string  `json:"video_ext"`
+	Width              int     `json:"width"`
+	Height             int     `json:"height"`
+	Resolution         string  `json:"resolution"`
+	DynamicRange       string  `json:"dynamic_range"`
+	Fps                float64 `json:"fps"`
+	Tbr                float64 `json:"tbr"`
+	Abr                float64 `json:"abr"`
+	Vbr                float64 `json:"vbr"`
+	Asr                int64   `json:"asr"`
+	AudioChannels      int     `json:"audio_channels"`
+	Filesize           int64   `json:"filesize"`
+	FilesizeApprox     int64   `json:"filesize_approx"`
+	Quality            float64 `json:"quality"`
+	Language           string  `json:"language"`
+	LanguagePreference int     `json:"language_preference"`
+}
+
+// RawThumbnail yt-dlp 输出中的一个缩略图
+type RawThumbnail struct {
+	ID         string `json:"id"`
+	URL        string `json:"url"`
+	Preference int    `json:"preference"`
+	Width      int    `json:"width"`
+	Height     int    `json:"height"`
+	Resolution string `json:"resolution"`
+}
+
+// RawSubtitle yt-dlp 输出中某种语言的一个字幕格式
+type RawSubtitle struct {
+	Ext  string `json:"ext"`
+	URL  string `json:"url"`
+	Name string `json:"name"`
+}
+
+// RawChapter yt-dlp 输出中的一个章节，时间单位：秒
+type RawChapter struct {
+	Title     string  `json:"title"`
+	StartTime float64 `json:"start_time"`
+	EndTime   float64 `json:"end_time"`
+}
+
+// RawHeatmapPoint yt-dlp 输出中热度图的一个区间，时间单位：秒，value 为 0-1 的相对热度
+type RawHeatmapPoint struct {
+	StartTime float64 `json:"start_time"`
+	EndTime   float64 `json:"end_time"`
+	Value     float64 `json:"value"`
+}
+
+// ParseRawVideoInfo 解析 yt-dlp --dump-json 的输出
+// 字段类型不符或缺少必要字段时返回包装了 ErrInfoSchema 的错误，并指明出错的字段
+func ParseRawVideoInfo(data []byte) (*RawVideoInfo, error) {
+	var info RawVideoInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return nil, fmt.Errorf("%w: field %q expects %s but got %s", ErrInfoSchema, typeErr.Field, typeErr.Type, typeErr.Value)
+		}
+		return nil, fmt.Errorf("failed to parse video info: %w", err)
+	}
+
+	if info.ID == "" {
+		return nil, fmt.Errorf("%w: missing field \"id\"", ErrInfoSchema)
+	}
+	if len(info.Formats) == 0 {
+		return nil, fmt.Errorf("%w: missing field \"formats\"", ErrInfoSchema)
+	}
+	return &info, nil
+}
+
+// IsAudioOnly 判断是否为纯音频格式
+func (f *RawFormat) IsAudioOnly() bool {
+	return f.Vcodec == "none" && f.Acodec != "none" && f.Acodec != ""
+}
+
+// IsVideoOnly 判断是否为纯视频格式
+func (f *RawFormat) IsVideoOnly() bool {
+	return f.Acodec == "none" && f.Vcodec != "none" && f.Vcodec != ""
+}
+
+// IsStoryboard 判断是否为 storyboard 预览图格式
+func (f *RawFormat) IsStoryboard() bool {
+	return strings.Contains(f.FormatNote, "storyboard")
+}
+
+// Size 返回格式的文件大小，没有精确大小时使用估算值，都没有时返回 0
+func (f *RawFormat) Size() int64 {
+	if f.Filesize > 0 {
+		return f.Filesize
+	}
+	return f.FilesizeApprox
+}
+
+// EstimateSize 返回格式的预估文件大小，没有大小信息时按总码率和时长估算，无法估算时返回 0
+func (f *RawFormat) EstimateSize(duration float64) int64 {
+	if size := f.Size(); size > 0 {
+		return size
+	}
+	if f.Tbr > 0 && duration > 0 {
+		// tbr 单位为 Kbps
+		return int64(f.Tbr * 1000 / 8 * duration)
+	}
+	return 0
+}
+
+// GetResolution 返回格式的分辨率，依次尝试 resolution、width x height 和格式描述
+func (f *RawFormat) GetResolution() string {
+	// 尝试从 resolution 字段获取
+	if f.Resolution != "" {
+		return f.Resolution
+	}
+
+	// 尝试从 width 和 height 字段构建
+	if f.Width > 0 && f.Height > 0 {
+		return fmt.Sprintf("%dx%d", f.Width, f.Height)
+	}
+
+	// 尝试从格式描述中提取
+	if matches := formatResolutionRegex.FindStringSubmatch(f.Format); len(matches) > 0 {
+		return matches[0]
+	}
+
+	return "unknown"
+}
+
+// findFormat 按格式ID查找格式
+func (info *RawVideoInfo) findFormat(formatID string) (*RawFormat, bool) {
+	for i := range info.Formats {
+		if info.Formats[i].FormatID == formatID {
+			return &info.Formats[i], true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,188 @@
+package ytdlp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/self-made-boy/youtube-tools/internal/config"
+)
+
+// update 为 true 时用当前输出重新生成 golden 文件：go test ./internal/ytdlp -run Golden -update
+var update = flag.Bool("update", false, "update golden files")
+
+// exampleInfoPath 仓库根目录下随代码提供的 yt-dlp 输出样例
+const exampleInfoPath = "../../yt-dlp-example.json"
+
+// loadExampleInfo 读取并解析 yt-dlp 输出样例
+func loadExampleInfo(t *testing.T) *RawVideoInfo {
+	t.Helper()
+
+	data, err := os.ReadFile(exampleInfoPath)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", exampleInfoPath, err)
+	}
+	rawInfo, err := ParseRawVideoInfo(data)
+	if err != nil {
+		t.Fatalf("ParseRawVideoInfo(%s) returned error: %v", exampleInfoPath, err)
+	}
+	return rawInfo
+}
+
+// assertGolden 将 value 序列化为 JSON 后与 testdata 下的 golden 文件比较
+func assertGolden(t *testing.T, name string, value interface{}) {
+	t.Helper()
+
+	actual, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		t.Fatalf("failed to marshal %s: %v", name, err)
+	}
+	actual = append(actual, '\n')
+
+	goldenPath := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(goldenPath, actual, 0644); err != nil {
+			t.Fatalf("failed to update golden file %s: %v", goldenPath, err)
+		}
+		return
+	}
+
+	expected, err := os.ReadFile(goldenPath)
+	if err != nil {
+		t.Fatalf("failed to read golden file %s: %v", goldenPath, err)
+	}
+	if !bytes.Equal(actual, expected) {
+		t.Errorf("%s does not match golden file %s, run with -update to regenerate and review the diff", name, goldenPath)
+	}
+}
+
+// TestParseRawVideoInfo_Golden 测试 yt-dlp 输出样例解析后构建的视频信息与 golden 文件一致
+func TestParseRawVideoInfo_Golden(t *testing.T) {
+	rawInfo := loadExampleInfo(t)
+
+	service := New(&config.Config{
+		Ytdlp: config.YtdlpConfig{
+			AudioFormats: []string{"mp3", "m4a"},
+			VideoFormats: []string{"mp4", "webm"},
+		},
+	}, zap.NewNop(), nil)
+
+	assertGolden(t, "video_info.golden.json", service.buildVideoInfo(rawInfo, defaultFormatPolicy))
+}
+
+// TestParseRawVideoInfo_Example 测试 yt-dlp 输出样例中各部分都被解析
+func TestParseRawVideoInfo_Example(t *testing.T) {
+	rawInfo := loadExampleInfo(t)
+
+	if rawInfo.ID != "dQw4w9WgXcQ" {
+		t.Errorf("ID = %q, expected %q", rawInfo.ID, "dQw4w9WgXcQ")
+	}
+	if rawInfo.Duration != 213 {
+		t.Errorf("Duration = %v, expected 213", rawInfo.Duration)
+	}
+	if len(rawInfo.Formats) == 0 {
+		t.Error("Formats is empty")
+	}
+	if len(rawInfo.Thumbnails) == 0 {
+		t.Error("Thumbnails is empty")
+	}
+	if len(rawInfo.Heatmap) == 0 {
+		t.Error("Heatmap is empty")
+	}
+	if len(rawInfo.Subtitles) == 0 || len(rawInfo.AutomaticCaptions) == 0 {
+		t.Error("Subtitles or AutomaticCaptions is empty")
+	}
+
+	format, ok := rawInfo.findFormat("251")
+	if !ok {
+		t.Fatal("format 251 not found")
+	}
+	if !format.IsAudioOnly() || format.Asr != 48000 || format.Abr == 0 {
+		t.Errorf("format 251 = %+v, expected audio only with asr 48000", format)
+	}
+}
+
+// TestParseRawVideoInfo_Schema 测试字段类型变化或缺少必要字段时返回 ErrInfoSchema
+func TestParseRawVideoInfo_Schema(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		schemaErr bool
+	}{
+		{name: "字段类型变化", input: `{"id": "abc", "duration": "213", "formats": [{"format_id": "1"}]}`, schemaErr: true},
+		{name: "格式字段类型变化", input: `{"id": "abc", "formats": [{"format_id": "1", "asr": "48000"}]}`, schemaErr: true},
+		{name: "缺少ID", input: `{"formats": [{"format_id": "1"}]}`, schemaErr: true},
+		{name: "缺少格式", input: `{"id": "abc"}`, schemaErr: true},
+		{name: "非法JSON", input: `{"id": `, schemaErr: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseRawVideoInfo([]byte(tt.input))
+			if err == nil {
+				t.Fatalf("ParseRawVideoInfo(%q) expected error, but got none", tt.input)
+			}
+			if errors.Is(err, ErrInfoSchema) != tt.schemaErr {
+				t.Errorf("ParseRawVideoInfo(%q) error = %v, expected schema error: %v", tt.input, err, tt.schemaErr)
+			}
+		})
+	}
+}
+
+// TestBuildVideoInfo_Policy 测试 compatible 规则优先选择无需转码的编码
+func TestBuildVideoInfo_Policy(t *testing.T) {
+	rawInfo := loadExampleInfo(t)
+
+	service := New(&config.Config{
+		Ytdlp: config.YtdlpConfig{
+			AudioFormats: []string{"m4a"},
+			VideoFormats: []string{"mp4", "webm"},
+		},
+	}, zap.NewNop(), nil)
+
+	info := service.buildVideoInfo(rawInfo, FormatPolicy{PolicyCompatible, PolicyQuality})
+
+	for _, group := range info.Video {
+		for _, format := range group.Formats {
+			// 样例中 1440p 及以上只有 vp9，其他分辨率两种编码都有
+			if format.Resolution == "3840x2160" || format.Resolution == "2560x1440" {
+				continue
+			}
+			if !isVideoCodecCompatible(format.Vcodec, group.Ext) {
+				t.Errorf("%s %s vcodec = %s, expected compatible codec", group.Ext, format.Resolution, format.Vcodec)
+			}
+			if !isAudioCodecCompatible(format.Acodec, group.Ext) {
+				t.Errorf("%s %s acodec = %s, expected compatible codec", group.Ext, format.Resolution, format.Acodec)
+			}
+		}
+	}
+
+	for _, group := range info.Audio {
+		for _, format := range group.Formats {
+			if format.Asr == 44100 && codecFamily(format.Acodec) != "aac" {
+				t.Errorf("%s %d acodec = %s, expected aac", group.Ext, format.Asr, format.Acodec)
+			}
+		}
+	}
+}
+
+// TestParseFormatPolicy 测试排序规则的解析
+func TestParseFormatPolicy(t *testing.T) {
+	policy, err := ParseFormatPolicy(" compatible, original_language,compatible ")
+	if err != nil {
+		t.Fatalf("ParseFormatPolicy returned error: %v", err)
+	}
+	if len(policy) != 2 || policy[0] != PolicyCompatible || policy[1] != PolicyOriginalLanguage {
+		t.Errorf("ParseFormatPolicy = %v, expected [compatible original_language]", policy)
+	}
+
+	if _, err := ParseFormatPolicy("fastest"); !errors.Is(err, ErrInvalidPolicy) {
+		t.Errorf("ParseFormatPolicy(fastest) error = %v, expected ErrInvalidPolicy", err)
+	}
+}
@@ -0,0 +1,442 @@
+package ytdlp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/self-made-boy/youtube-tools/internal/metrics"
+)
+
+// defaultMaxPlaylistEntries 未配置 max_playlist_entries 时最多展开的条目数
+const defaultMaxPlaylistEntries = 500
+
+// playlistCacheTTL 播放列表展开结果在内存中的缓存时间，分页请求复用同一次展开结果
+const playlistCacheTTL = 10 * time.Minute
+
+// channelTabs 频道链接支持的标签页
+var channelTabs = []string{"videos", "shorts", "streams"}
+
+// PlaylistInfo 表示播放列表或频道的信息
+type PlaylistInfo struct {
+	// 播放列表ID
+	ID string `json:"id" example:"PLFgquLnL59alCl_2TQvOiD5Vgm1hCaGSI"`
+	// 播放列表标题
+	Title string `json:"title" example:"Popular Music Videos"`
+	// 频道名称
+	ChannelName string `json:"channel" example:"Rick Astley"`
+	// 播放列表网页URL
+	WebpageURL string `json:"webpage_url" example:"https://www.youtube.com/playlist?list=PLFgquLnL59alCl_2TQvOiD5Vgm1hCaGSI"`
+	// 条目总数，最多为 max_playlist_entries
+	Total int `json:"total" example:"120"`
+	// 播放列表条目超过 max_playlist_entries 时为 true，超出部分不会返回
+	Truncated bool `json:"truncated" example:"false"`
+	// 当前页码，从 1 开始
+	Page int `json:"page" example:"1"`
+	// 每页条目数
+	PageSize int `json:"page_size" example:"50"`
+	// 当前页的条目
+	Entries []PlaylistEntry `json:"entries"`
+}
+
+// PlaylistEntry 表示播放列表中的一个视频
+type PlaylistEntry struct {
+	// 视频ID
+	ID string `json:"id" example:"dQw4w9WgXcQ"`
+	// 视频标题
+	Title string `json:"title" example:"Rick Astley - Never Gonna Give You Up"`
+	// 视频时长
+	Duration int `json:"duration" example:"213"`
+	// 视频缩略图
+	Thumbnail string `json:"thumbnail" example:"https://i.ytimg.com/vi/dQw4w9WgXcQ/hqdefault.jpg"`
+	// 视频链接
+	URL string `json:"url" example:"https://www.youtube.com/watch?v=dQw4w9WgXcQ"`
+}
+
+// PlaylistFormatPreference 批量下载时使用的格式偏好
+// 播放列表中每个视频的格式各不相同，因此使用 yt-dlp 的格式选择器而不是具体的格式ID
+type PlaylistFormatPreference struct {
+	// 类型，audio 或 video
+	Type string
+	// 目标文件扩展名
+	Ext string
+	// 视频最大高度，0 表示不限制
+	MaxHeight int
+}
+
+// PlaylistDownloadItem 表示批量下载中一个视频的任务创建结果
+type PlaylistDownloadItem struct {
+	// 视频ID
+	VideoID string `json:"video_id" example:"dQw4w9WgXcQ"`
+	// 任务ID，创建失败时为空
+	TaskID string `json:"task_id,omitempty" example:"123456"`
+	// 创建失败的原因
+	Error string `json:"error,omitempty"`
+}
+
+// cachedPlaylist 缓存的播放列表展开结果
+type cachedPlaylist struct {
+	info      *PlaylistInfo
+	fetchedAt time.Time
+}
+
+// playlistCache 播放列表展开结果的内存缓存
+type playlistCache struct {
+	mutex   sync.Mutex
+	entries map[string]cachedPlaylist
+}
+
+// get 获取未过期的缓存
+func (c *playlistCache) get(key string) (*PlaylistInfo, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	cached, ok := c.entries[key]
+	if !ok || time.Since(cached.fetchedAt) > playlistCacheTTL {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return cached.info, true
+}
+
+// set 写入缓存，同时清理过期的条目
+func (c *playlistCache) set(key string, info *PlaylistInfo) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[string]cachedPlaylist)
+	}
+	for k, cached := range c.entries {
+		if time.Since(cached.fetchedAt) > playlistCacheTTL {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = cachedPlaylist{info: info, fetchedAt: time.Now()}
+}
+
+// CheckPlaylistUrl 检查URL是否为有效的YouTube播放列表或频道链接，返回纯净的链接和播放列表标识
+//
+// 支持以下形式：
+//
+//	https://www.youtube.com/playlist?list=ID
+//	https://www.youtube.com/watch?v=VIDEO&list=ID（取其中的播放列表）
+//	https://www.youtube.com/@handle[/videos|/shorts|/streams]
+//	https://www.youtube.com/channel/UCxxx[/videos|/shorts|/streams]
+//	https://www.youtube.com/c/name、https://www.youtube.com/user/name
+//
+// 频道链接未指定标签页时默认使用 videos。
+// 同时带有 v 和 list 参数的观看页只有在播放列表接口中才按播放列表展开，CheckUrl 和 CheckVideoUrl 将其视为视频
+func (s *Service) CheckPlaylistUrl(urlStr string) (string, string, error) {
+	urlStr = strings.TrimSpace(urlStr)
+	if !strings.Contains(urlStr, "://") {
+		urlStr = "https://" + urlStr
+	}
+
+	parsedURL, err := url.Parse(urlStr)
+	if err != nil {
+		return "", "", err
+	}
+	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
+		return "", "", fmt.Errorf("invalid URL scheme: %s", parsedURL.Scheme)
+	}
+
+	switch strings.ToLower(parsedURL.Hostname()) {
+	case "www.youtube.com", "youtube.com", "m.youtube.com", "music.youtube.com":
+	default:
+		return "", "", fmt.Errorf("invalid URL host: %s", parsedURL.Host)
+	}
+
+	path := strings.TrimSuffix(parsedURL.Path, "/")
+
+	// 播放列表
+	if path == "/playlist" || path == "/watch" {
+		listID := parsedURL.Query().Get("list")
+		if listID == "" {
+			return "", "", fmt.Errorf("missing playlist ID in URL")
+		}
+		if !videoIDRegex.MatchString(listID) {
+			return "", "", fmt.Errorf("invalid playlist ID: %s", listID)
+		}
+		return "https://www.youtube.com/playlist?list=" + listID, listID, nil
+	}
+
+	// 频道：/@handle、/channel/ID、/c/name、/user/name，可选标签页
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	var channel []string
+	switch {
+	case len(parts) >= 1 && strings.HasPrefix(parts[0], "@") && len(parts[0]) > 1:
+		channel, parts = parts[:1], parts[1:]
+	case len(parts) >= 2 && (parts[0] == "channel" || parts[0] == "c" || parts[0] == "user") && parts[1] != "":
+		channel, parts = parts[:2], parts[2:]
+	default:
+		return "", "", fmt.Errorf("invalid playlist URL path: %s", parsedURL.Path)
+	}
+
+	tab := "videos"
+	if len(parts) > 1 {
+		return "", "", fmt.Errorf("invalid playlist URL path: %s", parsedURL.Path)
+	}
+	if len(parts) == 1 {
+		if !slices.Contains(channelTabs, parts[0]) {
+			return "", "", fmt.Errorf("unsupported channel tab: %s", parts[0])
+		}
+		tab = parts[0]
+	}
+
+	playlistID := strings.Join(append(channel, tab), "/")
+	return "https://www.youtube.com/" + playlistID, playlistID, nil
+}
+
+// GetPlaylistInfo 获取播放列表或频道的条目，page 从 1 开始
+func (s *Service) GetPlaylistInfo(urlStr string, page, pageSize int) (*PlaylistInfo, error) {
+	s.logger.Info("Getting playlist info", zap.String("url", urlStr))
+
+	full, err := s.expandPlaylist(urlStr)
+	if err != nil {
+		return nil, err
+	}
+
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = len(full.Entries)
+	}
+
+	info := *full
+	info.Page = page
+	info.PageSize = pageSize
+	info.Entries = []PlaylistEntry{}
+
+	start := (page - 1) * pageSize
+	if start < len(full.Entries) {
+		end := min(start+pageSize, len(full.Entries))
+		info.Entries = full.Entries[start:end]
+	}
+
+	return &info, nil
+}
+
+// StartPlaylistDownload 为播放列表中的每个视频创建一个下载任务，limit 为 0 时处理全部条目
+// opts 应用到每个任务上，设置回调地址时每个任务结束都会单独回调
+func (s *Service) StartPlaylistDownload(urlStr string, pref PlaylistFormatPreference, limit int, opts DownloadOptions) ([]PlaylistDownloadItem, error) {
+	formatID, err := s.BuildPreferredFormatID(pref)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.validatePreset(formatID, opts.Preset); err != nil {
+		return nil, err
+	}
+	if opts.CallbackURL != "" {
+		if err := s.ValidateCallbackURL(opts.CallbackURL); err != nil {
+			return nil, err
+		}
+	}
+
+	full, err := s.expandPlaylist(urlStr)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := full.Entries
+	if limit > 0 && limit < len(entries) {
+		entries = entries[:limit]
+	}
+
+	items := make([]PlaylistDownloadItem, 0, len(entries))
+	for _, entry := range entries {
+		item := PlaylistDownloadItem{VideoID: entry.ID}
+		taskID, err := s.StartDownload(entry.URL, formatID, opts)
+		if err != nil {
+			item.Error = err.Error()
+		} else {
+			item.TaskID = taskID
+		}
+		items = append(items, item)
+	}
+
+	s.logger.Info("Playlist download tasks created",
+		zap.String("playlist_id", full.ID),
+		zap.Int("count", len(items)))
+
+	return items, nil
+}
+
+// BuildPreferredFormatID 根据格式偏好构建格式 ID，格式 ID 的结构与 GetVideoInfo 返回的一致
+//
+// 音频使用 bestaudio，采样率记为 0；视频使用 bestvideo[height<=N]+bestaudio，分辨率记为 Np 或 best
+func (s *Service) BuildPreferredFormatID(pref PlaylistFormatPreference) (string, error) {
+	switch pref.Type {
+	case "audio":
+		if !slices.Contains(s.config.Ytdlp.AudioFormats, pref.Ext) {
+			return "", fmt.Errorf("unsupported audio format: %s", pref.Ext)
+		}
+		return buildAudioFormatID(pref.Ext, 0, "bestaudio"), nil
+	case "video":
+		if !slices.Contains(s.config.Ytdlp.VideoFormats, pref.Ext) {
+			return "", fmt.Errorf("unsupported video format: %s", pref.Ext)
+		}
+		if pref.MaxHeight < 0 {
+			return "", fmt.Errorf("invalid max height: %d", pref.MaxHeight)
+		}
+		if pref.MaxHeight == 0 {
+			return buildVideoFormatID(pref.Ext, "best", "bestvideo", "bestaudio"), nil
+		}
+		resolution := fmt.Sprintf("%dp", pref.MaxHeight)
+		vFormat := fmt.Sprintf("bestvideo[height<=%d]", pref.MaxHeight)
+		return buildVideoFormatID(pref.Ext, resolution, vFormat, "bestaudio"), nil
+	default:
+		return "", fmt.Errorf("invalid format type: %s", pref.Type)
+	}
+}
+
+// expandPlaylist 展开播放列表的全部条目，结果在内存中缓存一段时间
+func (s *Service) expandPlaylist(urlStr string) (*PlaylistInfo, error) {
+	normalizedURL, playlistID, err := s.CheckPlaylistUrl(urlStr)
+	if err != nil {
+		return nil, err
+	}
+
+	if info, ok := s.playlists.get(playlistID); ok {
+		return info, nil
+	}
+
+	// 使用singleflight确保同一播放列表只展开一次
+	result, err, _ := s.group.Do("playlist:"+playlistID, func() (interface{}, error) {
+		return s.doExpandPlaylist(normalizedURL)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	info := result.(*PlaylistInfo)
+	s.playlists.set(playlistID, info)
+	return info, nil
+}
+
+// rawPlaylist yt-dlp --flat-playlist --dump-single-json 的输出
+type rawPlaylist struct {
+	ID         string `json:"id"`
+	Title      string `json:"title"`
+	Channel    string `json:"channel"`
+	Uploader   string `json:"uploader"`
+	WebpageURL string `json:"webpage_url"`
+	Entries    []struct {
+		ID         string  `json:"id"`
+		Title      string  `json:"title"`
+		Duration   float64 `json:"duration"`
+		IEKey      string  `json:"ie_key"`
+		Thumbnails []struct {
+			URL string `json:"url"`
+		} `json:"thumbnails"`
+	} `json:"entries"`
+}
+
+// doExpandPlaylist 执行 yt-dlp 命令展开播放列表
+func (s *Service) doExpandPlaylist(normalizedURL string) (*PlaylistInfo, error) {
+	maxEntries := s.config.Ytdlp.MaxPlaylistEntries
+	if maxEntries <= 0 {
+		maxEntries = defaultMaxPlaylistEntries
+	}
+
+	// 构建命令参数，多取一个条目用于判断播放列表是否被截断
+	cmdArgs := []string{
+		"--flat-playlist",
+		"--dump-single-json",
+		"--playlist-end", fmt.Sprintf("%d", maxEntries+1),
+	}
+
+	// 添加 cookies 文件
+	if s.config.Ytdlp.CookiesPath != "" {
+		cmdArgs = append(cmdArgs, "--cookies", s.config.Ytdlp.CookiesPath)
+	}
+
+	// 添加代理配置
+	if s.config.Ytdlp.Proxy != "" {
+		cmdArgs = append(cmdArgs, "--proxy", s.config.Ytdlp.Proxy)
+	}
+
+	cmdArgs = append(cmdArgs, normalizedURL)
+
+	s.logger.Info("Executing yt-dlp command for playlist info",
+		zap.String("full_command", fmt.Sprintf("%s %s", s.config.Ytdlp.Path, strings.Join(cmdArgs, " "))))
+
+	start := time.Now()
+	output, err := exec.Command(s.config.Ytdlp.Path, cmdArgs...).Output()
+	duration := time.Since(start)
+	metrics.ObserveYtdlp("playlist", duration, err)
+	if err != nil {
+		if exitError, ok := err.(*exec.ExitError); ok {
+			s.logger.Error("yt-dlp playlist command failed",
+				zap.Error(err),
+				zap.String("stderr", string(exitError.Stderr)),
+				zap.Int("exit_code", exitError.ExitCode()),
+				zap.Duration("duration", duration))
+		} else {
+			s.logger.Error("Failed to execute yt-dlp playlist command",
+				zap.Error(err),
+				zap.Duration("duration", duration))
+		}
+		return nil, fmt.Errorf("failed to get playlist info: %w", err)
+	}
+
+	s.logger.Info("yt-dlp playlist command executed successfully",
+		zap.Duration("duration", duration),
+		zap.Int("output_size", len(output)))
+
+	var raw rawPlaylist
+	if err := json.Unmarshal(output, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse playlist info: %w", err)
+	}
+
+	info := &PlaylistInfo{
+		ID:          raw.ID,
+		Title:       raw.Title,
+		ChannelName: raw.Channel,
+		WebpageURL:  raw.WebpageURL,
+		Entries:     []PlaylistEntry{},
+	}
+	if info.ChannelName == "" {
+		info.ChannelName = raw.Uploader
+	}
+	if info.WebpageURL == "" {
+		info.WebpageURL = normalizedURL
+	}
+	if len(raw.Entries) > maxEntries {
+		raw.Entries = raw.Entries[:maxEntries]
+		info.Truncated = true
+	}
+
+	for _, entry := range raw.Entries {
+		// 跳过频道标签页等非视频条目
+		if entry.IEKey != "" && entry.IEKey != "Youtube" {
+			continue
+		}
+		if !videoIDRegex.MatchString(entry.ID) {
+			continue
+		}
+
+		playlistEntry := PlaylistEntry{
+			ID:       entry.ID,
+			Title:    entry.Title,
+			Duration: int(entry.Duration),
+			URL:      "https://www.youtube.com/watch?v=" + entry.ID,
+		}
+		// 缩略图按尺寸从小到大排列，取最后一个
+		if len(entry.Thumbnails) > 0 {
+			playlistEntry.Thumbnail = entry.Thumbnails[len(entry.Thumbnails)-1].URL
+		}
+		info.Entries = append(info.Entries, playlistEntry)
+	}
+	info.Total = len(info.Entries)
+
+	return info, nil
+}
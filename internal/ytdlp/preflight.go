@@ -0,0 +1,95 @@
+package ytdlp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/self-made-boy/youtube-tools/internal/config"
+)
+
+// ErrBinaryNotFound 外部程序不存在或无法执行
+var ErrBinaryNotFound = errors.New("binary not found")
+
+// versionCheckTimeout 获取外部程序版本的超时时间
+const versionCheckTimeout = 10 * time.Second
+
+// BinaryInfo 外部程序的检测结果
+type BinaryInfo struct {
+	// 解析后的可执行文件路径
+	Path string `json:"path" example:"/usr/local/bin/yt-dlp"`
+	// 版本号
+	Version string `json:"version" example:"2025.01.15"`
+}
+
+// Binaries 服务依赖的外部程序
+type Binaries struct {
+	Ytdlp  BinaryInfo `json:"yt_dlp"`
+	Ffmpeg BinaryInfo `json:"ffmpeg"`
+}
+
+// CheckBinaries 启动前检查 yt-dlp 和 ffmpeg 是否存在并获取版本
+// 任一程序不存在或无法执行时返回包装了 ErrBinaryNotFound 的错误
+func CheckBinaries(cfg config.YtdlpConfig) (Binaries, error) {
+	var binaries Binaries
+	var errs []error
+
+	ytdlp, err := checkBinary(cfg.Path, "--version")
+	if err != nil {
+		errs = append(errs, fmt.Errorf("yt-dlp: %w", err))
+	}
+	binaries.Ytdlp = ytdlp
+
+	ffmpeg, err := checkBinary(resolveFfmpegPath(cfg.FfmpegPath), "-version")
+	if err != nil {
+		errs = append(errs, fmt.Errorf("ffmpeg: %w", err))
+	}
+	binaries.Ffmpeg = ffmpeg
+
+	return binaries, errors.Join(errs...)
+}
+
+// resolveFfmpegPath 返回 ffmpeg 可执行文件路径
+// ffmpeg_path 与 yt-dlp 的 --ffmpeg-location 一致，可以是可执行文件或其所在目录，为空时从 PATH 中查找
+func resolveFfmpegPath(path string) string {
+	if path == "" {
+		return "ffmpeg"
+	}
+	if stat, err := os.Stat(path); err == nil && stat.IsDir() {
+		return filepath.Join(path, "ffmpeg")
+	}
+	return path
+}
+
+// checkBinary 解析可执行文件路径并执行 versionFlag 获取版本
+func checkBinary(path, versionFlag string) (BinaryInfo, error) {
+	resolved, err := exec.LookPath(path)
+	if err != nil {
+		return BinaryInfo{Path: path}, fmt.Errorf("%w: %v", ErrBinaryNotFound, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), versionCheckTimeout)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, resolved, versionFlag).Output()
+	if err != nil {
+		return BinaryInfo{Path: resolved}, fmt.Errorf("%w: %s %s failed: %v", ErrBinaryNotFound, resolved, versionFlag, err)
+	}
+	return BinaryInfo{Path: resolved, Version: parseVersion(string(output))}, nil
+}
+
+// parseVersion 从版本输出的第一行提取版本号
+// yt-dlp 只输出版本号，如 2025.01.15；ffmpeg 输出 ffmpeg version 6.1.1 Copyright ...
+func parseVersion(output string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(output), "\n")
+	fields := strings.Fields(line)
+	if len(fields) >= 3 && fields[1] == "version" {
+		return fields[2]
+	}
+	return strings.TrimSpace(line)
+}
@@ -0,0 +1,123 @@
+package ytdlp
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"go.uber.org/zap"
+
+	"github.com/self-made-boy/youtube-tools/internal/config"
+)
+
+// ErrInvalidPreset 转码预设不存在或不适用于所选格式
+var ErrInvalidPreset = errors.New("invalid preset")
+
+// presetNameRegex 预设名称会出现在文件路径中，只允许字母、数字、点、下划线和连字符
+var presetNameRegex = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// loadPresets 校验配置的转码预设，名称不合法的预设会被忽略
+func loadPresets(presets map[string]config.PresetConfig, logger *zap.Logger) map[string]config.PresetConfig {
+	valid := make(map[string]config.PresetConfig, len(presets))
+	for name, preset := range presets {
+		if !presetNameRegex.MatchString(name) {
+			logger.Error("Invalid preset name, ignoring preset", zap.String("preset", name))
+			continue
+		}
+		valid[name] = preset
+	}
+	return valid
+}
+
+// getPreset 按名称查找转码预设，名称为空时返回 nil
+func (s *Service) getPreset(name string) (*config.PresetConfig, error) {
+	if name == "" {
+		return nil, nil
+	}
+	preset, ok := s.presets[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q not found", ErrInvalidPreset, name)
+	}
+	return &preset, nil
+}
+
+// validatePreset 检查预设是否适用于所选格式
+// 字幕不转码，音频格式只能使用设置了音频项的预设
+func (s *Service) validatePreset(formatID, name string) error {
+	preset, err := s.getPreset(name)
+	if err != nil || preset == nil {
+		return err
+	}
+	if s.IsSubtitleFormatID(formatID) {
+		return fmt.Errorf("%w: presets are not supported for subtitles", ErrInvalidPreset)
+	}
+	if !s.IsVideoFormatID(formatID) && !presetHasAudio(preset) {
+		return fmt.Errorf("%w: %q has no audio options", ErrInvalidPreset, name)
+	}
+	return nil
+}
+
+// presetHasVideo 判断预设是否需要重新编码视频流
+func presetHasVideo(preset *config.PresetConfig) bool {
+	return preset != nil && (preset.VideoCodec != "" || preset.CRF != nil || preset.VideoBitrate != "" || preset.MaxHeight > 0)
+}
+
+// presetHasAudio 判断预设是否需要重新编码音频流
+func presetHasAudio(preset *config.PresetConfig) bool {
+	return preset != nil && (preset.AudioCodec != "" || preset.AudioBitrate != "" || preset.AudioChannels > 0 || preset.SampleRate > 0)
+}
+
+// presetVideoEncoder 返回预设的视频编码器，预设未指定时使用目标容器的编码器
+func presetVideoEncoder(preset *config.PresetConfig, containerEncoder string) string {
+	if preset != nil && preset.VideoCodec != "" {
+		return preset.VideoCodec
+	}
+	return containerEncoder
+}
+
+// presetAudioEncoder 返回预设的音频编码器，预设未指定时使用目标容器的编码器
+func presetAudioEncoder(preset *config.PresetConfig, containerEncoder string) string {
+	if preset != nil && preset.AudioCodec != "" {
+		return preset.AudioCodec
+	}
+	return containerEncoder
+}
+
+// getPresetVideoArgs 构建预设的视频编码参数，不包括编码器
+//
+//	-crf / -b:v: 质量或码率
+//	-vf scale: 高度超过 max_height 时按比例缩小，宽度取偶数
+func getPresetVideoArgs(preset *config.PresetConfig) []string {
+	var args []string
+	if preset.CRF != nil {
+		args = append(args, "-crf", strconv.Itoa(*preset.CRF))
+	}
+	if preset.VideoBitrate != "" {
+		args = append(args, "-b:v", preset.VideoBitrate)
+	}
+	if preset.MaxHeight > 0 {
+		// yt-dlp 按 shell 规则拆分参数，单引号保留滤镜表达式中转义的逗号
+		args = append(args, "-vf", fmt.Sprintf(`'scale=-2:min(ih\,%d)'`, preset.MaxHeight))
+	}
+	return args
+}
+
+// getPresetAudioArgs 构建预设的音频编码参数，不包括编码器
+//
+//	-b:a: 码率
+//	-ac: 声道数
+//	-ar: 采样率
+func getPresetAudioArgs(preset *config.PresetConfig) []string {
+	var args []string
+	if preset.AudioBitrate != "" {
+		args = append(args, "-b:a", preset.AudioBitrate)
+	}
+	if preset.AudioChannels > 0 {
+		args = append(args, "-ac", strconv.Itoa(preset.AudioChannels))
+	}
+	if preset.SampleRate > 0 {
+		args = append(args, "-ar", strconv.Itoa(preset.SampleRate))
+	}
+	return args
+}
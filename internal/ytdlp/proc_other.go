@@ -0,0 +1,10 @@
+//go:build !unix
+
+package ytdlp
+
+import (
+	"os/exec"
+)
+
+// setProcessGroup 在不支持进程组的平台上保持默认行为，取消时只结束 yt-dlp 进程
+func setProcessGroup(cmd *exec.Cmd) {}
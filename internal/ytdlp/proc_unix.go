@@ -0,0 +1,17 @@
+//go:build unix
+
+package ytdlp
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup 让命令运行在独立的进程组中，
+// 上下文取消时向整个进程组发送 SIGKILL，确保 yt-dlp 启动的 ffmpeg 子进程也被结束
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
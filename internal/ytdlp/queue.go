@@ -0,0 +1,112 @@
+package ytdlp
+
+import (
+	"sync"
+)
+
+// defaultMaxDownloads 未配置 max_downloads 时的默认并发下载数
+const defaultMaxDownloads = 2
+
+// downloadQueue 是一个先进先出的下载任务队列
+// worker 通过 pop 阻塞等待任务，队列中的任务可以查询排队位置或被移除
+type downloadQueue struct {
+	mutex sync.Mutex
+	cond  *sync.Cond
+	items []*DownloadTask
+}
+
+// newDownloadQueue 创建一个新的下载队列
+func newDownloadQueue() *downloadQueue {
+	q := &downloadQueue{}
+	q.cond = sync.NewCond(&q.mutex)
+	return q
+}
+
+// push 将任务加入队尾，返回任务的排队位置（从 1 开始）
+func (q *downloadQueue) push(task *DownloadTask) int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.items = append(q.items, task)
+	q.cond.Signal()
+	return len(q.items)
+}
+
+// pop 取出队首任务，队列为空时阻塞
+func (q *downloadQueue) pop() *DownloadTask {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for len(q.items) == 0 {
+		q.cond.Wait()
+	}
+
+	task := q.items[0]
+	q.items[0] = nil
+	q.items = q.items[1:]
+	return task
+}
+
+// remove 从队列中移除指定任务，返回任务是否在队列中
+func (q *downloadQueue) remove(taskID string) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for i, task := range q.items {
+		if task.ID == taskID {
+			q.items = append(q.items[:i], q.items[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// position 返回任务的排队位置（从 1 开始），不在队列中时返回 0
+func (q *downloadQueue) position(taskID string) int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for i, task := range q.items {
+		if task.ID == taskID {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// len 返回队列中等待的任务数量
+func (q *downloadQueue) len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return len(q.items)
+}
+
+// startWorkers 启动固定数量的下载 worker，数量由 max_downloads 决定
+func (s *Service) startWorkers() {
+	workers := s.config.Ytdlp.MaxDownloads
+	if workers <= 0 {
+		workers = defaultMaxDownloads
+	}
+
+	for i := 0; i < workers; i++ {
+		go s.worker()
+	}
+}
+
+// worker 从队列中依次取出任务并执行下载，随进程一直运行
+func (s *Service) worker() {
+	for {
+		s.runDownload(s.queue.pop())
+	}
+}
+
+// GetQueuePosition 获取任务在等待队列中的位置（从 1 开始），不在队列中时返回 0
+func (s *Service) GetQueuePosition(taskID string) int {
+	return s.queue.position(taskID)
+}
+
+// GetQueueLength 获取等待队列中的任务数量
+func (s *Service) GetQueueLength() int {
+	return s.queue.len()
+}
@@ -0,0 +1,144 @@
+package ytdlp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/self-made-boy/youtube-tools/internal/config"
+	"github.com/self-made-boy/youtube-tools/internal/storage"
+)
+
+// TestDownloadQueue 测试队列的先进先出顺序、排队位置和移除
+func TestDownloadQueue(t *testing.T) {
+	q := newDownloadQueue()
+	for i, id := range []string{"a", "b", "c", "d"} {
+		if position := q.push(&DownloadTask{ID: id}); position != i+1 {
+			t.Errorf("push(%s) position = %d, expected %d", id, position, i+1)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		taskID   string
+		expected int
+	}{
+		{"队首", "a", 1},
+		{"队尾", "d", 4},
+		{"不在队列中", "x", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := q.position(tt.taskID); got != tt.expected {
+				t.Errorf("position(%s) = %d, expected %d", tt.taskID, got, tt.expected)
+			}
+		})
+	}
+
+	if !q.remove("b") {
+		t.Error("remove(b) = false, expected true")
+	}
+	if q.remove("b") {
+		t.Error("remove(b) twice = true, expected false")
+	}
+	if got := q.position("c"); got != 2 {
+		t.Errorf("position(c) after remove = %d, expected 2", got)
+	}
+	if got := q.len(); got != 3 {
+		t.Errorf("len() = %d, expected 3", got)
+	}
+
+	for _, expected := range []string{"a", "c", "d"} {
+		if task := q.pop(); task.ID != expected {
+			t.Errorf("pop() = %s, expected %s", task.ID, expected)
+		}
+	}
+	if got := q.len(); got != 0 {
+		t.Errorf("len() after pop = %d, expected 0", got)
+	}
+}
+
+// TestDownloadQueue_PopBlocks 测试队列为空时 pop 阻塞到有任务加入
+func TestDownloadQueue_PopBlocks(t *testing.T) {
+	q := newDownloadQueue()
+	popped := make(chan *DownloadTask)
+	go func() {
+		popped <- q.pop()
+	}()
+
+	select {
+	case task := <-popped:
+		t.Fatalf("pop() returned %s from an empty queue", task.ID)
+	default:
+	}
+	q.push(&DownloadTask{ID: "a"})
+	if task := <-popped; task.ID != "a" {
+		t.Errorf("pop() = %s, expected a", task.ID)
+	}
+}
+
+// TestService_StartWorkers 测试同时执行的下载不超过 max_downloads，其余任务按顺序排队
+func TestService_StartWorkers(t *testing.T) {
+	// 写入 .part 文件后等待 download_dir 下出现 release 文件再完成下载
+	downloadDir := t.TempDir()
+	release := filepath.Join(t.TempDir(), "release")
+	ytdlpPath := writeFakeYtdlp(t, `
+out=""
+while [ $# -gt 0 ]; do
+	if [ "$1" = "-o" ]; then out="$2"; fi
+	shift
+done
+echo partial > "$out.part"
+while [ ! -e "`+release+`" ]; do sleep 0.02; done
+mv "$out.part" "$out"
+`)
+	service := &Service{
+		config: &config.Config{
+			Ytdlp: config.YtdlpConfig{Path: ytdlpPath, DownloadDir: downloadDir, MaxDownloads: 2},
+		},
+		logger:    zap.NewNop(),
+		downloads: make(map[string]*DownloadTask),
+		queue:     newDownloadQueue(),
+		store:     NewMemoryTaskStore(),
+		infoLRU:   newInfoLRU(config.InfoMemoryCacheConfig{}),
+		storage:   storage.NewLocal(t.TempDir(), ""),
+	}
+
+	var tasks []*DownloadTask
+	for _, videoID := range []string{"aaa", "bbb", "ccc"} {
+		task := newTestTask("https://www.youtube.com/watch?v="+videoID, buildAudioFormatID("mp3", 48000, "bestaudio"))
+		service.downloads[task.ID] = task
+		service.queue.push(task)
+		tasks = append(tasks, task)
+	}
+	service.startWorkers()
+
+	state := func(task *DownloadTask) string {
+		status, _ := service.GetDownloadStatus(task.ID)
+		return status.State
+	}
+	waitFor(t, "two downloads to start", func() bool {
+		matches, _ := filepath.Glob(filepath.Join(downloadDir, "*", "*", "*.part"))
+		return len(matches) == 2
+	})
+	if state(tasks[0]) != "downloading" || state(tasks[1]) != "downloading" {
+		t.Errorf("first two tasks = %s, %s, expected downloading", state(tasks[0]), state(tasks[1]))
+	}
+	if state(tasks[2]) != "pending" || service.GetQueuePosition(tasks[2].ID) != 1 {
+		t.Errorf("third task = %s at position %d, expected pending at position 1", state(tasks[2]), service.GetQueuePosition(tasks[2].ID))
+	}
+
+	if err := os.WriteFile(release, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "all downloads to complete", func() bool {
+		for _, task := range tasks {
+			if state(task) != "completed" {
+				return false
+			}
+		}
+		return true
+	})
+}
@@ -0,0 +1,248 @@
+package ytdlp
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// 格式排序规则，按顺序比较，前一条规则无法区分时再使用下一条，所有规则都无法区分时按质量比较
+const (
+	// PolicyQuality 优先最高质量：音频比较采样率、码率、大小，视频比较码率、帧率、大小
+	PolicyQuality = "quality"
+	// PolicyCompatible 优先编码与目标容器兼容、无需转码的格式
+	PolicyCompatible = "compatible"
+	// PolicySmallest 优先文件最小的格式
+	PolicySmallest = "smallest"
+	// PolicyOriginalLanguage 优先原始语言的音轨
+	PolicyOriginalLanguage = "original_language"
+)
+
+// rankingPolicies 支持的排序规则
+var rankingPolicies = []string{PolicyQuality, PolicyCompatible, PolicySmallest, PolicyOriginalLanguage}
+
+// defaultFormatPolicy 未配置 format_policy 时使用的排序规则，与最初的行为一致
+var defaultFormatPolicy = FormatPolicy{PolicyQuality}
+
+// ErrInvalidPolicy 格式排序规则无效
+var ErrInvalidPolicy = errors.New("invalid format policy")
+
+// FormatPolicy 格式排序规则列表
+type FormatPolicy []string
+
+// ParseFormatPolicy 解析逗号分隔的排序规则，如 compatible,quality
+// 为空时返回 nil，表示使用配置的默认规则
+func ParseFormatPolicy(value string) (FormatPolicy, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+
+	var policy FormatPolicy
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if !slices.Contains(rankingPolicies, name) {
+			return nil, fmt.Errorf("%w: %q, supported: %s", ErrInvalidPolicy, name, strings.Join(rankingPolicies, ", "))
+		}
+		if !slices.Contains(policy, name) {
+			policy = append(policy, name)
+		}
+	}
+	return policy, nil
+}
+
+// resolveFormatPolicy 返回实际使用的排序规则，请求未指定时使用配置的规则
+func (s *Service) resolveFormatPolicy(policy FormatPolicy) FormatPolicy {
+	if len(policy) > 0 {
+		return policy
+	}
+	return s.formatPolicy
+}
+
+// containerCodecs 目标容器转换时使用的编码及 ffmpeg 编码器，源编码一致时不需要转码
+// mkv 等未列出的容器可以直接封装任意编码
+var containerCodecs = map[string]struct {
+	video        string
+	audio        string
+	videoEncoder string
+	audioEncoder string
+}{
+	"mp4":  {video: "h264", audio: "aac", videoEncoder: "libx264", audioEncoder: "aac"},
+	"mov":  {video: "h264", audio: "aac", videoEncoder: "libx264", audioEncoder: "aac"},
+	"flv":  {video: "h264", audio: "aac", videoEncoder: "libx264", audioEncoder: "aac"},
+	"avi":  {video: "h264", audio: "mp3", videoEncoder: "libx264", audioEncoder: "libmp3lame"},
+	"webm": {video: "vp9", audio: "opus", videoEncoder: "libvpx-vp9", audioEncoder: "libopus"},
+	"mp3":  {audio: "mp3", audioEncoder: "libmp3lame"},
+	"m4a":  {audio: "aac", audioEncoder: "aac"},
+	"aac":  {audio: "aac", audioEncoder: "aac"},
+	"opus": {audio: "opus", audioEncoder: "libopus"},
+	"flac": {audio: "flac", audioEncoder: "flac"},
+	"wav":  {audio: "pcm", audioEncoder: "pcm_s16le"},
+}
+
+// codecFamily 将 yt-dlp 输出的编码字符串归一化，如 avc1.640028 -> h264、mp4a.40.2 -> aac
+func codecFamily(codec string) string {
+	codec = strings.ToLower(codec)
+	switch {
+	case strings.HasPrefix(codec, "avc"), strings.HasPrefix(codec, "h264"):
+		return "h264"
+	case strings.HasPrefix(codec, "hev"), strings.HasPrefix(codec, "hvc"), strings.HasPrefix(codec, "h265"):
+		return "hevc"
+	case strings.HasPrefix(codec, "vp09"), strings.HasPrefix(codec, "vp9"):
+		return "vp9"
+	case strings.HasPrefix(codec, "av01"), strings.HasPrefix(codec, "av1"):
+		return "av1"
+	case strings.HasPrefix(codec, "mp4a"), strings.HasPrefix(codec, "aac"):
+		return "aac"
+	case strings.HasPrefix(codec, "pcm"):
+		return "pcm"
+	default:
+		// opus、mp3、flac、vorbis 等
+		return codec
+	}
+}
+
+// isVideoCodecCompatible 判断视频编码无需转码即可放入目标容器
+func isVideoCodecCompatible(vcodec, ext string) bool {
+	codecs, ok := containerCodecs[ext]
+	if !ok || codecs.video == "" {
+		return true
+	}
+	return codecFamily(vcodec) == codecs.video
+}
+
+// isAudioCodecCompatible 判断音频编码无需转码即可放入目标容器
+func isAudioCodecCompatible(acodec, ext string) bool {
+	codecs, ok := containerCodecs[ext]
+	if !ok || codecs.audio == "" {
+		return true
+	}
+	return codecFamily(acodec) == codecs.audio
+}
+
+// formatRanker 按排序规则比较同一目标容器下的两个格式
+type formatRanker struct {
+	policy FormatPolicy
+	// 目标容器扩展名，用于判断编码是否兼容
+	ext string
+	// 视频的原始语言
+	language string
+}
+
+// newFormatRanker 创建格式比较器
+func newFormatRanker(policy FormatPolicy, ext string, rawInfo *RawVideoInfo) formatRanker {
+	return formatRanker{
+		policy:   policy,
+		ext:      ext,
+		language: rawInfo.Language,
+	}
+}
+
+// isAudioBetter 返回 true 表示音频格式 a 比 b 更好，完全相同时返回 true
+func (r formatRanker) isAudioBetter(a, b *RawFormat) bool {
+	for _, name := range r.policy {
+		var result int
+		switch name {
+		case PolicyQuality:
+			result = compareAudioQuality(a, b)
+		case PolicyCompatible:
+			result = compareBool(isAudioCodecCompatible(a.Acodec, r.ext), isAudioCodecCompatible(b.Acodec, r.ext))
+		case PolicySmallest:
+			result = compareSmaller(a.Size(), b.Size())
+		case PolicyOriginalLanguage:
+			result = compareBool(r.isOriginalLanguage(a), r.isOriginalLanguage(b))
+		}
+		if result != 0 {
+			return result > 0
+		}
+	}
+	return compareAudioQuality(a, b) >= 0
+}
+
+// isVideoBetter 返回 true 表示视频格式 a 比 b 更好，完全相同时返回 true
+// 视频格式没有音轨，original_language 规则对视频不起作用
+func (r formatRanker) isVideoBetter(a, b *RawFormat) bool {
+	for _, name := range r.policy {
+		var result int
+		switch name {
+		case PolicyQuality:
+			result = compareVideoQuality(a, b)
+		case PolicyCompatible:
+			result = compareBool(isVideoCodecCompatible(a.Vcodec, r.ext), isVideoCodecCompatible(b.Vcodec, r.ext))
+		case PolicySmallest:
+			result = compareSmaller(a.Size(), b.Size())
+		}
+		if result != 0 {
+			return result > 0
+		}
+	}
+	return compareVideoQuality(a, b) >= 0
+}
+
+// isOriginalLanguage 判断音轨是否为原始语言
+// yt-dlp 会给原始音轨更高的 language_preference，并在 format_note 中标注 original
+func (r formatRanker) isOriginalLanguage(f *RawFormat) bool {
+	if f.LanguagePreference > 0 || strings.Contains(strings.ToLower(f.FormatNote), "original") {
+		return true
+	}
+	return f.Language != "" && f.Language == r.language
+}
+
+// compareAudioQuality 依次比较采样率、码率和文件大小，a 更好时返回正数
+func compareAudioQuality(a, b *RawFormat) int {
+	if a.Asr != b.Asr {
+		return compareGreater(float64(a.Asr), float64(b.Asr))
+	}
+	if a.Abr != b.Abr {
+		return compareGreater(a.Abr, b.Abr)
+	}
+	return compareGreater(float64(a.Filesize), float64(b.Filesize))
+}
+
+// compareVideoQuality 依次比较码率、帧率和文件大小，a 更好时返回正数
+func compareVideoQuality(a, b *RawFormat) int {
+	if a.Vbr != b.Vbr {
+		return compareGreater(a.Vbr, b.Vbr)
+	}
+	if a.Fps != b.Fps {
+		return compareGreater(a.Fps, b.Fps)
+	}
+	return compareGreater(float64(a.Filesize), float64(b.Filesize))
+}
+
+// compareGreater 数值更大者更好
+func compareGreater(a, b float64) int {
+	switch {
+	case a > b:
+		return 1
+	case a < b:
+		return -1
+	}
+	return 0
+}
+
+// compareSmaller 文件更小者更好，大小未知的排在最后
+func compareSmaller(a, b int64) int {
+	switch {
+	case a == b:
+		return 0
+	case a == 0:
+		return -1
+	case b == 0:
+		return 1
+	case a < b:
+		return 1
+	}
+	return -1
+}
+
+// compareBool 满足条件者更好
+func compareBool(a, b bool) int {
+	switch {
+	case a && !b:
+		return 1
+	case !a && b:
+		return -1
+	}
+	return 0
+}
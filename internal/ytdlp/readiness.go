@@ -0,0 +1,161 @@
+package ytdlp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/self-made-boy/youtube-tools/internal/storage"
+)
+
+const (
+	// defaultMinFreeSpace 未配置 min_free_space 时要求的最小剩余空间：1GB
+	defaultMinFreeSpace = 1 << 30
+	// defaultMaxQueueLength 未配置 max_queue_length 时允许的最大等待队列长度
+	defaultMaxQueueLength = 100
+	// readinessCacheTTL 执行外部程序和写入目录的检查结果缓存时长，避免探针频繁启动进程和写入 S3
+	readinessCacheTTL = 30 * time.Second
+	// storageCheckTimeout 检查对象存储能否访问的超时时间
+	storageCheckTimeout = 10 * time.Second
+	// storageCheckKey 检查对象存储时查询的 key，不需要真实存在
+	storageCheckKey = ".readyz"
+)
+
+// ReadinessCheck 单项就绪检查的结果
+type ReadinessCheck struct {
+	// 检查项：yt_dlp、ffmpeg、storage、download_dir、cookies、queue
+	Name string `json:"name" example:"ffmpeg"`
+	// 是否通过
+	OK bool `json:"ok" example:"true"`
+	// 检查详情或失败原因
+	Message string `json:"message,omitempty" example:"6.1.1"`
+}
+
+// ReadinessReport 就绪检查结果，所有检查都通过时 Ready 为 true
+type ReadinessReport struct {
+	Ready  bool             `json:"ready" example:"true"`
+	Checks []ReadinessCheck `json:"checks"`
+}
+
+// readinessCache 缓存开销较大的检查结果
+type readinessCache struct {
+	mutex     sync.Mutex
+	checks    []ReadinessCheck
+	checkedAt time.Time
+}
+
+// CheckReadiness 检查服务是否可以接收下载请求
+// 外部程序和目录的检查结果缓存 readinessCacheTTL，cookies 和队列每次都检查
+func (s *Service) CheckReadiness() ReadinessReport {
+	checks := s.getCachedReadinessChecks()
+	checks = append(checks, s.checkCookies(), s.checkQueue())
+
+	report := ReadinessReport{Ready: true, Checks: checks}
+	for _, check := range checks {
+		if !check.OK {
+			report.Ready = false
+		}
+	}
+	return report
+}
+
+// getCachedReadinessChecks 返回外部程序和目录的检查结果，缓存过期时重新检查
+func (s *Service) getCachedReadinessChecks() []ReadinessCheck {
+	s.readiness.mutex.Lock()
+	defer s.readiness.mutex.Unlock()
+
+	if s.readiness.checks == nil || time.Since(s.readiness.checkedAt) > readinessCacheTTL {
+		s.readiness.checks = []ReadinessCheck{
+			binaryCheck("yt_dlp", s.config.Ytdlp.Path, "--version"),
+			binaryCheck("ffmpeg", resolveFfmpegPath(s.config.Ytdlp.FfmpegPath), "-version"),
+			s.checkStorage(),
+			s.checkDir("download_dir", s.config.Ytdlp.DownloadDir),
+		}
+		s.readiness.checkedAt = time.Now()
+	}
+	return append([]ReadinessCheck(nil), s.readiness.checks...)
+}
+
+// binaryCheck 检查外部程序能否执行
+func binaryCheck(name, path, versionFlag string) ReadinessCheck {
+	info, err := checkBinary(path, versionFlag)
+	if err != nil {
+		return ReadinessCheck{Name: name, Message: err.Error()}
+	}
+	return ReadinessCheck{Name: name, OK: true, Message: info.Version}
+}
+
+// checkDir 检查目录可写且剩余空间不低于 min_free_space，无法获取剩余空间的平台只检查可写
+func (s *Service) checkDir(name, dir string) ReadinessCheck {
+	if dir == "" {
+		return ReadinessCheck{Name: name, Message: "not configured"}
+	}
+
+	file, err := os.CreateTemp(dir, ".readyz-*")
+	if err != nil {
+		return ReadinessCheck{Name: name, Message: fmt.Sprintf("not writable: %v", err)}
+	}
+	file.Close()
+	os.Remove(file.Name())
+
+	minFreeSpace := s.config.Readiness.MinFreeSpace
+	if minFreeSpace <= 0 {
+		minFreeSpace = defaultMinFreeSpace
+	}
+	free, err := getFreeSpace(dir)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return ReadinessCheck{Name: name, OK: true, Message: "writable"}
+	}
+	if err != nil {
+		return ReadinessCheck{Name: name, Message: fmt.Sprintf("failed to get free space: %v", err)}
+	}
+	if free < minFreeSpace {
+		return ReadinessCheck{Name: name, Message: fmt.Sprintf("free space %d bytes is below %d bytes", free, minFreeSpace)}
+	}
+	return ReadinessCheck{Name: name, OK: true, Message: fmt.Sprintf("%d bytes free", free)}
+}
+
+// checkStorage 检查存储可用，本地存储检查目录可写及剩余空间，对象存储检查能否访问存储桶
+func (s *Service) checkStorage() ReadinessCheck {
+	if local, ok := s.storage.(*storage.LocalStorage); ok {
+		return s.checkDir("storage", local.Root())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), storageCheckTimeout)
+	defer cancel()
+	if _, err := s.storage.Stat(ctx, storageCheckKey); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return ReadinessCheck{Name: "storage", Message: fmt.Sprintf("not reachable: %v", err)}
+	}
+	return ReadinessCheck{Name: "storage", OK: true, Message: "reachable"}
+}
+
+// checkCookies 检查配置的 cookies 文件可读，未配置时视为通过
+func (s *Service) checkCookies() ReadinessCheck {
+	path := s.config.Ytdlp.CookiesPath
+	if path == "" {
+		return ReadinessCheck{Name: "cookies", OK: true, Message: "not configured"}
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return ReadinessCheck{Name: "cookies", Message: fmt.Sprintf("not readable: %v", err)}
+	}
+	file.Close()
+	return ReadinessCheck{Name: "cookies", OK: true}
+}
+
+// checkQueue 检查等待队列未达到 max_queue_length
+func (s *Service) checkQueue() ReadinessCheck {
+	maxQueueLength := s.config.Readiness.MaxQueueLength
+	if maxQueueLength <= 0 {
+		maxQueueLength = defaultMaxQueueLength
+	}
+	length := s.queue.len()
+	message := fmt.Sprintf("%d/%d queued", length, maxQueueLength)
+	if length >= maxQueueLength {
+		return ReadinessCheck{Name: "queue", Message: message}
+	}
+	return ReadinessCheck{Name: "queue", OK: true, Message: message}
+}
@@ -0,0 +1,488 @@
+package ytdlp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+
+	"github.com/self-made-boy/youtube-tools/internal/metrics"
+	"github.com/self-made-boy/youtube-tools/internal/storage"
+	"github.com/self-made-boy/youtube-tools/internal/utils"
+)
+
+// 存储清理的原因
+const (
+	// RetentionExpired 超过 max_age 未访问
+	RetentionExpired = "expired"
+	// RetentionQuota 存储总大小超过 max_total_size，按最后访问时间淘汰
+	RetentionQuota = "quota"
+	// RetentionOrphan 中断的下载留下的临时文件或未写完的文件
+	RetentionOrphan = "orphan"
+)
+
+const (
+	// defaultRetentionInterval 未配置 retention.interval 时自动清理的间隔
+	defaultRetentionInterval = time.Hour
+	// defaultOrphanAge 未配置 retention.orphan_age 时残留临时文件的最短未修改时间
+	defaultOrphanAge = time.Hour
+	// accessIndexKey 保存最后访问时间的索引文件，以 . 开头，不会出现在存储的 List 结果中
+	accessIndexKey = ".retention/access.json"
+)
+
+// RetentionItem 被清理或试运行时将被清理的文件
+type RetentionItem struct {
+	// 存储中的 key，download_dir 中的残留文件为本地路径
+	Key string `json:"key" example:"dQw4w9WgXcQ/audio/48000/dQw4w9WgXcQ.mp3"`
+	// 文件大小，目录为其中所有文件的大小之和，单位：字节
+	Size int64 `json:"size" example:"3407872"`
+	// 最后访问时间，没有访问记录时为修改时间
+	LastAccess time.Time `json:"last_access"`
+	// 清理原因：expired、quota 或 orphan
+	Reason string `json:"reason" example:"expired"`
+	// 删除失败或被跳过的原因
+	Error string `json:"error,omitempty"`
+}
+
+// RetentionReport 一次存储清理的结果
+type RetentionReport struct {
+	// 是否为试运行，试运行时不删除任何文件
+	DryRun bool `json:"dry_run" example:"true"`
+	// 开始清理的时间
+	StartTime time.Time `json:"start_time"`
+	// 清理前存储中的文件数
+	TotalObjects int `json:"total_objects" example:"120"`
+	// 清理前存储中文件的总大小，单位：字节
+	TotalBytes int64 `json:"total_bytes" example:"1073741824"`
+	// 释放的字节数，试运行时为可以释放的字节数
+	ReclaimedBytes int64 `json:"reclaimed_bytes" example:"536870912"`
+	// 被清理的文件
+	Items []RetentionItem `json:"items"`
+}
+
+// accessIndex 记录存储中文件的最后访问时间
+// 文件下载、返回下载地址和读取视频信息缓存时更新，每次清理时与存储中的索引文件合并后保存，多个实例共享
+type accessIndex struct {
+	mutex sync.Mutex
+	times map[string]time.Time
+}
+
+// touch 将文件的最后访问时间更新为当前时间
+func (a *accessIndex) touch(key string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.times == nil {
+		a.times = make(map[string]time.Time)
+	}
+	a.times[key] = time.Now()
+}
+
+// get 返回文件的最后访问时间
+func (a *accessIndex) get(key string) (time.Time, bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	accessedAt, ok := a.times[key]
+	return accessedAt, ok
+}
+
+// remove 删除文件的访问记录
+func (a *accessIndex) remove(key string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	delete(a.times, key)
+}
+
+// merge 合并其他实例记录的访问时间，保留较晚的时间
+func (a *accessIndex) merge(times map[string]time.Time) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.times == nil {
+		a.times = make(map[string]time.Time)
+	}
+	for key, accessedAt := range times {
+		if accessedAt.After(a.times[key]) {
+			a.times[key] = accessedAt
+		}
+	}
+}
+
+// snapshot 去掉已不存在的文件的访问记录，返回剩余记录的副本
+func (a *accessIndex) snapshot(exists func(key string) bool) map[string]time.Time {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	times := make(map[string]time.Time, len(a.times))
+	for key, accessedAt := range a.times {
+		if !exists(key) {
+			delete(a.times, key)
+			continue
+		}
+		times[key] = accessedAt
+	}
+	return times
+}
+
+// startRetentionRoutine 按 retention.interval 定期清理存储，间隔为负数时不自动清理
+func (s *Service) startRetentionRoutine() {
+	interval := s.config.Retention.Interval
+	if interval < 0 {
+		return
+	}
+	if interval == 0 {
+		interval = defaultRetentionInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if _, err := s.RunRetention(context.Background(), false); err != nil {
+			s.logger.Error("Storage retention failed", zap.Error(err))
+		}
+	}
+}
+
+// RunRetention 按保留策略清理存储中的文件和 download_dir 中的残留临时文件
+// 超过 max_age 未访问的文件先被删除，总大小仍超过 max_total_size 时再按最后访问时间从旧到新删除
+// 进行中任务的文件不会被删除；dryRun 为 true 时只返回将被清理的文件；同一时间只有一次清理在执行
+func (s *Service) RunRetention(ctx context.Context, dryRun bool) (*RetentionReport, error) {
+	s.retentionMutex.Lock()
+	defer s.retentionMutex.Unlock()
+
+	report := &RetentionReport{
+		DryRun:    dryRun,
+		StartTime: time.Now(),
+		Items:     []RetentionItem{},
+	}
+
+	objects, err := s.storage.List(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	s.loadAccessIndex(ctx)
+
+	activeTasks := s.getActiveTaskIDs()
+	cookiesKey := s.getCookiesKey()
+	cfg := s.config.Retention
+	var candidates []RetentionItem
+	remainingBytes := int64(0)
+	for _, object := range objects {
+		// 下载中的临时文件不是下载结果，由 orphan 清理处理；cookies 文件可能放在 s3_mount 中
+		if isPartialFile(object.Key) || object.Key == cookiesKey {
+			continue
+		}
+		report.TotalObjects++
+		report.TotalBytes += object.Size
+		remainingBytes += object.Size
+		if activeTasks[utils.ToHex(object.Key)] {
+			continue
+		}
+
+		item := RetentionItem{
+			Key:        object.Key,
+			Size:       object.Size,
+			LastAccess: object.ModTime,
+		}
+		if accessedAt, ok := s.access.get(object.Key); ok && accessedAt.After(item.LastAccess) {
+			item.LastAccess = accessedAt
+		}
+		if cfg.MaxAge > 0 && report.StartTime.Sub(item.LastAccess) > cfg.MaxAge {
+			item.Reason = RetentionExpired
+			report.Items = append(report.Items, item)
+			remainingBytes -= item.Size
+			continue
+		}
+		candidates = append(candidates, item)
+	}
+
+	if cfg.MaxTotalSize > 0 && remainingBytes > cfg.MaxTotalSize {
+		sort.Slice(candidates, func(i, j int) bool {
+			return candidates[i].LastAccess.Before(candidates[j].LastAccess)
+		})
+		for _, item := range candidates {
+			if remainingBytes <= cfg.MaxTotalSize {
+				break
+			}
+			item.Reason = RetentionQuota
+			report.Items = append(report.Items, item)
+			remainingBytes -= item.Size
+		}
+	}
+
+	report.Items = append(report.Items, s.findOrphanWorkDirs(activeTasks, report.StartTime)...)
+
+	deleted := 0
+	deletedKeys := make(map[string]bool)
+	for i := range report.Items {
+		item := &report.Items[i]
+		if !dryRun {
+			if err := s.deleteRetentionItem(ctx, item); err != nil {
+				item.Error = err.Error()
+				s.logger.Warn("Failed to delete file during storage retention",
+					zap.String("key", item.Key),
+					zap.String("reason", item.Reason),
+					zap.Error(err))
+				continue
+			}
+			metrics.ObserveRetentionDelete(item.Reason, item.Size)
+			deleted++
+			if item.Reason != RetentionOrphan {
+				deletedKeys[item.Key] = true
+			}
+		}
+		report.ReclaimedBytes += item.Size
+	}
+
+	if dryRun {
+		return report, nil
+	}
+
+	// 保存清理后仍存在的文件的访问记录
+	existing := make(map[string]bool, len(objects))
+	remainingObjects, storageBytes := 0, int64(0)
+	for _, object := range objects {
+		if deletedKeys[object.Key] || isPartialFile(object.Key) || object.Key == cookiesKey {
+			continue
+		}
+		existing[object.Key] = true
+		remainingObjects++
+		storageBytes += object.Size
+	}
+	s.saveAccessIndex(ctx, s.access.snapshot(func(key string) bool { return existing[key] }))
+	metrics.SetStorageUsage(remainingObjects, storageBytes)
+
+	s.logger.Info("Storage retention completed",
+		zap.Int("total_objects", report.TotalObjects),
+		zap.Int64("total_bytes", report.TotalBytes),
+		zap.Int("deleted", deleted),
+		zap.Int64("reclaimed_bytes", report.ReclaimedBytes),
+		zap.Duration("duration", time.Since(report.StartTime)))
+	return report, nil
+}
+
+// deleteRetentionItem 删除被清理的文件，删除存储中的文件后同时忘记对应的已完成任务，再次请求时重新下载
+func (s *Service) deleteRetentionItem(ctx context.Context, item *RetentionItem) error {
+	if item.Reason == RetentionOrphan {
+		return os.RemoveAll(item.Key)
+	}
+
+	// 列出文件后任务可能又开始了
+	taskID := utils.ToHex(item.Key)
+	if s.getActiveTaskIDs()[taskID] {
+		return errors.New("skipped: download in progress")
+	}
+	if err := s.storage.Delete(ctx, item.Key); err != nil {
+		return err
+	}
+	s.access.remove(item.Key)
+	s.forgetCompletedTask(taskID)
+	return nil
+}
+
+// getActiveTaskIDs 返回等待中和下载中的任务ID
+func (s *Service) getActiveTaskIDs() map[string]bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	active := make(map[string]bool)
+	for taskID, task := range s.downloads {
+		if task.State == "pending" || task.State == "downloading" {
+			active[taskID] = true
+		}
+	}
+	return active
+}
+
+// forgetCompletedTask 删除已完成的任务，其结果文件已被清理
+func (s *Service) forgetCompletedTask(taskID string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	task, ok := s.downloads[taskID]
+	if !ok || task.State != "completed" {
+		return
+	}
+	delete(s.downloads, taskID)
+	if err := s.store.Delete(taskID); err != nil {
+		s.logger.Error("Failed to delete download task from store",
+			zap.String("task_id", taskID),
+			zap.Error(err))
+	}
+}
+
+// getCookiesKey 返回 cookies 文件在本地存储中的 key，不在存储目录中时返回空字符串
+func (s *Service) getCookiesKey() string {
+	local, ok := s.storage.(*storage.LocalStorage)
+	if !ok || s.config.Ytdlp.CookiesPath == "" {
+		return ""
+	}
+	root, err := filepath.Abs(local.Root())
+	if err != nil {
+		return ""
+	}
+	cookiesPath, err := filepath.Abs(s.config.Ytdlp.CookiesPath)
+	if err != nil {
+		return ""
+	}
+	rel, err := filepath.Rel(root, cookiesPath)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
+
+// isPartialFile 判断文件是否为 yt-dlp 下载中的临时文件
+func isPartialFile(key string) bool {
+	name := path.Base(key)
+	return strings.HasSuffix(name, ".part") || strings.HasSuffix(name, ".ytdl") || strings.Contains(name, ".part-Frag")
+}
+
+// isTaskWorkDirName 判断 download_dir 中的文件名是否为任务的临时目录名，即可以解码为任务结果路径的任务ID
+func isTaskWorkDirName(name string) bool {
+	key, err := utils.FromHex(name)
+	return err == nil && strings.Contains(key, "/") && utf8.ValidString(key)
+}
+
+// findOrphanWorkDirs 查找 download_dir 中不属于等待中或下载中任务、超过 orphan_age 未修改的任务临时目录
+// 这些目录由进程崩溃或重启前中断的 runDownload 留下，任务恢复后重新下载时使用新的临时目录
+// 只处理名称为任务ID的目录，download_dir 中的其他文件不会被删除
+func (s *Service) findOrphanWorkDirs(activeTasks map[string]bool, now time.Time) []RetentionItem {
+	downloadDir := s.config.Ytdlp.DownloadDir
+	if downloadDir == "" {
+		return nil
+	}
+	entries, err := os.ReadDir(downloadDir)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			s.logger.Warn("Failed to read download directory for orphan files",
+				zap.String("download_dir", downloadDir),
+				zap.Error(err))
+		}
+		return nil
+	}
+
+	orphanAge := s.config.Retention.OrphanAge
+	if orphanAge <= 0 {
+		orphanAge = defaultOrphanAge
+	}
+
+	var items []RetentionItem
+	for _, entry := range entries {
+		if !entry.IsDir() || !isTaskWorkDirName(entry.Name()) || activeTasks[entry.Name()] {
+			continue
+		}
+		path := filepath.Join(downloadDir, entry.Name())
+		modTime, size := latestModTime(path)
+		if now.Sub(modTime) < orphanAge {
+			continue
+		}
+		items = append(items, RetentionItem{
+			Key:        path,
+			Size:       size,
+			LastAccess: modTime,
+			Reason:     RetentionOrphan,
+		})
+	}
+	return items
+}
+
+// latestModTime 返回文件或目录中所有文件最晚的修改时间及总大小，空目录返回目录本身的修改时间
+func latestModTime(path string) (time.Time, int64) {
+	var latest, dirModTime time.Time
+	var size int64
+	_ = filepath.WalkDir(path, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if p == path {
+				dirModTime = info.ModTime()
+			}
+			return nil
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+		size += info.Size()
+		return nil
+	})
+	if latest.IsZero() {
+		return dirModTime, size
+	}
+	return latest, size
+}
+
+// removePartialUpload 删除进程退出时正在下载的任务在存储中的文件
+// 本地存储直接写入目标文件，上传中断会留下不完整的文件，任务恢复后 runDownload 会把它当作已完成的结果
+func (s *Service) removePartialUpload(task *DownloadTask) {
+	key, err := utils.FromHex(task.ID)
+	if err != nil {
+		return
+	}
+	ctx := context.Background()
+	info, err := s.storage.Stat(ctx, key)
+	if err != nil {
+		return
+	}
+	if err := s.storage.Delete(ctx, key); err != nil {
+		s.logger.Error("Failed to remove partially uploaded file",
+			zap.String("task_id", task.ID),
+			zap.String("key", key),
+			zap.Error(err))
+		return
+	}
+	metrics.ObserveRetentionDelete(RetentionOrphan, info.Size)
+	s.logger.Warn("Removed partially uploaded file left by interrupted download",
+		zap.String("task_id", task.ID),
+		zap.String("key", key),
+		zap.Int64("size", info.Size))
+}
+
+// loadAccessIndex 读取存储中的访问记录并与内存中的记录合并
+func (s *Service) loadAccessIndex(ctx context.Context) {
+	reader, err := s.storage.Open(ctx, accessIndexKey)
+	if errors.Is(err, storage.ErrNotFound) {
+		return
+	}
+	if err != nil {
+		s.logger.Warn("Failed to open access index", zap.Error(err))
+		return
+	}
+	defer reader.Close()
+
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		s.logger.Warn("Failed to read access index", zap.Error(err))
+		return
+	}
+	var times map[string]time.Time
+	if err := json.Unmarshal(content, &times); err != nil {
+		s.logger.Warn("Failed to parse access index", zap.Error(err))
+		return
+	}
+	s.access.merge(times)
+}
+
+// saveAccessIndex 将访问记录写入存储，失败时只记录日志
+func (s *Service) saveAccessIndex(ctx context.Context, times map[string]time.Time) {
+	content, err := json.Marshal(times)
+	if err != nil {
+		s.logger.Error("Failed to marshal access index", zap.Error(err))
+		return
+	}
+	if err := s.storage.Put(ctx, accessIndexKey, bytes.NewReader(content)); err != nil {
+		s.logger.Error("Failed to save access index", zap.Error(err))
+	}
+}
@@ -0,0 +1,220 @@
+package ytdlp
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// TaskStore 持久化下载任务状态，使任务在进程重启后仍然可以查询和恢复
+type TaskStore interface {
+	// Save 保存任务的当前状态，已存在时覆盖
+	// 已保存的任务 Revision 更大时保持不变，并发保存时较旧的状态不会覆盖较新的状态
+	Save(task *DownloadTask) error
+	// Delete 删除任务
+	Delete(taskID string) error
+	// List 列出所有已保存的任务
+	List() ([]*DownloadTask, error)
+}
+
+// NewTaskStore 根据配置创建任务存储，未配置目录时仅保存在内存中
+func NewTaskStore(dir string) (TaskStore, error) {
+	if dir == "" {
+		return NewMemoryTaskStore(), nil
+	}
+	return NewFileTaskStore(dir)
+}
+
+// MemoryTaskStore 将任务保存在内存中，进程重启后丢失
+type MemoryTaskStore struct {
+	mutex sync.RWMutex
+	tasks map[string]DownloadTask
+}
+
+// NewMemoryTaskStore 创建一个内存任务存储
+func NewMemoryTaskStore() *MemoryTaskStore {
+	return &MemoryTaskStore{
+		tasks: make(map[string]DownloadTask),
+	}
+}
+
+// Save 保存任务
+func (m *MemoryTaskStore) Save(task *DownloadTask) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if stored, ok := m.tasks[task.ID]; ok && stored.Revision > task.Revision {
+		return nil
+	}
+	m.tasks[task.ID] = *task
+	return nil
+}
+
+// Delete 删除任务
+func (m *MemoryTaskStore) Delete(taskID string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.tasks, taskID)
+	return nil
+}
+
+// List 列出所有任务
+func (m *MemoryTaskStore) List() ([]*DownloadTask, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	tasks := make([]*DownloadTask, 0, len(m.tasks))
+	for _, task := range m.tasks {
+		task := task
+		tasks = append(tasks, &task)
+	}
+	return tasks, nil
+}
+
+// FileTaskStore 将每个任务保存为目录下的一个 JSON 文件
+//
+// 每次保存先写入同目录下的临时文件再重命名覆盖，进程崩溃时不会留下写了一半的任务文件。
+// 不依赖随机写、文件锁或 mmap，挂载的 S3 目录不支持重命名时退回直接覆盖写入
+type FileTaskStore struct {
+	dir   string
+	mutex sync.Mutex
+}
+
+// taskFileExt 任务文件扩展名
+const taskFileExt = ".task.json"
+
+// NewFileTaskStore 创建一个基于文件的任务存储，目录不存在时自动创建
+func NewFileTaskStore(dir string) (*FileTaskStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create task store directory: %w", err)
+	}
+	// 删除进程崩溃时留下的临时文件
+	if matches, err := filepath.Glob(filepath.Join(dir, ".*.tmp")); err == nil {
+		for _, match := range matches {
+			os.Remove(match)
+		}
+	}
+	return &FileTaskStore{dir: dir}, nil
+}
+
+// taskPath 返回任务文件路径，任务ID本身是十六进制字符串，可以直接作为文件名
+func (f *FileTaskStore) taskPath(taskID string) string {
+	return filepath.Join(f.dir, taskID+taskFileExt)
+}
+
+// Save 保存任务
+func (f *FileTaskStore) Save(task *DownloadTask) error {
+	data, err := json.Marshal(task)
+	if err != nil {
+		return fmt.Errorf("failed to marshal task: %w", err)
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	path := f.taskPath(task.ID)
+	if f.storedRevision(path) > task.Revision {
+		return nil
+	}
+	tmpPath, err := f.writeTempFile(task.ID, data)
+	if err != nil {
+		return fmt.Errorf("failed to write task file: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			return fmt.Errorf("failed to write task file: %w", err)
+		}
+	}
+	return nil
+}
+
+// storedRevision 返回已保存任务的 Revision，文件不存在或无法解析时返回 -1
+func (f *FileTaskStore) storedRevision(path string) int64 {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return -1
+	}
+	var stored struct {
+		Revision int64 `json:"revision"`
+	}
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return -1
+	}
+	return stored.Revision
+}
+
+// writeTempFile 将任务内容写入同目录下以 . 开头的临时文件并同步到磁盘，返回临时文件路径
+func (f *FileTaskStore) writeTempFile(taskID string, data []byte) (string, error) {
+	file, err := os.CreateTemp(f.dir, "."+taskID+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(file.Name(), 0644)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+// Delete 删除任务
+func (f *FileTaskStore) Delete(taskID string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if err := os.Remove(f.taskPath(taskID)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove task file: %w", err)
+	}
+	return nil
+}
+
+// List 列出所有任务，无法解析的文件会被跳过并返回错误信息
+func (f *FileTaskStore) List() ([]*DownloadTask, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read task store directory: %w", err)
+	}
+
+	var tasks []*DownloadTask
+	var badFiles []string
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), taskFileExt) {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(f.dir, entry.Name()))
+		if err != nil {
+			badFiles = append(badFiles, entry.Name())
+			continue
+		}
+
+		var task DownloadTask
+		if err := json.Unmarshal(data, &task); err != nil || task.ID == "" {
+			badFiles = append(badFiles, entry.Name())
+			continue
+		}
+		tasks = append(tasks, &task)
+	}
+
+	if len(badFiles) > 0 {
+		return tasks, fmt.Errorf("failed to load task files: %s", strings.Join(badFiles, ", "))
+	}
+	return tasks, nil
+}
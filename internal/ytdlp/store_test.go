@@ -0,0 +1,187 @@
+package ytdlp
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/self-made-boy/youtube-tools/internal/config"
+	"github.com/self-made-boy/youtube-tools/internal/storage"
+	"github.com/self-made-boy/youtube-tools/internal/utils"
+)
+
+// TestFileTaskStore 测试任务文件的保存、覆盖、删除和列出
+func TestFileTaskStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileTaskStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tasks := []*DownloadTask{
+		{ID: "616263", State: "pending"},
+		{ID: "646566", State: "completed", DownloadUrl: "https://cdn/def.mp3"},
+	}
+	for _, task := range tasks {
+		if err := store.Save(task); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 覆盖已存在的任务
+	if err := store.Save(&DownloadTask{ID: "616263", State: "downloading", Progress: 50}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete("646566"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete("646566"); err != nil {
+		t.Errorf("Delete() of missing task error = %v", err)
+	}
+
+	listed, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 || listed[0].ID != "616263" || listed[0].State != "downloading" || listed[0].Progress != 50 {
+		t.Fatalf("List() = %+v, expected the overwritten task only", listed)
+	}
+
+	// 保存后目录中不应留下临时文件
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), taskFileExt) {
+			t.Errorf("unexpected file %s in task store", entry.Name())
+		}
+	}
+}
+
+// TestTaskStore_Save_Revision 测试并发保存时较旧的状态不会覆盖 Revision 更大的已保存状态
+func TestTaskStore_Save_Revision(t *testing.T) {
+	fileStore, err := NewFileTaskStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stores := map[string]TaskStore{
+		"文件存储": fileStore,
+		"内存存储": NewMemoryTaskStore(),
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			// 取消后保存的状态先写入，worker 开始下载时的状态后写入
+			if err := store.Save(&DownloadTask{ID: "616263", State: "cancelled", Revision: 3}); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Save(&DownloadTask{ID: "616263", State: "downloading", Revision: 2}); err != nil {
+				t.Fatal(err)
+			}
+			listed, err := store.List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(listed) != 1 || listed[0].State != "cancelled" {
+				t.Fatalf("List() = %+v, expected the cancelled task to be kept", listed)
+			}
+
+			// Revision 相同时覆盖，如登记回调地址
+			if err := store.Save(&DownloadTask{ID: "616263", State: "cancelled", Revision: 3, CallbackURLs: []string{"https://example.com/hook"}}); err != nil {
+				t.Fatal(err)
+			}
+			listed, _ = store.List()
+			if len(listed) != 1 || len(listed[0].CallbackURLs) != 1 {
+				t.Errorf("List() = %+v, expected the task saved with the same revision", listed)
+			}
+		})
+	}
+}
+
+// TestFileTaskStore_List_Corrupt 测试写了一半的任务文件和残留的临时文件不影响其他任务的恢复
+func TestFileTaskStore_List_Corrupt(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "626164"+taskFileExt), []byte(`{"id":"626164","sta`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tmpPath := filepath.Join(dir, ".616263.123.tmp")
+	if err := os.WriteFile(tmpPath, []byte(`{"id":"616263"`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewFileTaskStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(tmpPath); !os.IsNotExist(err) {
+		t.Errorf("leftover temp file not removed: %v", err)
+	}
+	if err := store.Save(&DownloadTask{ID: "676f6f64", State: "pending"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tasks, err := store.List()
+	if err == nil || !strings.Contains(err.Error(), "626164"+taskFileExt) {
+		t.Errorf("List() error = %v, expected to report the corrupt file", err)
+	}
+	if len(tasks) != 1 || tasks[0].ID != "676f6f64" {
+		t.Errorf("List() = %+v, expected the valid task", tasks)
+	}
+}
+
+// TestService_RestoreTasks 测试恢复任务时未完成的任务按创建顺序重新排队，已结束的任务只恢复状态
+func TestService_RestoreTasks(t *testing.T) {
+	root := t.TempDir()
+	store := NewMemoryTaskStore()
+	now := time.Now()
+	downloadingKey := "dl/audio/48000/dl.mp3"
+	saved := []*DownloadTask{
+		{ID: "second", State: "pending", StartTime: now.Add(-time.Minute)},
+		{ID: "first", State: "downloading", Progress: 40, StartTime: now.Add(-time.Hour)},
+		{ID: "done", State: "completed", StartTime: now.Add(-2 * time.Hour)},
+		{ID: "failed", State: "failed", StartTime: now.Add(-3 * time.Hour)},
+	}
+	// 下载中断的任务在存储中留下了不完整的文件
+	saved[1].ID = utils.ToHex(downloadingKey)
+	local := storage.NewLocal(root, "")
+	if err := local.Put(context.Background(), downloadingKey, strings.NewReader("partial")); err != nil {
+		t.Fatal(err)
+	}
+	for _, task := range saved {
+		store.Save(task)
+	}
+
+	service := &Service{
+		config:    &config.Config{},
+		logger:    zap.NewNop(),
+		downloads: make(map[string]*DownloadTask),
+		queue:     newDownloadQueue(),
+		store:     store,
+		storage:   local,
+	}
+	service.restoreTasks()
+
+	if got := service.queue.len(); got != 2 {
+		t.Fatalf("queue length = %d, expected 2", got)
+	}
+	for i, expectedID := range []string{saved[1].ID, "second"} {
+		task := service.queue.pop()
+		if task.ID != expectedID {
+			t.Errorf("queue[%d] = %s, expected %s", i, task.ID, expectedID)
+		}
+		if task.State != "pending" || task.Progress != 0 || task.Ctx == nil || task.Cancel == nil {
+			t.Errorf("requeued task %s = %+v, expected reset pending task", task.ID, task)
+		}
+	}
+	for _, id := range []string{"done", "failed"} {
+		if _, err := service.GetDownloadStatus(id); err != nil {
+			t.Errorf("finished task %s not restored: %v", id, err)
+		}
+	}
+	if _, err := local.Stat(context.Background(), downloadingKey); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("partial upload not removed: %v", err)
+	}
+}
@@ -0,0 +1,314 @@
+package ytdlp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/self-made-boy/youtube-tools/internal/metrics"
+)
+
+var (
+	// ErrTooManyStreams 同时进行的流式下载数已达到 max_streams
+	ErrTooManyStreams = errors.New("too many concurrent streams")
+	// ErrStreamNotSupported 所选格式不支持流式下载
+	ErrStreamNotSupported = errors.New("format does not support streaming")
+)
+
+// defaultMaxStreams 未配置 max_streams 时同时进行的流式下载数
+const defaultMaxStreams = 2
+
+// maxStderrTail 流式下载失败时保留的 yt-dlp 和 ffmpeg 错误输出的最大长度
+const maxStderrTail = 4096
+
+// streamMuxer 流式输出时使用的 ffmpeg 封装格式
+type streamMuxer struct {
+	// ffmpeg -f 参数
+	format string
+	// 下载文件名的扩展名
+	fileExt string
+	// 不在 containerCodecs 中的扩展名使用的编码器，总是转码
+	encoder string
+	// 输出到管道时需要的额外参数
+	args []string
+}
+
+// streamMuxers 支持流式下载的音频扩展名，输出到管道的 mp4 需要使用分片封装
+var streamMuxers = map[string]streamMuxer{
+	"mp3":    {format: "mp3", fileExt: "mp3"},
+	"m4a":    {format: "ipod", fileExt: "m4a", args: []string{"-movflags", "frag_keyframe+empty_moov"}},
+	"alac":   {format: "ipod", fileExt: "m4a", encoder: "alac", args: []string{"-movflags", "frag_keyframe+empty_moov"}},
+	"aac":    {format: "adts", fileExt: "aac"},
+	"opus":   {format: "opus", fileExt: "opus"},
+	"vorbis": {format: "ogg", fileExt: "ogg", encoder: "libvorbis"},
+	"flac":   {format: "flac", fileExt: "flac"},
+	"wav":    {format: "wav", fileExt: "wav"},
+}
+
+// AudioStream 正在输出的音频流，读取完毕或客户端断开后必须调用 Close
+type AudioStream struct {
+	ContentType string
+	// 下载时使用的文件名，由视频标题和扩展名组成
+	Filename string
+
+	reader      *bufio.Reader
+	cancel      context.CancelCauseFunc
+	ctx         context.Context
+	commands    []*exec.Cmd
+	stderr      *tailBuffer
+	maxFileSize int64
+	written     int64
+	eof         bool
+	startTime   time.Time
+	release     func()
+	closeOnce   sync.Once
+	closeErr    error
+}
+
+// Read 读取音频数据，超过 max_file_size 时结束子进程并返回 ErrFileTooLarge
+func (a *AudioStream) Read(p []byte) (int, error) {
+	n, err := a.reader.Read(p)
+	a.written += int64(n)
+	if a.maxFileSize > 0 && a.written > a.maxFileSize {
+		err := fmt.Errorf("%w: streamed more than %d bytes", ErrFileTooLarge, a.maxFileSize)
+		a.cancel(err)
+		return n, err
+	}
+	if err != nil {
+		a.eof = true
+	}
+	return n, err
+}
+
+// Close 结束并等待子进程，返回流是否完整输出
+// 未读取完毕就关闭时会结束子进程，返回 context.Canceled
+func (a *AudioStream) Close() error {
+	a.closeOnce.Do(func() {
+		if !a.eof {
+			a.cancel(context.Canceled)
+		}
+		a.closeErr = a.wait()
+		a.release()
+		metrics.ObserveYtdlp("stream", time.Since(a.startTime), a.closeErr)
+	})
+	return a.closeErr
+}
+
+// wait 等待所有子进程退出，取消原因优先于子进程的退出错误
+func (a *AudioStream) wait() error {
+	var waitErr error
+	for _, cmd := range a.commands {
+		if err := cmd.Wait(); err != nil && waitErr == nil {
+			waitErr = fmt.Errorf("%s failed: %w: %s", filepath.Base(cmd.Path), err, a.stderr.lastLine())
+		}
+	}
+	if cause := context.Cause(a.ctx); cause != nil {
+		return cause
+	}
+	return waitErr
+}
+
+// OpenAudioStream 启动 yt-dlp 将音频输出到标准输出，需要转换容器或编码时通过 ffmpeg 转换后输出
+// 结果不写入临时目录和存储，ctx 取消时结束子进程；同时进行的流式下载数超过 max_streams 时返回 ErrTooManyStreams
+// 子进程在输出第一个字节前失败时直接返回错误，之后的错误由 Close 返回
+func (s *Service) OpenAudioStream(ctx context.Context, url, formatID string) (*AudioStream, error) {
+	ext, _, aFormatID, err := s.ParseAudioFormatID(formatID)
+	if err != nil {
+		return nil, err
+	}
+	muxer, ok := streamMuxers[ext]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrStreamNotSupported, ext)
+	}
+	_, videoID, err := s.CheckVideoUrl(url)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkFormatSize(url, formatID, nil); err != nil {
+		return nil, err
+	}
+
+	select {
+	case s.streamSlots <- struct{}{}:
+	default:
+		return nil, ErrTooManyStreams
+	}
+	release := func() { <-s.streamSlots }
+
+	streamCtx, cancel := context.WithCancelCause(ctx)
+	stream := &AudioStream{
+		ContentType: ContentTypeByExt(muxer.fileExt),
+		Filename:    s.getFilenameTitle(videoID) + "." + muxer.fileExt,
+		cancel:      cancel,
+		ctx:         streamCtx,
+		stderr:      &tailBuffer{},
+		maxFileSize: s.config.Ytdlp.MaxFileSize,
+		startTime:   time.Now(),
+		release:     release,
+	}
+
+	commands, err := s.startStreamCommands(streamCtx, stream, url, ext, aFormatID, muxer)
+	if err != nil {
+		cancel(nil)
+		release()
+		return nil, err
+	}
+	stream.commands = commands
+
+	// 等待第一个字节，在返回响应头之前发现视频不可用等错误
+	if _, err := stream.reader.Peek(1); err != nil {
+		stream.eof = true
+		closeErr := stream.Close()
+		if closeErr == nil {
+			closeErr = errors.New("stream produced no output")
+		}
+		s.logger.Warn("Audio stream failed before output",
+			zap.String("url", url),
+			zap.String("format", formatID),
+			zap.Error(closeErr))
+		return nil, closeErr
+	}
+
+	s.logger.Info("Audio stream started",
+		zap.String("url", url),
+		zap.String("format", formatID),
+		zap.Bool("ffmpeg", len(commands) > 1))
+	return stream, nil
+}
+
+// startStreamCommands 启动 yt-dlp 和可能需要的 ffmpeg，最后一个命令的标准输出作为流的内容
+func (s *Service) startStreamCommands(ctx context.Context, stream *AudioStream, url, ext, aFormatID string, muxer streamMuxer) ([]*exec.Cmd, error) {
+	cmdArgs := []string{
+		"--no-playlist",
+		"--no-progress",
+		"--no-part",
+	}
+	if s.config.Ytdlp.CookiesPath != "" {
+		cmdArgs = append(cmdArgs, "--cookies", s.config.Ytdlp.CookiesPath)
+	}
+	if s.config.Ytdlp.Proxy != "" {
+		cmdArgs = append(cmdArgs, "--proxy", s.config.Ytdlp.Proxy)
+	}
+	cmdArgs = append(cmdArgs, "-f", aFormatID, "-o", "-", url)
+
+	ytdlpCmd := exec.CommandContext(ctx, s.config.Ytdlp.Path, cmdArgs...)
+	setProcessGroup(ytdlpCmd)
+	ytdlpCmd.Stderr = stream.stderr
+
+	ffmpegArgs := s.getStreamFfmpegArgs(url, ext, aFormatID, muxer)
+	s.logger.Info("Executing yt-dlp command for stream",
+		zap.String("full_command", fmt.Sprintf("%s %s", s.config.Ytdlp.Path, strings.Join(cmdArgs, " "))),
+		zap.Strings("ffmpeg_args", ffmpegArgs))
+
+	// 源格式与目标格式相同时直接输出 yt-dlp 的结果
+	if ffmpegArgs == nil {
+		stdout, err := ytdlpCmd.StdoutPipe()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get stdout pipe: %w", err)
+		}
+		if err := ytdlpCmd.Start(); err != nil {
+			return nil, fmt.Errorf("failed to start yt-dlp: %w", err)
+		}
+		stream.reader = bufio.NewReaderSize(stdout, 64*1024)
+		return []*exec.Cmd{ytdlpCmd}, nil
+	}
+
+	ffmpegCmd := exec.CommandContext(ctx, resolveFfmpegPath(s.config.Ytdlp.FfmpegPath), ffmpegArgs...)
+	setProcessGroup(ffmpegCmd)
+	ffmpegCmd.Stderr = stream.stderr
+
+	// 父进程不能持有管道的任何一端，否则 ffmpeg 提前退出时 yt-dlp 会一直阻塞在写入上
+	pipeReader, pipeWriter, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pipe: %w", err)
+	}
+	ytdlpCmd.Stdout = pipeWriter
+	ffmpegCmd.Stdin = pipeReader
+	stdout, err := ffmpegCmd.StdoutPipe()
+	if err != nil {
+		pipeReader.Close()
+		pipeWriter.Close()
+		return nil, fmt.Errorf("failed to get stdout pipe: %w", err)
+	}
+
+	if err := ffmpegCmd.Start(); err != nil {
+		pipeReader.Close()
+		pipeWriter.Close()
+		return nil, fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+	pipeReader.Close()
+	if err := ytdlpCmd.Start(); err != nil {
+		pipeWriter.Close()
+		ffmpegCmd.Wait()
+		return nil, fmt.Errorf("failed to start yt-dlp: %w", err)
+	}
+	pipeWriter.Close()
+
+	stream.reader = bufio.NewReaderSize(stdout, 64*1024)
+	return []*exec.Cmd{ytdlpCmd, ffmpegCmd}, nil
+}
+
+// getStreamFfmpegArgs 构建从标准输入读取、输出到标准输出的 ffmpeg 参数，不需要 ffmpeg 时返回 nil
+// 源格式的扩展名与目标相同时直接输出；源编码与目标兼容时只转换容器，否则转码
+// 格式选择器或视频信息不可用时无法得知源格式，总是转码
+func (s *Service) getStreamFfmpegArgs(url, ext, aFormatID string, muxer streamMuxer) []string {
+	var source *RawFormat
+	if !isFormatSelector(aFormatID) {
+		if rawInfo, err := s.getRawVideoInfo(url); err != nil {
+			s.logger.Warn("Failed to get source format for stream, falling back to transcoding",
+				zap.String("url", url),
+				zap.Error(err))
+		} else if format, ok := rawInfo.findFormat(aFormatID); ok {
+			source = format
+		}
+	}
+	if source != nil && source.Ext == ext && muxer.encoder == "" {
+		return nil
+	}
+
+	codec := muxer.encoder
+	if codec == "" {
+		if source != nil && isAudioCodecCompatible(source.Acodec, ext) {
+			codec = "copy"
+		} else {
+			codec = containerCodecs[ext].audioEncoder
+		}
+	}
+
+	args := []string{"-hide_banner", "-loglevel", "error", "-i", "pipe:0", "-vn", "-c:a", codec}
+	args = append(args, muxer.args...)
+	return append(args, "-f", muxer.format, "pipe:1")
+}
+
+// tailBuffer 只保留最后 maxStderrTail 字节的错误输出，可以被多个子进程同时写入
+type tailBuffer struct {
+	mutex sync.Mutex
+	data  []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.data = append(b.data, p...)
+	if len(b.data) > maxStderrTail {
+		b.data = b.data[len(b.data)-maxStderrTail:]
+	}
+	return len(p), nil
+}
+
+// lastLine 返回最后一行非空的输出
+func (b *tailBuffer) lastLine() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	lines := strings.Split(strings.TrimSpace(string(b.data)), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
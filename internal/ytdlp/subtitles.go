@@ -0,0 +1,186 @@
+package ytdlp
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/self-made-boy/youtube-tools/internal/utils"
+)
+
+// subtitleExts 支持下载的字幕格式，srt 可由 vtt 转换得到
+var subtitleExts = []string{"vtt", "srt", "json3"}
+
+// subtitleLangRegex 字幕语言代码，如 en、zh-Hans、pt-BR，会出现在文件路径中
+var subtitleLangRegex = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+
+// SubtitleTrack 表示一种语言的字幕
+type SubtitleTrack struct {
+	// 语言代码
+	Lang string `json:"lang" example:"en"`
+	// 语言名称
+	Name string `json:"name" example:"English"`
+	// 是否为自动生成的字幕
+	Auto bool `json:"auto" example:"false"`
+	// 可下载的字幕格式
+	Formats []SubtitleFormat `json:"formats"`
+}
+
+// SubtitleFormat 表示字幕格式
+type SubtitleFormat struct {
+	// 格式ID
+	FormatID string `json:"format_id" example:"735f5f7674745f5f656e5f5f6d616e75616c"`
+	// 文件扩展名
+	Ext string `json:"ext" example:"vtt"`
+}
+
+// extractSubtitles 从 yt-dlp 输出的 subtitles 和 automatic_captions 中提取字幕列表
+// 同一语言同时存在上传字幕和自动字幕时两者都会列出
+func extractSubtitles(rawInfo *RawVideoInfo) []SubtitleTrack {
+	tracks := []SubtitleTrack{}
+	tracks = append(tracks, extractSubtitleTracks(rawInfo.Subtitles, false)...)
+	tracks = append(tracks, extractSubtitleTracks(rawInfo.AutomaticCaptions, true)...)
+	return tracks
+}
+
+// extractSubtitleTracks 提取 subtitles 或 automatic_captions 中的字幕，按语言代码排序
+func extractSubtitleTracks(subtitlesRaw map[string][]RawSubtitle, auto bool) []SubtitleTrack {
+	langs := make([]string, 0, len(subtitlesRaw))
+	for lang := range subtitlesRaw {
+		// live_chat 是直播聊天记录，不是字幕
+		if lang == "live_chat" || !subtitleLangRegex.MatchString(lang) {
+			continue
+		}
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+
+	var tracks []SubtitleTrack
+	for _, lang := range langs {
+		name := ""
+		available := make(map[string]bool)
+		for _, entry := range subtitlesRaw[lang] {
+			available[entry.Ext] = true
+			if name == "" {
+				name = entry.Name
+			}
+		}
+
+		var formats []SubtitleFormat
+		for _, ext := range subtitleExts {
+			// srt 可以由 vtt 转换得到
+			if available[ext] || (ext == "srt" && available["vtt"]) {
+				formats = append(formats, SubtitleFormat{
+					FormatID: buildSubtitleFormatID(ext, lang, auto),
+					Ext:      ext,
+				})
+			}
+		}
+		if len(formats) == 0 {
+			continue
+		}
+
+		tracks = append(tracks, SubtitleTrack{
+			Lang:    lang,
+			Name:    name,
+			Auto:    auto,
+			Formats: formats,
+		})
+	}
+	return tracks
+}
+
+// buildSubtitleFormatID 构建字幕格式 ID，格式为 s__ext__lang__manual 或 s__ext__lang__auto
+func buildSubtitleFormatID(ext, lang string, auto bool) string {
+	kind := "manual"
+	if auto {
+		kind = "auto"
+	}
+	return utils.ToHex(fmt.Sprintf("s__%s__%s__%s", ext, lang, kind))
+}
+
+// ParseSubtitleFormatID 解析字幕格式 ID，格式为 s__ext__lang__manual 或 s__ext__lang__auto
+func (s *Service) ParseSubtitleFormatID(formatID string) (ext string, lang string, auto bool, err error) {
+	formatID, err = utils.FromHex(formatID)
+	if err != nil {
+		return "", "", false, fmt.Errorf("invalid subtitle format ID: %s", formatID)
+	}
+	parts := strings.Split(formatID, "__")
+	if len(parts) != 4 || parts[0] != "s" {
+		return "", "", false, fmt.Errorf("invalid subtitle format ID: %s", formatID)
+	}
+
+	ext = parts[1]
+	if !slices.Contains(subtitleExts, ext) {
+		return "", "", false, fmt.Errorf("unsupported subtitle format in format ID: %s", formatID)
+	}
+	lang = parts[2]
+	if !subtitleLangRegex.MatchString(lang) {
+		return "", "", false, fmt.Errorf("invalid subtitle language in format ID: %s", formatID)
+	}
+	switch parts[3] {
+	case "manual":
+		auto = false
+	case "auto":
+		auto = true
+	default:
+		return "", "", false, fmt.Errorf("invalid subtitle kind in format ID: %s", formatID)
+	}
+	return ext, lang, auto, nil
+}
+
+// IsSubtitleFormatID 检查格式 ID 是否为字幕格式
+func (s *Service) IsSubtitleFormatID(formatID string) bool {
+	formatID, err := utils.FromHex(formatID)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(formatID, "s__")
+}
+
+// getSubtitleLocation 返回字幕文件相对 S3Mount 的路径
+// 上传字幕为 <videoID>/subtitles/<lang>.<ext>，自动字幕放在 auto 子目录下以免同语言冲突
+func getSubtitleLocation(videoID, ext, lang string, auto bool) string {
+	if auto {
+		return fmt.Sprintf("%s/subtitles/auto/%s.%s", videoID, lang, ext)
+	}
+	return fmt.Sprintf("%s/subtitles/%s.%s", videoID, lang, ext)
+}
+
+// getSubtitleArgs 构建只下载字幕的 yt-dlp 参数
+//
+//	--skip-download: 不下载音视频
+//	--write-subs / --write-auto-subs: 下载上传字幕或自动字幕
+//	--sub-langs: 字幕语言
+//	--sub-format: 字幕格式，srt 优先使用原始格式，没有时从 vtt 转换
+func getSubtitleArgs(ext, lang string, auto bool) []string {
+	args := []string{"--skip-download"}
+	if auto {
+		args = append(args, "--write-auto-subs")
+	} else {
+		args = append(args, "--write-subs")
+	}
+	args = append(args, "--sub-langs", lang)
+	if ext == "srt" {
+		args = append(args, "--sub-format", "srt/vtt", "--convert-subs", "srt")
+	} else {
+		args = append(args, "--sub-format", ext)
+	}
+	return args
+}
+
+// findSubtitleFile 在临时目录中查找 yt-dlp 写出的字幕文件
+// yt-dlp 会在输出文件名后追加语言代码，如 <name>.en.vtt
+func findSubtitleFile(workDir, ext string) (string, error) {
+	matches, err := filepath.Glob(filepath.Join(workDir, "*."+ext))
+	if err != nil {
+		return "", err
+	}
+	if len(matches) == 0 {
+		return "", fmt.Errorf("subtitle file not found in %s", workDir)
+	}
+	return matches[0], nil
+}
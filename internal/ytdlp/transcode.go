@@ -0,0 +1,90 @@
+package ytdlp
+
+import (
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/self-made-boy/youtube-tools/internal/config"
+)
+
+// 音视频流的处理方式
+const (
+	// ProcessingCopy 源编码与目标容器兼容，直接复制流
+	ProcessingCopy = "copy"
+	// ProcessingTranscode 需要转码为目标容器的编码
+	ProcessingTranscode = "transcode"
+)
+
+// ProcessingDecision 下载后对音视频流的处理方式
+type ProcessingDecision struct {
+	// 源视频编码，音频任务或无法得知时为空
+	SourceVcodec string `json:"source_vcodec,omitempty" example:"avc1.640028"`
+	// 源音频编码，无法得知时为空
+	SourceAcodec string `json:"source_acodec,omitempty" example:"mp4a.40.2"`
+	// 视频流的处理方式：copy 或 transcode，音频任务为空
+	Video string `json:"video,omitempty" example:"copy"`
+	// 音频流的处理方式：copy 或 transcode
+	Audio string `json:"audio,omitempty" example:"copy"`
+}
+
+// decideProcessing 根据缓存的视频信息查出所选格式的源编码，判断每个流是否可以直接复制到目标容器
+// 格式选择器或视频信息不可用时无法得知源编码，全部转码；预设设置了的流总是转码，并将结果记录在任务上
+func (s *Service) decideProcessing(task *DownloadTask, ext, originalFormatIDs string, preset *config.PresetConfig) ProcessingDecision {
+	decision := ProcessingDecision{
+		Audio: ProcessingTranscode,
+	}
+	if s.IsVideoFormatID(task.Format) {
+		decision.Video = ProcessingTranscode
+	}
+
+	if !isFormatSelector(originalFormatIDs) {
+		if rawInfo, err := s.getRawVideoInfo(task.URL); err != nil {
+			s.logger.Warn("Failed to get source codecs, falling back to transcoding",
+				zap.String("task_id", task.ID),
+				zap.Error(err))
+		} else {
+			for _, id := range strings.Split(originalFormatIDs, "+") {
+				format, ok := rawInfo.findFormat(id)
+				if !ok {
+					continue
+				}
+				if format.Vcodec != "" && format.Vcodec != "none" {
+					decision.SourceVcodec = format.Vcodec
+				}
+				if format.Acodec != "" && format.Acodec != "none" {
+					decision.SourceAcodec = format.Acodec
+				}
+			}
+		}
+	}
+
+	// mkv 等未列出的容器可以直接封装任意编码，getFfmpegArgs 对其始终复制
+	_, known := containerCodecs[ext]
+	if decision.Video != "" && (!known || decision.SourceVcodec != "" && isVideoCodecCompatible(decision.SourceVcodec, ext)) {
+		decision.Video = ProcessingCopy
+	}
+	if !known || decision.SourceAcodec != "" && isAudioCodecCompatible(decision.SourceAcodec, ext) {
+		decision.Audio = ProcessingCopy
+	}
+	if decision.Video != "" && presetHasVideo(preset) {
+		decision.Video = ProcessingTranscode
+	}
+	if presetHasAudio(preset) {
+		decision.Audio = ProcessingTranscode
+	}
+
+	s.logger.Info("Decided stream processing",
+		zap.String("task_id", task.ID),
+		zap.String("ext", ext),
+		zap.String("preset", task.Preset),
+		zap.String("source_vcodec", decision.SourceVcodec),
+		zap.String("source_acodec", decision.SourceAcodec),
+		zap.String("video", decision.Video),
+		zap.String("audio", decision.Audio))
+
+	s.updateTask(task, func(t *DownloadTask) {
+		t.Processing = &decision
+	})
+	return decision
+}
@@ -0,0 +1,335 @@
+package ytdlp
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultWebhookMaxRetries 未配置 max_retries 时的默认重试次数
+	defaultWebhookMaxRetries = 5
+	// defaultWebhookTimeout 未配置 timeout 时单次回调请求的超时时间
+	defaultWebhookTimeout = 10 * time.Second
+	// webhookInitialBackoff 第一次重试前的等待时间，之后每次翻倍
+	webhookInitialBackoff = time.Second
+	// webhookMaxBackoff 重试等待时间的上限
+	webhookMaxBackoff = time.Minute
+)
+
+// ErrInvalidCallbackURL 回调地址格式错误、不在 allowed_hosts 中或指向内网地址
+var ErrInvalidCallbackURL = errors.New("invalid callback url")
+
+// sharedAddressSpace 运营商级 NAT 使用的地址段，netip 不将其视为私有地址
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// CallbackPayload 任务结束时回调请求的 JSON 内容
+type CallbackPayload struct {
+	// 任务ID
+	TaskID string `json:"task_id" example:"123456"`
+	// 任务状态：completed、failed 或 cancelled
+	State string `json:"state" example:"completed"`
+	// 下载文件路径
+	DownloadUrl string `json:"download_url,omitempty" example:"https://xxx.com/123456.m4a"`
+	// 开启签名下载地址时下载地址的过期时间
+	DownloadUrlExpiresAt *time.Time `json:"download_url_expires_at,omitempty"`
+	// 错误信息
+	Error string `json:"error,omitempty"`
+	// 错误码
+	ErrorCode string `json:"error_code,omitempty"`
+	// 任务创建时间
+	StartTime time.Time `json:"start_time"`
+	// 任务结束时间
+	EndTime time.Time `json:"end_time"`
+	// 任务耗时，单位：秒
+	DurationSeconds float64 `json:"duration_seconds" example:"12.5"`
+}
+
+// CallbackAttempt 一次回调投递的记录
+type CallbackAttempt struct {
+	// 回调地址
+	URL string `json:"url" example:"https://example.com/hooks/yt"`
+	// 第几次尝试，从 1 开始
+	Attempt int `json:"attempt" example:"1"`
+	// 尝试时间
+	Time time.Time `json:"time"`
+	// 响应状态码，请求未发出时为 0
+	StatusCode int `json:"status_code,omitempty" example:"200"`
+	// 失败原因
+	Error string `json:"error,omitempty"`
+	// 是否投递成功
+	Success bool `json:"success" example:"true"`
+}
+
+// SignCallback 计算回调签名，签名内容为 "<timestamp>.<body>"，结果为十六进制的 HMAC-SHA256
+// 接收方应使用相同的密钥重新计算并与 X-Signature-256 头中 sha256= 之后的值比较
+func SignCallback(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ValidateCallbackURL 检查回调地址，只允许 http 和 https
+// 配置了 allowed_hosts 时域名必须在列表中；否则不允许 localhost 和内网 IP，域名解析到的地址在连接时再检查
+func (s *Service) ValidateCallbackURL(callbackURL string) error {
+	u, err := url.Parse(callbackURL)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidCallbackURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%w: unsupported scheme %q", ErrInvalidCallbackURL, u.Scheme)
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "" {
+		return fmt.Errorf("%w: missing host", ErrInvalidCallbackURL)
+	}
+
+	if len(s.config.Webhook.AllowedHosts) > 0 {
+		if !s.isAllowedCallbackHost(host) {
+			return fmt.Errorf("%w: host %s is not in webhook.allowed_hosts", ErrInvalidCallbackURL, host)
+		}
+		return nil
+	}
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("%w: host %s is not allowed", ErrInvalidCallbackURL, host)
+	}
+	if ip, err := netip.ParseAddr(host); err == nil && !isPublicIP(ip) {
+		return fmt.Errorf("%w: address %s is not allowed", ErrInvalidCallbackURL, host)
+	}
+	return nil
+}
+
+// isAllowedCallbackHost 判断域名是否在 allowed_hosts 中，*.example.com 匹配 example.com 的所有子域名
+func (s *Service) isAllowedCallbackHost(host string) bool {
+	for _, allowed := range s.config.Webhook.AllowedHosts {
+		allowed = strings.ToLower(allowed)
+		if suffix, ok := strings.CutPrefix(allowed, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+		} else if host == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// isPublicIP 判断是否为公网地址，回环、私有、链路本地（包括云服务器元数据地址）、组播和未指定地址都不是
+func isPublicIP(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsValid() &&
+		!ip.IsLoopback() &&
+		!ip.IsPrivate() &&
+		!ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() &&
+		!ip.IsMulticast() &&
+		!ip.IsUnspecified() &&
+		!sharedAddressSpace.Contains(ip)
+}
+
+// newCallbackClient 创建投递回调使用的 HTTP 客户端，不使用代理，不跟随重定向
+// 回调域名不在 allowed_hosts 中时，在连接前检查域名解析到的地址，防止通过 DNS 指向内网
+func (s *Service) newCallbackClient(callbackURL string, timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout}
+	if u, err := url.Parse(callbackURL); err != nil || !s.isAllowedCallbackHost(strings.ToLower(u.Hostname())) {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !isPublicIP(addrPort.Addr()) {
+				return fmt.Errorf("%w: address %s is not allowed", ErrInvalidCallbackURL, addrPort.Addr())
+			}
+			return nil
+		}
+	}
+
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, address)
+			},
+			TLSHandshakeTimeout: timeout,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// addTaskCallback 为已存在的任务登记新的回调地址，任务已结束时立即投递
+func (s *Service) addTaskCallback(task *DownloadTask, callbackURL string) {
+	if callbackURL == "" {
+		return
+	}
+
+	s.mutex.Lock()
+	if slices.Contains(task.CallbackURLs, callbackURL) {
+		s.mutex.Unlock()
+		return
+	}
+	task.CallbackURLs = append(task.CallbackURLs, callbackURL)
+	finished := IsTaskFinished(task)
+	snapshot := *task
+	s.mutex.Unlock()
+
+	s.saveTask(&snapshot)
+	if finished {
+		go s.deliverCallback(snapshot, callbackURL)
+	}
+}
+
+// notifyCallbacks 任务结束时向所有回调地址投递通知
+func (s *Service) notifyCallbacks(task DownloadTask) {
+	for _, callbackURL := range task.CallbackURLs {
+		go s.deliverCallback(task, callbackURL)
+	}
+}
+
+// deliverCallback 向回调地址 POST 任务结果，失败时按指数退避重试，每次尝试都记录在任务上
+func (s *Service) deliverCallback(task DownloadTask, callbackURL string) {
+	downloadUrl, expiresAt := s.GetDownloadURL(&task)
+	payload := CallbackPayload{
+		TaskID:               task.ID,
+		State:                task.State,
+		DownloadUrl:          downloadUrl,
+		DownloadUrlExpiresAt: expiresAt,
+		Error:                task.Error,
+		ErrorCode:            task.ErrorCode,
+		StartTime:            task.StartTime,
+		EndTime:              task.EndTime,
+		DurationSeconds:      task.EndTime.Sub(task.StartTime).Seconds(),
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		s.logger.Error("Failed to marshal callback payload", zap.String("task_id", task.ID), zap.Error(err))
+		return
+	}
+
+	maxRetries := s.config.Webhook.MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = defaultWebhookMaxRetries
+	}
+	timeout := s.config.Webhook.Timeout
+	if timeout <= 0 {
+		timeout = defaultWebhookTimeout
+	}
+	client := s.newCallbackClient(callbackURL, timeout)
+	defer client.CloseIdleConnections()
+
+	for attempt := 1; attempt <= maxRetries+1; attempt++ {
+		record := CallbackAttempt{
+			URL:     callbackURL,
+			Attempt: attempt,
+			Time:    time.Now(),
+		}
+
+		statusCode, err := s.postCallback(client, callbackURL, body)
+		record.StatusCode = statusCode
+		retryable := true
+		switch {
+		case err != nil:
+			record.Error = err.Error()
+			// 地址不允许时重试也不会成功
+			retryable = !errors.Is(err, ErrInvalidCallbackURL)
+		case statusCode >= 200 && statusCode < 300:
+			record.Success = true
+		default:
+			record.Error = fmt.Sprintf("unexpected status code %d", statusCode)
+			// 除超时和限流外的 4xx 说明请求本身有问题，重试也不会成功
+			retryable = statusCode >= 500 || statusCode == http.StatusRequestTimeout || statusCode == http.StatusTooManyRequests
+		}
+		s.recordCallbackAttempt(task, record)
+
+		if record.Success {
+			s.logger.Info("Callback delivered",
+				zap.String("task_id", task.ID),
+				zap.String("callback_url", callbackURL),
+				zap.Int("attempt", attempt))
+			return
+		}
+
+		s.logger.Warn("Callback delivery failed",
+			zap.String("task_id", task.ID),
+			zap.String("callback_url", callbackURL),
+			zap.Int("attempt", attempt),
+			zap.Int("status_code", statusCode),
+			zap.String("error", record.Error))
+
+		if !retryable || attempt > maxRetries {
+			return
+		}
+		time.Sleep(callbackBackoff(attempt))
+	}
+}
+
+// callbackBackoff 返回第 attempt 次尝试失败后重试前的等待时间，从 webhookInitialBackoff 开始每次翻倍，不超过 webhookMaxBackoff
+func callbackBackoff(attempt int) time.Duration {
+	backoff := webhookInitialBackoff
+	for i := 1; i < attempt && backoff < webhookMaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, webhookMaxBackoff)
+}
+
+// postCallback 发送一次回调请求，返回响应状态码
+func (s *Service) postCallback(client *http.Client, callbackURL string, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "youtube-tools-webhook/1.0")
+	req.Header.Set("X-Webhook-Timestamp", strconv.FormatInt(timestamp, 10))
+	if s.config.Webhook.Secret != "" {
+		req.Header.Set("X-Signature-256", "sha256="+SignCallback(s.config.Webhook.Secret, timestamp, body))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	return resp.StatusCode, nil
+}
+
+// recordCallbackAttempt 将投递记录写入任务并持久化
+// 任务已被清理，或已被重新发起的同一任务替换时忽略，重新发起的任务有新的 StartTime
+func (s *Service) recordCallbackAttempt(delivered DownloadTask, record CallbackAttempt) {
+	s.mutex.Lock()
+	task, ok := s.downloads[delivered.ID]
+	if !ok || !task.StartTime.Equal(delivered.StartTime) {
+		s.mutex.Unlock()
+		return
+	}
+	task.Callbacks = append(task.Callbacks, record)
+	snapshot := *task
+	s.mutex.Unlock()
+
+	s.saveTask(&snapshot)
+}
@@ -0,0 +1,211 @@
+package ytdlp
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/self-made-boy/youtube-tools/internal/config"
+)
+
+// TestSignCallback 测试回调签名为 "<timestamp>.<body>" 的十六进制 HMAC-SHA256
+func TestSignCallback(t *testing.T) {
+	body := []byte(`{"task_id":"616263","state":"completed"}`)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte("1700000000." + string(body)))
+	expected := hex.EncodeToString(mac.Sum(nil))
+
+	if got := SignCallback("secret", 1700000000, body); got != expected {
+		t.Errorf("SignCallback() = %s, expected %s", got, expected)
+	}
+	if got := SignCallback("secret", 1700000001, body); got == expected {
+		t.Error("SignCallback() does not depend on the timestamp")
+	}
+	if got := SignCallback("other", 1700000000, body); got == expected {
+		t.Error("SignCallback() does not depend on the secret")
+	}
+}
+
+// TestCallbackBackoff 测试重试等待时间从 1s 开始翻倍且不超过上限
+func TestCallbackBackoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		attempt  int
+		expected time.Duration
+	}{
+		{"第一次失败", 1, time.Second},
+		{"第二次失败", 2, 2 * time.Second},
+		{"第四次失败", 4, 8 * time.Second},
+		{"达到上限", 7, time.Minute},
+		{"超过上限", 30, time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := callbackBackoff(tt.attempt); got != tt.expected {
+				t.Errorf("callbackBackoff(%d) = %s, expected %s", tt.attempt, got, tt.expected)
+			}
+		})
+	}
+}
+
+// TestService_ValidateCallbackURL 测试回调地址的协议、内网地址和 allowed_hosts 校验
+func TestService_ValidateCallbackURL(t *testing.T) {
+	tests := []struct {
+		name         string
+		allowedHosts []string
+		url          string
+		expectErr    bool
+	}{
+		{"公网域名", nil, "https://example.com/hooks/yt", false},
+		{"公网 IP", nil, "http://8.8.8.8/hook", false},
+		{"不支持的协议", nil, "ftp://example.com/hook", true},
+		{"缺少域名", nil, "http:///hook", true},
+		{"localhost", nil, "http://localhost:8080/hook", true},
+		{"回环地址", nil, "http://127.0.0.1/hook", true},
+		{"内网地址", nil, "http://10.0.0.5/hook", true},
+		{"云服务器元数据地址", nil, "http://169.254.169.254/latest/meta-data/", true},
+		{"IPv6 回环地址", nil, "http://[::1]/hook", true},
+		{"IPv4 映射的内网地址", nil, "http://[::ffff:192.168.1.1]/hook", true},
+		{"在允许列表中", []string{"hooks.example.com"}, "https://hooks.example.com/yt", false},
+		{"匹配通配符", []string{"*.example.com"}, "https://a.b.example.com/yt", false},
+		{"通配符不匹配根域名", []string{"*.example.com"}, "https://example.com/yt", true},
+		{"不在允许列表中", []string{"hooks.example.com"}, "https://evil.com/yt", true},
+		{"允许列表中的内网地址", []string{"127.0.0.1"}, "http://127.0.0.1:9000/hook", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &Service{config: &config.Config{Webhook: config.WebhookConfig{AllowedHosts: tt.allowedHosts}}}
+			err := service.ValidateCallbackURL(tt.url)
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("ValidateCallbackURL(%s) error = %v, expectErr %v", tt.url, err, tt.expectErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidCallbackURL) {
+				t.Errorf("ValidateCallbackURL(%s) error = %v, expected ErrInvalidCallbackURL", tt.url, err)
+			}
+		})
+	}
+}
+
+// newCallbackTestService 创建投递回调的测试服务，downloads 中包含 task
+func newCallbackTestService(webhook config.WebhookConfig, task *DownloadTask) *Service {
+	return &Service{
+		config:    &config.Config{Webhook: webhook},
+		logger:    zap.NewNop(),
+		store:     NewMemoryTaskStore(),
+		downloads: map[string]*DownloadTask{task.ID: task},
+	}
+}
+
+// TestService_DeliverCallback 测试回调请求的签名头，以及失败后的重试和投递记录
+func TestService_DeliverCallback(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp, err := strconv.ParseInt(r.Header.Get("X-Webhook-Timestamp"), 10, 64)
+		if err != nil {
+			t.Errorf("invalid X-Webhook-Timestamp: %v", err)
+		}
+		if expected := "sha256=" + SignCallback("s3cr3t", timestamp, body); r.Header.Get("X-Signature-256") != expected {
+			t.Errorf("X-Signature-256 = %s, expected %s", r.Header.Get("X-Signature-256"), expected)
+		}
+		var payload CallbackPayload
+		if err := json.Unmarshal(body, &payload); err != nil || payload.TaskID != "616263" || payload.State != "failed" {
+			t.Errorf("payload = %s, error = %v", body, err)
+		}
+		// 第一次请求返回 500，之后成功
+		if requests.Add(1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	task := &DownloadTask{ID: "616263", State: "failed", StartTime: time.Now()}
+	service := newCallbackTestService(config.WebhookConfig{
+		Secret:       "s3cr3t",
+		MaxRetries:   1,
+		AllowedHosts: []string{"127.0.0.1"},
+	}, task)
+	service.deliverCallback(*task, server.URL)
+
+	if got := requests.Load(); got != 2 {
+		t.Fatalf("callback requests = %d, expected 2", got)
+	}
+	if len(task.Callbacks) != 2 {
+		t.Fatalf("callback attempts = %+v, expected 2", task.Callbacks)
+	}
+	if first := task.Callbacks[0]; first.Success || first.StatusCode != http.StatusInternalServerError || first.Attempt != 1 {
+		t.Errorf("first attempt = %+v, expected failed with status 500", first)
+	}
+	if second := task.Callbacks[1]; !second.Success || second.StatusCode != http.StatusNoContent || second.Attempt != 2 {
+		t.Errorf("second attempt = %+v, expected success with status 204", second)
+	}
+}
+
+// TestService_DeliverCallback_NoRetry 测试请求本身有问题或地址不允许时不重试
+func TestService_DeliverCallback_NoRetry(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name             string
+		allowedHosts     []string
+		expectedRequests int32
+		expectedError    string
+	}{
+		{"返回 400", []string{"127.0.0.1"}, 1, "unexpected status code 400"},
+		{"地址解析到回环地址", nil, 0, "not allowed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests.Store(0)
+			task := &DownloadTask{ID: "616263", State: "completed", StartTime: time.Now()}
+			service := newCallbackTestService(config.WebhookConfig{AllowedHosts: tt.allowedHosts}, task)
+			service.deliverCallback(*task, server.URL)
+
+			if got := requests.Load(); got != tt.expectedRequests {
+				t.Errorf("callback requests = %d, expected %d", got, tt.expectedRequests)
+			}
+			if len(task.Callbacks) != 1 || !strings.Contains(task.Callbacks[0].Error, tt.expectedError) {
+				t.Errorf("callback attempts = %+v, expected one attempt with error %q", task.Callbacks, tt.expectedError)
+			}
+		})
+	}
+}
+
+// TestService_RecordCallbackAttempt 测试上一次执行的回调记录不会写入重新发起的任务
+func TestService_RecordCallbackAttempt(t *testing.T) {
+	previous := DownloadTask{ID: "616263", State: "cancelled", StartTime: time.Now().Add(-time.Minute)}
+	current := &DownloadTask{ID: "616263", State: "pending", StartTime: time.Now()}
+	service := newCallbackTestService(config.WebhookConfig{}, current)
+
+	service.recordCallbackAttempt(previous, CallbackAttempt{URL: "https://example.com/hook", Attempt: 1})
+	if len(current.Callbacks) != 0 {
+		t.Errorf("attempt of previous run recorded on requeued task: %+v", current.Callbacks)
+	}
+
+	service.recordCallbackAttempt(*current, CallbackAttempt{URL: "https://example.com/hook", Attempt: 1})
+	if len(current.Callbacks) != 1 {
+		t.Errorf("attempt of current run not recorded: %+v", current.Callbacks)
+	}
+}
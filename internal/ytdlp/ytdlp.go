@@ -3,7 +3,6 @@ package ytdlp
 import (
 	"bufio"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -12,6 +11,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -21,6 +21,8 @@ import (
 	"golang.org/x/sync/singleflight"
 
 	"github.com/self-made-boy/youtube-tools/internal/config"
+	"github.com/self-made-boy/youtube-tools/internal/metrics"
+	"github.com/self-made-boy/youtube-tools/internal/storage"
 	"github.com/self-made-boy/youtube-tools/internal/utils"
 )
 
@@ -30,28 +32,76 @@ type Service struct {
 	logger    *zap.Logger
 	downloads map[string]*DownloadTask
 	mutex     sync.RWMutex
+	// queue 等待执行的下载任务队列
+	queue *downloadQueue
+	// store 持久化下载任务状态
+	store TaskStore
+	// playlists 播放列表展开结果缓存
+	playlists playlistCache
+	// events 任务状态变化的订阅者
+	events taskBroker
 	// group 用于确保同一videoID只执行一次
-	group     singleflight.Group
+	group singleflight.Group
+	// formatPolicy 配置的格式排序规则
+	formatPolicy FormatPolicy
+	// presets 配置的转码预设
+	presets map[string]config.PresetConfig
+	// readiness 就绪检查结果缓存
+	readiness readinessCache
+	// infoLRU 解析后视频信息的内存缓存
+	infoLRU *infoLRU
+	// storage 保存下载结果和视频信息缓存的存储
+	storage storage.Storage
+	// streamSlots 限制同时进行的流式下载数
+	streamSlots chan struct{}
+	// access 存储中文件的最后访问时间
+	access accessIndex
+	// retentionMutex 保证同一时间只有一次存储清理
+	retentionMutex sync.Mutex
 }
 
 // DownloadTask 表示一个下载任务
 type DownloadTask struct {
-	ID          string             `json:"id"`
-	URL         string             `json:"url"`
-	Format      string             `json:"format"`
-	State       string             `json:"state"` // pending, downloading, completed, failed
-	Progress    float64            `json:"progress"`
-	Speed       string             `json:"speed"`
-	ETA         string             `json:"eta"`
-	DownloadUrl string             `json:"download_url,omitempty"`
-	Error       string             `json:"error,omitempty"`
-	StartTime   time.Time          `json:"start_time"`
-	EndTime     time.Time          `json:"end_time,omitempty"`
-	Cmd         *exec.Cmd          `json:"-"`
-	Ctx         context.Context    `json:"-"`
-	Cancel      context.CancelFunc `json:"-"`
+	ID          string    `json:"id"`
+	URL         string    `json:"url"`
+	Format      string    `json:"format"`
+	State       string    `json:"state"` // pending, downloading, completed, failed, cancelled
+	Progress    float64   `json:"progress"`
+	Speed       string    `json:"speed"`
+	ETA         string    `json:"eta"`
+	DownloadUrl string    `json:"download_url,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	ErrorCode   string    `json:"error_code,omitempty"`
+	StartTime   time.Time `json:"start_time"`
+	EndTime     time.Time `json:"end_time,omitempty"`
+	Revision    int64     `json:"revision"` // 每次任务变化时递增，用作 SSE 事件ID
+	// 只下载视频片段时的起止时间
+	Clip *ClipRange `json:"clip,omitempty"`
+	// 使用的转码预设名称
+	Preset string `json:"preset,omitempty"`
+	// 下载后对音视频流的处理方式
+	Processing *ProcessingDecision `json:"processing,omitempty"`
+	// 任务结束时回调的地址及投递记录
+	CallbackURLs []string           `json:"callback_urls,omitempty"`
+	Callbacks    []CallbackAttempt  `json:"callbacks,omitempty"`
+	Cmd          *exec.Cmd          `json:"-"`
+	Ctx          context.Context    `json:"-"`
+	Cancel       context.CancelFunc `json:"-"`
+	// 是否已因格式信息失效刷新视频信息并重试过，每个任务只重试一次
+	infoRefreshed bool
 }
 
+var (
+	// ErrTaskNotFound 下载任务不存在
+	ErrTaskNotFound = errors.New("download task not found")
+	// ErrTaskNotCancellable 下载任务已结束，无法取消
+	ErrTaskNotCancellable = errors.New("download task has already finished")
+	// ErrVideoUnavailable yt-dlp 无法获取视频信息，如视频不存在、已删除或需要登录
+	ErrVideoUnavailable = errors.New("video unavailable")
+	// ErrPlaylistURL 需要单个视频的接口收到了播放列表或频道链接
+	ErrPlaylistURL = errors.New("playlist or channel URL is not supported here, use the playlist API")
+)
+
 // VideoInfo 表示视频信息
 type VideoInfo struct {
 	// 视频ID
@@ -90,6 +140,10 @@ type VideoInfo struct {
 	Audio []AudioFormatGroup `json:"audio"`
 	// 视频格式
 	Video []VideoFormatGroup `json:"video"`
+	// 字幕，包括上传字幕和自动生成的字幕
+	Subtitles []SubtitleTrack `json:"subtitles"`
+	// 选择格式时使用的排序规则
+	Policy []string `json:"policy" example:"compatible,quality"`
 }
 
 // VideoFormatGroup 表示视频按照后缀名分组格式
@@ -108,7 +162,7 @@ type AudioFormatGroup struct {
 	Formats []AudioFormat `json:"formats"`
 }
 
-// VideoFormat 表示视频格式
+// VideoFormat 表示视频格式，编码和码率等信息来自合并前的源视频流和源音频流
 type VideoFormat struct {
 	// 格式ID
 	FormatID string `json:"format_id" example:"137"`
@@ -116,9 +170,29 @@ type VideoFormat struct {
 	Ext string `json:"ext" example:"mp4"`
 	// 分辨率
 	Resolution string `json:"resolution" example:"1920x1080"`
+	// 帧率
+	Fps float64 `json:"fps,omitempty" example:"60"`
+	// 动态范围，如 SDR、HDR10、HLG
+	DynamicRange string `json:"dynamic_range,omitempty" example:"HDR10"`
+	// 视频编码
+	Vcodec string `json:"vcodec,omitempty" example:"vp09.00.50.08"`
+	// 音频编码
+	Acodec string `json:"acodec,omitempty" example:"opus"`
+	// 总码率，单位：Kbps
+	Tbr float64 `json:"tbr,omitempty" example:"2500.5"`
+	// 视频码率，单位：Kbps
+	Vbr float64 `json:"vbr,omitempty" example:"2370"`
+	// 音频码率，单位：Kbps
+	Abr float64 `json:"abr,omitempty" example:"130.5"`
+	// 音频声道数
+	AudioChannels int `json:"audio_channels,omitempty" example:"2"`
+	// 音频语言
+	Language string `json:"language,omitempty" example:"en"`
+	// 预估文件大小，单位：字节，0 表示未知
+	Filesize int64 `json:"filesize,omitempty" example:"251658240"`
 }
 
-// AudioFormat 表示音频格式
+// AudioFormat 表示音频格式，编码和码率等信息来自源音频流
 type AudioFormat struct {
 	// 音频格式ID
 	FormatID string `json:"format_id" example:"140"`
@@ -127,96 +201,218 @@ type AudioFormat struct {
 
 	// 采样率
 	Asr int64 `json:"asr" example:"44100"`
+	// 音频编码
+	Acodec string `json:"acodec,omitempty" example:"opus"`
+	// 音频码率，单位：Kbps
+	Abr float64 `json:"abr,omitempty" example:"130.5"`
+	// 音频声道数
+	AudioChannels int `json:"audio_channels,omitempty" example:"2"`
+	// 音频语言
+	Language string `json:"language,omitempty" example:"en"`
+	// 预估文件大小，单位：字节，0 表示未知
+	Filesize int64 `json:"filesize,omitempty" example:"3407872"`
 }
 
-// New 创建一个新的 yt-dlp 服务
-func New(cfg *config.Config, logger *zap.Logger) *Service {
+// New 创建一个新的 yt-dlp 服务，fileStorage 用于保存下载结果和视频信息缓存
+func New(cfg *config.Config, logger *zap.Logger, fileStorage storage.Storage) *Service {
+	store, err := NewTaskStore(cfg.Ytdlp.TaskStoreDir)
+	if err != nil {
+		logger.Error("Failed to create task store, falling back to memory store",
+			zap.String("task_store_dir", cfg.Ytdlp.TaskStoreDir),
+			zap.Error(err))
+		store = NewMemoryTaskStore()
+	}
+
+	formatPolicy, err := ParseFormatPolicy(strings.Join(cfg.Ytdlp.FormatPolicy, ","))
+	if err != nil {
+		logger.Error("Invalid format policy in config, falling back to default",
+			zap.Strings("format_policy", cfg.Ytdlp.FormatPolicy),
+			zap.Error(err))
+		formatPolicy = nil
+	}
+	if len(formatPolicy) == 0 {
+		formatPolicy = defaultFormatPolicy
+	}
+
 	s := &Service{
-		config:    cfg,
-		logger:    logger,
-		downloads: make(map[string]*DownloadTask),
-		mutex:     sync.RWMutex{},
+		config:       cfg,
+		logger:       logger,
+		downloads:    make(map[string]*DownloadTask),
+		mutex:        sync.RWMutex{},
+		queue:        newDownloadQueue(),
+		store:        store,
+		formatPolicy: formatPolicy,
+		presets:      loadPresets(cfg.Ytdlp.Presets, logger),
+		infoLRU:      newInfoLRU(cfg.Ytdlp.InfoMemoryCache),
+		storage:      fileStorage,
 	}
 
+	maxStreams := cfg.Ytdlp.MaxStreams
+	if maxStreams <= 0 {
+		maxStreams = defaultMaxStreams
+	}
+	s.streamSlots = make(chan struct{}, maxStreams)
+
+	// 恢复上次运行时保存的任务
+	s.restoreTasks()
+
+	// 启动下载 worker
+	s.startWorkers()
+
 	// 启动清理 goroutine
 	go s.startCleanupRoutine()
 
+	// 启动存储清理 goroutine
+	go s.startRetentionRoutine()
+
 	return s
 }
 
-// CheckUrl 检查URL是否为有效的YouTube视频链接,返回纯净的链接和视频 Id
+// defaultSignedURLExpiry 未配置 signed_url.expiry 时签名下载地址的有效期
+const defaultSignedURLExpiry = time.Hour
+
+// videoIDRegex 视频ID只包含字母、数字、下划线和连字符
+var videoIDRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// CheckUrl 检查URL是否为有效的YouTube视频、播放列表或频道链接，返回纯净的链接和视频 Id 或播放列表标识
+// 视频链接按 CheckVideoUrl 规范化，播放列表和频道链接按 CheckPlaylistUrl 规范化；
+// 同时带有 v 和 list 参数的观看页视为视频
 func (s *Service) CheckUrl(urlStr string) (string, string, error) {
+	normalizedURL, videoID, err := s.checkVideoUrl(urlStr)
+	if err == nil {
+		return normalizedURL, videoID, nil
+	}
+	if normalizedURL, playlistID, playlistErr := s.CheckPlaylistUrl(urlStr); playlistErr == nil {
+		return normalizedURL, playlistID, nil
+	}
+	return "", "", err
+}
+
+// CheckVideoUrl 检查URL是否为有效的YouTube视频链接，返回纯净的链接和视频 Id
+// 播放列表和频道链接返回 ErrPlaylistURL
+//
+// 支持以下形式，统一规范为 https://www.youtube.com/watch?v=ID 并去掉 si、feature、t 等其他参数：
+//
+//	https://www.youtube.com/watch?v=ID（含 youtube.com、m.youtube.com、music.youtube.com）
+//	https://youtu.be/ID
+//	https://www.youtube.com/shorts/ID
+//	https://www.youtube.com/live/ID
+//	https://www.youtube.com/embed/ID（含 youtube-nocookie.com）
+func (s *Service) CheckVideoUrl(urlStr string) (string, string, error) {
+	normalizedURL, videoID, err := s.checkVideoUrl(urlStr)
+	if err != nil {
+		if _, _, playlistErr := s.CheckPlaylistUrl(urlStr); playlistErr == nil {
+			return "", "", fmt.Errorf("%w: %s", ErrPlaylistURL, strings.TrimSpace(urlStr))
+		}
+		return "", "", err
+	}
+	return normalizedURL, videoID, nil
+}
+
+// checkVideoUrl 解析并规范化视频链接
+func (s *Service) checkVideoUrl(urlStr string) (string, string, error) {
+	urlStr = strings.TrimSpace(urlStr)
+	// 没有协议时补全，否则域名会被解析为路径
+	if !strings.Contains(urlStr, "://") {
+		urlStr = "https://" + urlStr
+	}
+
 	// 解析URL
 	parsedURL, err := url.Parse(urlStr)
 	if err != nil {
 		return "", "", err
 	}
 
-	// Check and normalize URL scheme
-	if parsedURL.Scheme == "" || parsedURL.Scheme == "http" {
-		parsedURL.Scheme = "https"
-	} else if parsedURL.Scheme != "https" {
+	// Check URL scheme
+	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
 		return "", "", fmt.Errorf("invalid URL scheme: %s", parsedURL.Scheme)
 	}
 
-	// Check if hostname is www.youtube.com, youtube.com or m.youtube.com
-	// Convert hostname to www.youtube.com if valid
-	switch parsedURL.Host {
-	case "youtube.com", "m.youtube.com":
-		parsedURL.Host = "www.youtube.com"
-	case "www.youtube.com":
-		// Already correct format
+	host := strings.ToLower(parsedURL.Hostname())
+	path := strings.TrimSuffix(parsedURL.Path, "/")
+
+	videoID := ""
+	switch host {
+	case "youtu.be":
+		// https://youtu.be/ID
+		videoID = strings.TrimPrefix(path, "/")
+	case "www.youtube.com", "youtube.com", "m.youtube.com", "music.youtube.com",
+		"www.youtube-nocookie.com", "youtube-nocookie.com":
+		videoID, err = extractVideoIDFromPath(path, parsedURL.Query())
+		if err != nil {
+			return "", "", err
+		}
 	default:
 		return "", "", fmt.Errorf("invalid URL host: %s", parsedURL.Host)
 	}
 
-	// 检查路径是否为 /watch
-	if parsedURL.Path != "/watch" {
-		return "", "", fmt.Errorf("invalid URL path: %s", parsedURL.Path)
-	}
-
-	// 检查是否包含 v 参数
-	queryParams := parsedURL.Query()
-	videoID := queryParams.Get("v")
 	if videoID == "" {
 		return "", "", fmt.Errorf("missing video ID in URL")
 	}
+	if !videoIDRegex.MatchString(videoID) {
+		return "", "", fmt.Errorf("invalid video ID: %s", videoID)
+	}
+
+	return "https://www.youtube.com/watch?v=" + videoID, videoID, nil
+}
+
+// extractVideoIDFromPath 从 youtube.com 域名下的路径中提取视频ID
+func extractVideoIDFromPath(path string, query url.Values) (string, error) {
+	if path == "/watch" {
+		return query.Get("v"), nil
+	}
 
-	return parsedURL.String(), videoID, nil
+	// /shorts/ID、/live/ID、/embed/ID、/v/ID
+	for _, prefix := range []string{"/shorts/", "/live/", "/embed/", "/v/"} {
+		if strings.HasPrefix(path, prefix) {
+			return strings.TrimPrefix(path, prefix), nil
+		}
+	}
+
+	return "", fmt.Errorf("invalid URL path: %s", path)
 }
-func (s *Service) getVideoJsonPath(videoID string) string {
-	return filepath.Join(s.config.S3Mount, fmt.Sprintf("%s/%s.json", videoID, videoID))
+
+// getVideoJsonKey 返回视频信息缓存在存储中的 key
+func getVideoJsonKey(videoID string) string {
+	return fmt.Sprintf("%s/%s.json", videoID, videoID)
 }
 
-// executeYtdlpCommand 执行yt-dlp命令获取视频信息
-func (s *Service) executeYtdlpCommand(url string) (string, error) {
-	_, videoID, err := s.CheckUrl(url)
+// executeYtdlpCommand 执行yt-dlp命令获取并解析视频信息，依次使用内存缓存和磁盘缓存
+// refresh 为 true 时忽略缓存重新获取
+func (s *Service) executeYtdlpCommand(url string, refresh bool) (*RawVideoInfo, error) {
+	url, videoID, err := s.CheckVideoUrl(url)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	if !refresh {
+		if rawInfo, ok := s.infoLRU.get(videoID); ok {
+			return rawInfo, nil
+		}
 	}
-	
-	// 使用singleflight确保同一videoID只执行一次
-	result, err, _ := s.group.Do(videoID, func() (interface{}, error) {
-		return s.doExecuteYtdlpCommand(url, videoID)
+
+	// 使用singleflight确保同一videoID只执行一次，强制刷新的请求不复用读取缓存的结果
+	key := videoID
+	if refresh {
+		key += ":refresh"
+	}
+	result, err, _ := s.group.Do(key, func() (interface{}, error) {
+		return s.doExecuteYtdlpCommand(url, videoID, refresh)
 	})
-	
+
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	
-	return result.(string), nil
+
+	return result.(*RawVideoInfo), nil
 }
 
 // doExecuteYtdlpCommand 实际执行yt-dlp命令的逻辑
-func (s *Service) doExecuteYtdlpCommand(url, videoID string) (string, error) {
-	videoJsonPath := s.getVideoJsonPath(videoID)
-	
-	// 检查文件是否已存在
-	if _, statErr := os.Stat(videoJsonPath); statErr == nil {
-		// 文件存在，读取内容
-		content, readErr := os.ReadFile(videoJsonPath)
-		if readErr == nil {
-			return string(content), nil
+func (s *Service) doExecuteYtdlpCommand(url, videoID string, refresh bool) (*RawVideoInfo, error) {
+	// 使用未过期的磁盘缓存
+	if !refresh {
+		if content, fetchedAt, ok := s.readCachedInfo(videoID); ok {
+			return s.parseVideoInfo(url, videoID, content, fetchedAt)
 		}
 	}
 
@@ -250,6 +446,7 @@ func (s *Service) doExecuteYtdlpCommand(url, videoID string) (string, error) {
 	start := time.Now()
 	output, err := cmd.Output()
 	duration := time.Since(start)
+	metrics.ObserveYtdlp("info", duration, err)
 
 	if err != nil {
 		// 记录命令执行失败的详细信息
@@ -260,13 +457,15 @@ func (s *Service) doExecuteYtdlpCommand(url, videoID string) (string, error) {
 				zap.Int("exit_code", exitError.ExitCode()),
 				zap.Duration("duration", duration),
 				zap.String("command", fmt.Sprintf("%s %s", s.config.Ytdlp.Path, strings.Join(cmdArgs, " "))))
+			// yt-dlp 正常运行但无法获取视频，属于请求的视频有问题
+			return nil, fmt.Errorf("failed to get video info: %w: %s", ErrVideoUnavailable, lastErrorLine(exitError.Stderr))
 		} else {
 			s.logger.Error("Failed to execute yt-dlp command",
 				zap.Error(err),
 				zap.Duration("duration", duration),
 				zap.String("command", fmt.Sprintf("%s %s", s.config.Ytdlp.Path, strings.Join(cmdArgs, " "))))
 		}
-		return "", fmt.Errorf("failed to get video info: %w", err)
+		return nil, fmt.Errorf("failed to get video info: %w", err)
 	}
 
 	// 记录命令执行成功的信息
@@ -278,83 +477,85 @@ func (s *Service) doExecuteYtdlpCommand(url, videoID string) (string, error) {
 	// 记录输出内容（仅在debug级别，因为可能很长）
 	s.logger.Debug("yt-dlp command output", zap.String("output", string(output)))
 
-	// 将结果写入到 videoJsonPath 中
-	writeErr := os.WriteFile(videoJsonPath, output, 0644)
-	if writeErr != nil {
-		s.logger.Error("Failed to write video info to file", zap.Error(writeErr))
-	}
-	return string(output), nil
+	// 将结果写入缓存
+	s.writeCachedInfo(videoID, output)
+	return s.parseVideoInfo(url, videoID, output, time.Now())
 }
 
-// GetVideoInfo 获取视频信息
-func (s *Service) GetVideoInfo(url string) (*VideoInfo, error) {
-	s.logger.Info("Getting video info", zap.String("url", url))
+// lastErrorLine 返回 yt-dlp 错误输出中最后一个非空行，一般是 "ERROR: ..." 形式的错误原因
+func lastErrorLine(stderr []byte) string {
+	lines := strings.Split(strings.TrimSpace(string(stderr)), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
 
-	// 执行yt-dlp命令获取输出
-	outputStr, err := s.executeYtdlpCommand(url)
+// parseVideoInfo 解析 yt-dlp 输出的视频信息并加入内存缓存，fetchedAt 为获取视频信息的时间
+func (s *Service) parseVideoInfo(url, videoID string, output []byte, fetchedAt time.Time) (*RawVideoInfo, error) {
+	rawInfo, err := ParseRawVideoInfo(output)
 	if err != nil {
+		s.logger.Error("Failed to parse video info", zap.String("url", url), zap.Error(err))
 		return nil, err
 	}
+	s.infoLRU.add(videoID, rawInfo, int64(len(output)), fetchedAt.Add(s.getInfoCacheTTL()))
+	return rawInfo, nil
+}
 
-	output := []byte(outputStr)
-	// 解析 JSON 输出
-	var rawInfo map[string]interface{}
-	if err := json.Unmarshal(output, &rawInfo); err != nil {
-		s.logger.Error("Failed to parse video info", zap.Error(err))
-		return nil, fmt.Errorf("failed to parse video info: %w", err)
+// getRawVideoInfo 获取并解析视频信息，优先使用未过期的缓存
+func (s *Service) getRawVideoInfo(url string) (*RawVideoInfo, error) {
+	return s.executeYtdlpCommand(url, false)
+}
+
+// GetVideoInfo 获取视频信息，policy 为空时使用配置的格式排序规则，refresh 为 true 时忽略缓存重新获取
+func (s *Service) GetVideoInfo(url string, policy FormatPolicy, refresh bool) (*VideoInfo, error) {
+	s.logger.Info("Getting video info",
+		zap.String("url", url),
+		zap.Strings("policy", policy),
+		zap.Bool("refresh", refresh))
+
+	rawInfo, err := s.executeYtdlpCommand(url, refresh)
+	if err != nil {
+		return nil, err
 	}
+	return s.buildVideoInfo(rawInfo, s.resolveFormatPolicy(policy)), nil
+}
 
+// buildVideoInfo 根据 yt-dlp 输出的视频信息构建接口返回的视频信息
+func (s *Service) buildVideoInfo(rawInfo *RawVideoInfo, policy FormatPolicy) *VideoInfo {
 	// 提取所需信息
 	info := &VideoInfo{
-		ID:           getStringValue(rawInfo, "id"),
-		WebpageURL:   getStringValue(rawInfo, "webpage_url"),
-		Title:        getStringValue(rawInfo, "title"),
-		Description:  getStringValue(rawInfo, "description"),
-		Duration:     getIntValue(rawInfo, "duration"),
-		Thumbnail:    getStringValue(rawInfo, "thumbnail"),
-		ViewCount:    getInt64Value(rawInfo, "view_count"),
-		CommentCount: getInt64Value(rawInfo, "comment_count"),
-		LikeCount:    getInt64Value(rawInfo, "like_count"),
-		UploadDate:   getStringValue(rawInfo, "upload_date"),
-		Uploader:     getStringValue(rawInfo, "uploader"),
+		ID:           rawInfo.ID,
+		WebpageURL:   rawInfo.WebpageURL,
+		Title:        rawInfo.Title,
+		Description:  rawInfo.Description,
+		Duration:     int(rawInfo.Duration),
+		Thumbnail:    rawInfo.Thumbnail,
+		ViewCount:    rawInfo.ViewCount,
+		CommentCount: rawInfo.CommentCount,
+		LikeCount:    rawInfo.LikeCount,
+		UploadDate:   rawInfo.UploadDate,
+		Uploader:     rawInfo.Uploader,
+		Categories:   rawInfo.Categories,
+		Tags:         rawInfo.Tags,
+		ChannelName:  rawInfo.Channel,
+		ChannelURL:   rawInfo.ChannelURL,
+		Policy:       policy,
 	}
 
-	// 处理分类信息
-	info.Categories = getStringArrayValue(rawInfo, "categories")
-
-	// 提取标签信息
-	info.Tags = getStringArrayValue(rawInfo, "tags")
-
-	// 提取频道信息
-	info.ChannelName = getStringValue(rawInfo, "channel")
-	info.ChannelURL = getStringValue(rawInfo, "channel_url")
-
 	// 尝试获取频道订阅数
-	info.ChannelFollowerCount = getInt64Value(rawInfo, "channel_follower_count")
+	info.ChannelFollowerCount = rawInfo.ChannelFollowerCount
 	// 如果没有 channel_follower_count 字段，尝试 subscriber_count 字段
 	if info.ChannelFollowerCount == 0 {
-		info.ChannelFollowerCount = getInt64Value(rawInfo, "subscriber_count")
+		info.ChannelFollowerCount = rawInfo.SubscriberCount
 	}
 
-	// 提取格式信息
-	optimalAudioFormats, optimalVideoFormats := s.extractOptimalFormats(rawInfo)
-
-	maxAsr := int64(0)
-	maxAFormatId := ""
-
-	// 构建音频格式组列表
+	// 构建音频格式组列表，编码兼容性与目标容器有关，因此每种容器分别选择
 	for _, afe := range s.config.Ytdlp.AudioFormats {
+		optimalAudioFormats, _ := s.extractOptimalFormats(rawInfo, newFormatRanker(policy, afe, rawInfo))
 		formats := []AudioFormat{}
 		for _, af := range optimalAudioFormats {
-			formats = append(formats, AudioFormat{
-				FormatID: buildAudioFormatID(afe, af.Asr, af.FormatID),
-				Ext:      afe,
-				Asr:      af.Asr,
-			})
-			if af.Asr > maxAsr {
-				maxAsr = af.Asr
-				maxAFormatId = af.FormatID
-			}
+			format := af
+			format.FormatID = buildAudioFormatID(afe, af.Asr, af.FormatID)
+			format.Ext = afe
+			formats = append(formats, format)
 		}
 		if len(formats) > 0 {
 			info.Audio = append(info.Audio, AudioFormatGroup{
@@ -366,13 +567,22 @@ func (s *Service) GetVideoInfo(url string) (*VideoInfo, error) {
 
 	// 构建视频格式组列表
 	for _, vfe := range s.config.Ytdlp.VideoFormats {
+		ranker := newFormatRanker(policy, vfe, rawInfo)
+		_, optimalVideoFormats := s.extractOptimalFormats(rawInfo, ranker)
+
+		// 每个视频格式都与按排序规则选出的音频合并
+		bestAudio := s.selectBestAudio(rawInfo, ranker)
+		aFormatID := ""
+		if bestAudio != nil {
+			aFormatID = bestAudio.FormatID
+		}
+
 		formats := []VideoFormat{}
 		for _, vf := range optimalVideoFormats {
-			formats = append(formats, VideoFormat{
-				FormatID:   buildVideoFormatID(vfe, vf.Resolution, vf.FormatID, maxAFormatId),
-				Ext:        vfe,
-				Resolution: vf.Resolution,
-			})
+			format := mergeVideoAudioFormat(vf, bestAudio)
+			format.FormatID = buildVideoFormatID(vfe, vf.Resolution, vf.FormatID, aFormatID)
+			format.Ext = vfe
+			formats = append(formats, format)
 		}
 		if len(formats) > 0 {
 			videoGroup := VideoFormatGroup{
@@ -383,7 +593,10 @@ func (s *Service) GetVideoInfo(url string) (*VideoInfo, error) {
 		}
 	}
 
-	return info, nil
+	// 提取字幕信息
+	info.Subtitles = extractSubtitles(rawInfo)
+
+	return info
 }
 
 // buildAudioFormatID 构建音频格式 ID，格式为 a__ext__asr__formatID
@@ -439,6 +652,20 @@ func (s *Service) ParseVideoFormatID(formatID string) (ext string, resolution st
 	return ext, resolution, vaFormatID, nil
 }
 
+// getFormatKind 返回格式 ID 对应的下载类型（audio、video 或 subtitle）和目标扩展名
+func (s *Service) getFormatKind(formatID string) (kind, ext string) {
+	if s.IsSubtitleFormatID(formatID) {
+		ext, _, _, _ = s.ParseSubtitleFormatID(formatID)
+		return "subtitle", ext
+	}
+	if s.IsVideoFormatID(formatID) {
+		ext, _, _, _ = s.ParseVideoFormatID(formatID)
+		return "video", ext
+	}
+	ext, _, _, _ = s.ParseAudioFormatID(formatID)
+	return "audio", ext
+}
+
 // IsVideoFormatID 检查格式 ID 是否为视频格式
 func (s *Service) IsVideoFormatID(formatID string) bool {
 	formatID, err := utils.FromHex(formatID)
@@ -448,51 +675,109 @@ func (s *Service) IsVideoFormatID(formatID string) bool {
 	return strings.HasPrefix(formatID, "v__")
 }
 
-func (s *Service) getTaskId(url, formatID string) (string, error) {
-	_, videoID, err := s.CheckUrl(url)
+func (s *Service) getTaskId(url, formatID string, clip *ClipRange, preset string) (string, error) {
+	_, videoID, err := s.CheckVideoUrl(url)
 	if err != nil {
 		return "", err
 	}
 
-	task_id := ""
+	task_id := s.getTaskLocation(videoID, formatID, clip, preset)
+	return utils.ToHex(task_id), nil
+
+}
+
+// getTaskLocation 返回任务结果相对 S3Mount 的路径，同时也是解码后的任务ID
+// 片段下载放在 <videoID>/clip/<start>-<end>/ 下，使用预设时再放在 preset/<name>/ 下，互不覆盖
+func (s *Service) getTaskLocation(videoID, formatID string, clip *ClipRange, preset string) string {
+	prefix := videoID
+	if clip != nil {
+		prefix = fmt.Sprintf("%s/clip/%s", videoID, clip.String())
+	}
+	if preset != "" {
+		prefix = fmt.Sprintf("%s/preset/%s", prefix, preset)
+	}
+
 	// 添加格式
-	if s.IsVideoFormatID(formatID) {
+	if s.IsSubtitleFormatID(formatID) {
+		ext, lang, auto, _ := s.ParseSubtitleFormatID(formatID)
+		return getSubtitleLocation(videoID, ext, lang, auto)
+	} else if s.IsVideoFormatID(formatID) {
 		ext, resolution, _, _ := s.ParseVideoFormatID(formatID)
-		task_id = fmt.Sprintf("%s/video/%s/%s.%s", videoID, resolution, videoID, ext)
-	} else {
-		ext, asr, _, _ := s.ParseAudioFormatID(formatID)
-
-		task_id = fmt.Sprintf("%s/audio/%d/%s.%s", videoID, asr, videoID, ext)
+		return fmt.Sprintf("%s/video/%s/%s.%s", prefix, resolution, videoID, ext)
 	}
-	return utils.ToHex(task_id), nil
+	ext, asr, _, _ := s.ParseAudioFormatID(formatID)
+	return fmt.Sprintf("%s/audio/%d/%s.%s", prefix, asr, videoID, ext)
+}
 
+// DownloadOptions 下载任务的可选参数
+type DownloadOptions struct {
+	// 任务结束时回调的地址
+	CallbackURL string
+	// 只下载视频片段，为 nil 时下载完整视频
+	Clip *ClipRange
+	// 转码预设名称，为空时不使用预设
+	Preset string
 }
 
 // StartDownload 开始下载视频
-func (s *Service) StartDownload(url, formatID string) (string, error) {
+func (s *Service) StartDownload(url, formatID string, opts DownloadOptions) (string, error) {
 	s.logger.Info("Starting download", zap.String("url", url), zap.String("format", formatID))
 
+	if opts.CallbackURL != "" {
+		if err := s.ValidateCallbackURL(opts.CallbackURL); err != nil {
+			return "", err
+		}
+	}
+
+	// 校验片段并补全结束时间，片段是任务ID的一部分，覆盖完整视频的片段按完整视频下载
+	clip := opts.Clip.normalize()
+	if clip != nil {
+		if s.IsSubtitleFormatID(formatID) {
+			return "", fmt.Errorf("%w: clips are not supported for subtitles", ErrInvalidClip)
+		}
+		resolved, err := s.resolveClip(url, clip)
+		if err != nil {
+			return "", err
+		}
+		clip = resolved
+	}
+
+	// 校验转码预设，预设是任务ID的一部分
+	if err := s.validatePreset(formatID, opts.Preset); err != nil {
+		return "", err
+	}
+
 	// 生成任务 ID
-	taskID, err := s.getTaskId(url, formatID)
+	taskID, err := s.getTaskId(url, formatID, clip, opts.Preset)
 	if err != nil {
 		return "", err
 	}
 
-	// 使用读锁检查任务是否已存在
+	// 使用读锁检查任务是否已存在，已存在时只登记回调地址
 	s.mutex.RLock()
-	if _, ok := s.downloads[taskID]; ok {
-		s.mutex.RUnlock()
+	existing, ok := s.downloads[taskID]
+	reuse := ok && !isTaskRetryable(existing)
+	s.mutex.RUnlock()
+	if reuse {
+		s.addTaskCallback(existing, opts.CallbackURL)
 		return taskID, nil
 	}
-	s.mutex.RUnlock()
+
+	// 检查所选格式的预估大小
+	if err := s.checkFormatSize(url, formatID, clip); err != nil {
+		return "", err
+	}
 
 	// 使用写锁进行双重检查并创建任务
 	s.mutex.Lock()
-	defer s.mutex.Unlock()
 
 	// 双重检查：在获取写锁后再次检查任务是否存在
 	// 防止在读锁释放到写锁获取之间有其他goroutine创建了相同的任务
-	if _, ok := s.downloads[taskID]; ok {
+	// 已失败或已取消的任务会被新任务替换
+	existing, ok = s.downloads[taskID]
+	if ok && !isTaskRetryable(existing) {
+		s.mutex.Unlock()
+		s.addTaskCallback(existing, opts.CallbackURL)
 		return taskID, nil
 	}
 
@@ -509,54 +794,200 @@ func (s *Service) StartDownload(url, formatID string) (string, error) {
 		Speed:     "0 B/s",
 		ETA:       "unknown",
 		StartTime: time.Now(),
+		Clip:      clip,
+		Preset:    opts.Preset,
 		Ctx:       ctx,
 		Cancel:    cancel,
 	}
+	if opts.CallbackURL != "" {
+		task.CallbackURLs = []string{opts.CallbackURL}
+	}
+	// 沿用被替换任务的 Revision，订阅者不会把新任务的快照当作已收到的旧事件
+	if ok {
+		task.Revision = existing.Revision + 1
+	}
 
 	s.downloads[taskID] = task
+	s.saveTask(task)
+	s.mutex.Unlock()
 
-	// 在后台启动下载
-	go s.runDownload(task)
+	// 加入等待队列，由 worker 按先进先出顺序执行
+	position := s.queue.push(task)
+	s.logger.Info("Download task queued",
+		zap.String("task_id", taskID),
+		zap.Int("queue_position", position))
 
 	return taskID, nil
 }
 
-// GetDownloadStatus 获取下载状态
+// GetDownloadStatus 获取下载状态，返回任务当前状态的副本
 func (s *Service) GetDownloadStatus(taskID string) (*DownloadTask, error) {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 
 	task, ok := s.downloads[taskID]
 	if !ok {
-		return nil, errors.New("download task not found")
+		return nil, ErrTaskNotFound
 	}
 
-	return task, nil
+	snapshot := *task
+	return &snapshot, nil
+}
+
+// isTaskRetryable 判断任务是否已失败或已取消，可以被重新发起的同一任务替换
+func isTaskRetryable(task *DownloadTask) bool {
+	return task.State == "failed" || task.State == "cancelled"
 }
 
 // CancelDownload 取消下载
-func (s *Service) CancelDownload(taskID string) error {
+// 排队中的任务直接从队列移除，下载中的任务会结束 yt-dlp 及其 ffmpeg 子进程并清理临时文件
+func (s *Service) CancelDownload(taskID string) (*DownloadTask, error) {
 	s.mutex.Lock()
-	defer s.mutex.Unlock()
-
 	task, ok := s.downloads[taskID]
 	if !ok {
-		return errors.New("download task not found")
+		s.mutex.Unlock()
+		return nil, ErrTaskNotFound
+	}
+
+	if task.State != "pending" && task.State != "downloading" {
+		s.mutex.Unlock()
+		return nil, ErrTaskNotCancellable
 	}
 
-	// 取消下载
-	if task.State == "downloading" && task.Cancel != nil {
+	// 先标记为已取消，runDownload 看到上下文被取消后只负责清理
+	if task.Cancel != nil {
 		task.Cancel()
-		task.State = "failed"
-		task.Error = "Download cancelled by user"
-		task.EndTime = time.Now()
 	}
+	task.State = "cancelled"
+	task.Error = "Download cancelled by user"
+	task.EndTime = time.Now()
+	task.Revision++
+	snapshot := *task
+	// 在锁内推送，与进度更新之间按 Revision 顺序到达订阅者
+	s.events.publish(snapshot)
+	s.mutex.Unlock()
+
+	// 排队中的任务不会再被 worker 执行
+	s.queue.remove(taskID)
+	s.saveTask(&snapshot)
+	s.notifyCallbacks(snapshot)
+
+	s.logger.Info("Download task cancelled", zap.String("task_id", taskID))
+	return &snapshot, nil
+}
+
+// updateTask 在锁内修改任务并通知订阅者，任务状态发生变化时将其持久化
+// 任务已被重新发起的同一任务替换时，上一次执行的更新不再推送和持久化
+func (s *Service) updateTask(task *DownloadTask, update func(t *DownloadTask)) {
+	s.mutex.Lock()
+	prevState := task.State
+	update(task)
+	task.Revision++
+	stateChanged := task.State != prevState
+	snapshot := *task
+	current := s.downloads[task.ID] == task
+	if current {
+		// 在锁内推送，保证订阅者按 Revision 顺序收到快照
+		s.events.publish(snapshot)
+	}
+	s.mutex.Unlock()
+
+	if !current {
+		return
+	}
+	if stateChanged {
+		s.saveTask(&snapshot)
+	}
+
+	if stateChanged && IsTaskFinished(&snapshot) {
+		s.notifyCallbacks(snapshot)
+	}
+}
+
+// failTask 将任务标记为失败
+func (s *Service) failTask(task *DownloadTask, message string) {
+	s.failTaskWithCode(task, "", message)
+}
+
+// failTaskWithCode 将任务标记为失败并记录错误码
+func (s *Service) failTaskWithCode(task *DownloadTask, code, message string) {
+	s.updateTask(task, func(t *DownloadTask) {
+		t.State = "failed"
+		t.Error = message
+		t.ErrorCode = code
+		t.EndTime = time.Now()
+	})
+}
+
+// completeTask 将任务标记为完成
+func (s *Service) completeTask(task *DownloadTask, downloadUrl string) {
+	s.updateTask(task, func(t *DownloadTask) {
+		t.State = "completed"
+		t.Progress = 100
+		t.Speed = "0 B/s"
+		t.ETA = "00:00"
+		t.DownloadUrl = downloadUrl
+		t.EndTime = time.Now()
+	})
+	if key, err := utils.FromHex(task.ID); err == nil {
+		s.access.touch(key)
+	}
+}
 
-	return nil
+// saveTask 持久化任务，失败时只记录日志
+func (s *Service) saveTask(task *DownloadTask) {
+	if err := s.store.Save(task); err != nil {
+		s.logger.Error("Failed to save download task",
+			zap.String("task_id", task.ID),
+			zap.Error(err))
+	}
+}
+
+// restoreTasks 从任务存储中恢复任务
+// 进程退出时处于 pending 或 downloading 状态的任务按原先的创建顺序重新加入队列
+func (s *Service) restoreTasks() {
+	tasks, err := s.store.List()
+	if err != nil {
+		s.logger.Warn("Failed to load some download tasks from store", zap.Error(err))
+	}
+
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].StartTime.Before(tasks[j].StartTime)
+	})
+
+	requeued := 0
+	for _, task := range tasks {
+		if task.State == "pending" || task.State == "downloading" {
+			// 上传中断时存储中会留下不完整的文件
+			if task.State == "downloading" {
+				s.removePartialUpload(task)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			task.State = "pending"
+			task.Progress = 0
+			task.Speed = "0 B/s"
+			task.ETA = "unknown"
+			task.Ctx = ctx
+			task.Cancel = cancel
+
+			s.downloads[task.ID] = task
+			s.saveTask(task)
+			s.queue.push(task)
+			requeued++
+			continue
+		}
+		s.downloads[task.ID] = task
+	}
+
+	if len(tasks) > 0 {
+		s.logger.Info("Restored download tasks from store",
+			zap.Int("total", len(tasks)),
+			zap.Int("requeued", requeued))
+	}
 }
 
 // GetActiveTasksCount 获取当前活跃的下载任务数量
-func (s *Service) GetActiveTasksCount() (total, pending, downloading, completed, failed int) {
+func (s *Service) GetActiveTasksCount() (total, pending, downloading, completed, failed, cancelled int) {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 
@@ -571,6 +1002,8 @@ func (s *Service) GetActiveTasksCount() (total, pending, downloading, completed,
 			completed++
 		case "failed":
 			failed++
+		case "cancelled":
+			cancelled++
 		}
 	}
 	return
@@ -609,36 +1042,65 @@ func (s *Service) GetActiveTasksCount() (total, pending, downloading, completed,
 //	--no-playlist: 只下载单个视频，不下载播放列表
 //	--restrict-filenames: 限制文件名字符，避免特殊字符
 //	--cookies: 指定cookies文件路径，用于访问需要登录的内容
+//	--ffmpeg-location: 指定ffmpeg路径，用于合并、转码和截取片段
 //	-f: 指定视频格式和质量
 //	-o: 指定输出文件路径和命名模板
 func (s *Service) runDownload(task *DownloadTask) {
+	// 格式信息失效时重新获取视频信息并重新执行一次
+	for s.executeDownload(task) {
+		s.logger.Warn("Retrying download with fresh video info", zap.String("task_id", task.ID))
+	}
+}
+
+// executeDownload 执行一次下载，需要用刷新后的视频信息重新执行时返回 true
+func (s *Service) executeDownload(task *DownloadTask) bool {
 	s.logger.Info("Running download task", zap.String("task_id", task.ID))
 
+	// 任务在排队期间已被取消
+	if task.Ctx.Err() != nil {
+		return false
+	}
+
 	decodedTaskID, err := utils.FromHex(task.ID)
 	if err != nil {
-		task.State = "failed"
-		task.Error = err.Error()
-		task.EndTime = time.Now()
-		return
+		s.failTask(task, err.Error())
+		return false
 	}
-	location := filepath.Join(s.config.S3Mount, decodedTaskID)
-	// 判断 location 文件是否存在，如果存在直接返回成功
-	if _, statErr := os.Stat(location); statErr == nil {
-		task.State = "completed"
-		task.EndTime = time.Now()
-		task.Progress = 100
-		task.Speed = "0 B/s"
-		task.ETA = "00:00"
-		task.DownloadUrl = s.getDownloadUrl(decodedTaskID)
-		return
+	// 结果已存在于存储中时直接返回成功
+	if _, statErr := s.storage.Stat(task.Ctx, decodedTaskID); statErr == nil {
+		s.completeTask(task, s.getDownloadUrl(decodedTaskID))
+		return false
+	} else if !errors.Is(statErr, storage.ErrNotFound) {
+		s.logger.Warn("Failed to check existing download in storage",
+			zap.String("task_id", task.ID),
+			zap.Error(statErr))
 	}
 
 	// 更新任务状态
-	task.State = "downloading"
+	s.updateTask(task, func(t *DownloadTask) {
+		if t.State == "pending" {
+			t.State = "downloading"
+		}
+	})
 
-	// 构建输出文件名
-	outputDir := s.config.Ytdlp.DownloadDir
-	outputTemplate := outputDir
+	// 预设可能在任务恢复前已从配置中删除
+	preset, err := s.getPreset(task.Preset)
+	if err != nil {
+		s.failTask(task, err.Error())
+		return false
+	}
+
+	// 每次执行使用独立的临时目录，取消或失败时整体清理
+	// 任务取消后可以立即以相同的任务ID重新开始，此时上一次执行可能仍在退出，不能共用目录
+	workDir, err := s.createTaskWorkDir(task.ID)
+	if err != nil {
+		s.logger.Error("Failed to create task work directory",
+			zap.String("task_id", task.ID),
+			zap.Error(err))
+		s.failTask(task, fmt.Sprintf("Failed to start download: %v", err))
+		return false
+	}
+	outputTemplate := ""
 
 	// 构建命令
 	cmdArgs := []string{
@@ -658,26 +1120,43 @@ func (s *Service) runDownload(task *DownloadTask) {
 		cmdArgs = append(cmdArgs, "--proxy", s.config.Ytdlp.Proxy)
 	}
 
-	_, videoID, _ := s.CheckUrl(task.URL)
+	// 合并、转码和截取片段都依赖 ffmpeg，未配置时由 yt-dlp 从 PATH 中查找
+	if s.config.Ytdlp.FfmpegPath != "" {
+		cmdArgs = append(cmdArgs, "--ffmpeg-location", s.config.Ytdlp.FfmpegPath)
+	}
+
+	_, videoID, _ := s.CheckVideoUrl(task.URL)
 
-	s3Location := ""
+	s3Location := s.getTaskLocation(videoID, task.Format, task.Clip, task.Preset)
+
+	subtitleExt := ""
 	// 添加格式
-	if s.IsVideoFormatID(task.Format) {
-		ext, resolution, vaFormatID, _ := s.ParseVideoFormatID(task.Format)
+	if s.IsSubtitleFormatID(task.Format) {
+		ext, lang, auto, _ := s.ParseSubtitleFormatID(task.Format)
+		cmdArgs = append(cmdArgs, getSubtitleArgs(ext, lang, auto)...)
+		// yt-dlp 会在文件名后追加语言代码和字幕扩展名，下载完成后再查找实际文件
+		outputTemplate = filepath.Join(workDir, videoID+".%(ext)s")
+		subtitleExt = ext
+	} else if s.IsVideoFormatID(task.Format) {
+		ext, _, vaFormatID, _ := s.ParseVideoFormatID(task.Format)
+		processing := s.decideProcessing(task, ext, vaFormatID, preset)
 		cmdArgs = append(cmdArgs, "-f", vaFormatID)
 		cmdArgs = append(cmdArgs, "--merge-output-format", ext)
-		cmdArgs = append(cmdArgs, "--postprocessor-args", getFfmpegArgs(ext))
-
-		s3Location = fmt.Sprintf("%s/video/%s/%s.%s", videoID, resolution, videoID, ext)
-		outputTemplate = filepath.Join(outputDir, s3Location)
+		cmdArgs = append(cmdArgs, "--postprocessor-args", getFfmpegArgs(ext, processing, preset))
+		outputTemplate = filepath.Join(workDir, filepath.Base(s3Location))
 	} else {
-		ext, asr, aFormatID, _ := s.ParseAudioFormatID(task.Format)
+		ext, _, aFormatID, _ := s.ParseAudioFormatID(task.Format)
+		processing := s.decideProcessing(task, ext, aFormatID, preset)
 		cmdArgs = append(cmdArgs, "-f", aFormatID)
 		cmdArgs = append(cmdArgs, "-x")
 		cmdArgs = append(cmdArgs, "--audio-format", ext)
-		cmdArgs = append(cmdArgs, "--postprocessor-args", getFfmpegArgs(ext))
-		s3Location = fmt.Sprintf("%s/audio/%d/%s.%s", videoID, asr, videoID, ext)
-		outputTemplate = filepath.Join(outputDir, s3Location)
+		cmdArgs = append(cmdArgs, "--postprocessor-args", getFfmpegArgs(ext, processing, preset))
+		outputTemplate = filepath.Join(workDir, filepath.Base(s3Location))
+	}
+
+	// 只下载片段
+	if task.Clip != nil {
+		cmdArgs = append(cmdArgs, getClipArgs(task.Clip)...)
 	}
 
 	// 添加输出模板
@@ -686,9 +1165,16 @@ func (s *Service) runDownload(task *DownloadTask) {
 	// 添加 URL
 	cmdArgs = append(cmdArgs, task.URL)
 
-	// 创建命令
-	cmd := exec.CommandContext(task.Ctx, s.config.Ytdlp.Path, cmdArgs...)
+	// 下载上下文，超过 max_file_size 等内部原因也会取消下载
+	downloadCtx, cancelDownload := context.WithCancelCause(task.Ctx)
+	defer cancelDownload(nil)
+
+	// 创建命令，yt-dlp 及其启动的 ffmpeg 运行在同一个进程组中，取消时一并结束
+	cmd := exec.CommandContext(downloadCtx, s.config.Ytdlp.Path, cmdArgs...)
+	setProcessGroup(cmd)
+	s.mutex.Lock()
 	task.Cmd = cmd
+	s.mutex.Unlock()
 
 	// 记录要执行的下载命令详情
 	s.logger.Info("Executing yt-dlp command for download",
@@ -702,9 +1188,9 @@ func (s *Service) runDownload(task *DownloadTask) {
 			zap.String("task_id", task.ID),
 			zap.Error(err),
 			zap.String("command", fmt.Sprintf("%s %s", s.config.Ytdlp.Path, strings.Join(cmdArgs, " "))))
-		task.State = "failed"
-		task.Error = fmt.Sprintf("Failed to start download: %v", err)
-		return
+		s.removeTaskWorkDir(task.ID, workDir)
+		s.failTask(task, fmt.Sprintf("Failed to start download: %v", err))
+		return false
 	}
 
 	stderrPipe, err := cmd.StderrPipe()
@@ -713,9 +1199,9 @@ func (s *Service) runDownload(task *DownloadTask) {
 			zap.String("task_id", task.ID),
 			zap.Error(err),
 			zap.String("command", fmt.Sprintf("%s %s", s.config.Ytdlp.Path, strings.Join(cmdArgs, " "))))
-		task.State = "failed"
-		task.Error = fmt.Sprintf("Failed to start download: %v", err)
-		return
+		s.removeTaskWorkDir(task.ID, workDir)
+		s.failTask(task, fmt.Sprintf("Failed to start download: %v", err))
+		return false
 	}
 
 	// 启动命令
@@ -725,28 +1211,39 @@ func (s *Service) runDownload(task *DownloadTask) {
 			zap.String("task_id", task.ID),
 			zap.Error(err),
 			zap.String("command", fmt.Sprintf("%s %s", s.config.Ytdlp.Path, strings.Join(cmdArgs, " "))))
-		task.State = "failed"
-		task.Error = fmt.Sprintf("Failed to start download: %v", err)
-		return
+		s.removeTaskWorkDir(task.ID, workDir)
+		s.failTask(task, fmt.Sprintf("Failed to start download: %v", err))
+		return false
 	}
 
 	s.logger.Info("yt-dlp download command started successfully",
 		zap.String("task_id", task.ID),
 		zap.Int("process_id", cmd.Process.Pid))
 
-	// 处理输出
-	go s.processOutput(task, stdoutPipe, stderrPipe)
+	// 监控已写入的文件大小
+	go s.watchFileSize(downloadCtx, cancelDownload, task, workDir)
+
+	// 读完全部输出后再等待命令结束，同时记录是否出现了格式信息失效的错误
+	staleFormats := s.processOutput(task, stdoutPipe, stderrPipe)
 
 	// 等待命令完成
-	if err := cmd.Wait(); err != nil {
+	err = cmd.Wait()
+	metrics.ObserveYtdlp("download", time.Since(commandStartTime), err)
+	if err != nil {
 		commandDuration := time.Since(commandStartTime)
-		// 检查是否是因为取消而失败
+		// 检查是否是因为取消而失败，任务状态已由 CancelDownload 更新
 		if task.Ctx.Err() == context.Canceled {
 			s.logger.Info("Download cancelled",
 				zap.String("task_id", task.ID),
 				zap.Duration("command_duration", commandDuration))
-			task.State = "failed"
-			task.Error = "Download cancelled by user"
+			s.removeTaskWorkDir(task.ID, workDir)
+		} else if cause := context.Cause(downloadCtx); errors.Is(cause, ErrFileTooLarge) {
+			s.logger.Warn("Download aborted because file is too large",
+				zap.String("task_id", task.ID),
+				zap.Duration("command_duration", commandDuration),
+				zap.Error(cause))
+			s.removeTaskWorkDir(task.ID, workDir)
+			s.failTaskWithCode(task, ErrorCodeFileTooLarge, cause.Error())
 		} else {
 			// 记录命令执行失败的详细信息
 			if exitError, ok := err.(*exec.ExitError); ok {
@@ -763,125 +1260,226 @@ func (s *Service) runDownload(task *DownloadTask) {
 					zap.Duration("command_duration", commandDuration),
 					zap.String("command", fmt.Sprintf("%s %s", s.config.Ytdlp.Path, strings.Join(cmdArgs, " "))))
 			}
-			task.State = "failed"
-			task.Error = fmt.Sprintf("Download failed: %v", err)
+			s.removeTaskWorkDir(task.ID, workDir)
+			if staleFormats {
+				refreshErr := s.refreshFormatInfo(task)
+				if refreshErr == nil {
+					return true
+				}
+				if errors.Is(refreshErr, ErrFormatUnavailable) {
+					s.failTaskWithCode(task, ErrorCodeFormatUnavailable, refreshErr.Error())
+					return false
+				}
+				s.logger.Warn("Not retrying download with fresh video info",
+					zap.String("task_id", task.ID),
+					zap.Error(refreshErr))
+			}
+			s.failTask(task, fmt.Sprintf("Download failed: %v", err))
 		}
-	} else if task.State != "failed" {
-		commandDuration := time.Since(commandStartTime)
-		// 将文件 outputTemplate mv 到 s3Location
-		destinationPath := filepath.Join(s.config.S3Mount, s3Location)
-		if err := s.moveFile(outputTemplate, destinationPath); err != nil {
-			s.logger.Error("Failed to move file to S3 location",
+		return false
+	}
+
+	// 命令结束后才被取消
+	if task.Ctx.Err() != nil {
+		s.removeTaskWorkDir(task.ID, workDir)
+		return false
+	}
+
+	commandDuration := time.Since(commandStartTime)
+	outputPath := outputTemplate
+	if subtitleExt != "" {
+		outputPath, err = findSubtitleFile(workDir, subtitleExt)
+		if err != nil {
+			s.logger.Error("Subtitle file not found after download",
 				zap.String("task_id", task.ID),
-				zap.Error(err),
-				zap.String("source", outputTemplate),
-				zap.String("destination", destinationPath))
-			task.State = "failed"
-			task.Error = fmt.Sprintf("Failed to move file to S3 location: %v", err)
-			return
+				zap.Error(err))
+			s.removeTaskWorkDir(task.ID, workDir)
+			s.failTask(task, fmt.Sprintf("Subtitle not available: %v", err))
+			return false
 		}
-		// 下载成功
-		downloadUrl := s.getDownloadUrl(s3Location)
-		s.logger.Info("Download completed successfully",
+	}
+
+	// 将文件 outputPath 上传到存储的 s3Location
+	size, err := s.uploadFile(task.Ctx, outputPath, s3Location)
+	s.removeTaskWorkDir(task.ID, workDir)
+	if err != nil {
+		// 上传期间被取消，任务状态已由 CancelDownload 更新
+		if task.Ctx.Err() != nil {
+			return false
+		}
+		s.logger.Error("Failed to upload file to storage",
 			zap.String("task_id", task.ID),
-			zap.Duration("command_duration", commandDuration),
-			zap.String("download_url", downloadUrl))
-		task.State = "completed"
-		task.Progress = 100
-		task.Speed = "0 B/s"
-		task.ETA = "00:00"
-		task.DownloadUrl = downloadUrl
+			zap.Error(err),
+			zap.String("source", outputPath),
+			zap.String("key", s3Location))
+		s.failTask(task, fmt.Sprintf("Failed to upload file to storage: %v", err))
+		return false
 	}
 
-	task.EndTime = time.Now()
+	kind, ext := s.getFormatKind(task.Format)
+	metrics.ObserveDownload(kind, ext, commandDuration, size)
+
+	// 下载成功
+	downloadUrl := s.getDownloadUrl(s3Location)
+	s.logger.Info("Download completed successfully",
+		zap.String("task_id", task.ID),
+		zap.Duration("command_duration", commandDuration),
+		zap.String("download_url", downloadUrl))
+	s.completeTask(task, downloadUrl)
+	return false
 }
 
-func getFfmpegArgs(ext string) string {
-	switch ext {
-	case "mp4":
-		return "ffmpeg:-c:v libx264 -c:a aac"
-	case "webm":
-		return "ffmpeg:-c:v libvpx-vp9 -c:a libopus"
-	case "avi":
-		return "ffmpeg:-c:v libx264 -c:a libmp3lame"
-	case "mov":
-		return "ffmpeg:-c:v libx264 -c:a aac"
-	case "flv":
-		return "ffmpeg:-c:v libx264 -c:a aac"
-	case "mp3":
-		return "ffmpeg:-c:a libmp3lame"
-	case "m4a":
-		return "ffmpeg:-c:a aac"
-	case "aac":
-		return "ffmpeg:-c:a aac"
-	case "opus":
-		return "ffmpeg:-c:a libopus"
-	case "flac":
-		return "ffmpeg:-c:a flac"
-	case "wav":
-		return "ffmpeg:-c:a pcm_s16le"
-	default:
+// createTaskWorkDir 为任务的一次执行创建临时下载目录：download_dir/<任务ID>/run-<随机后缀>
+func (s *Service) createTaskWorkDir(taskID string) (string, error) {
+	taskDir := filepath.Join(s.config.Ytdlp.DownloadDir, taskID)
+	var err error
+	// 上一次执行可能正好在删除空的任务目录，目录消失时重试一次
+	for i := 0; i < 2; i++ {
+		if err = os.MkdirAll(taskDir, 0755); err != nil {
+			continue
+		}
+		var workDir string
+		workDir, err = os.MkdirTemp(taskDir, "run-")
+		if err == nil {
+			return workDir, nil
+		}
+	}
+	return "", err
+}
+
+// removeTaskWorkDir 删除任务本次执行的临时下载目录及其中未完成的文件
+// 任务目录为空时一并删除，同一任务的其他执行仍在使用时保留
+func (s *Service) removeTaskWorkDir(taskID, workDir string) {
+	if err := os.RemoveAll(workDir); err != nil {
+		s.logger.Warn("Failed to remove task work directory",
+			zap.String("task_id", taskID),
+			zap.String("work_dir", workDir),
+			zap.Error(err))
+	}
+	_ = os.Remove(filepath.Dir(workDir))
+}
+
+// getFfmpegArgs 构建 ffmpeg 后处理参数，源编码与目标容器兼容的流直接复制，其余按容器的编码器转码
+// 使用预设时，预设设置了的流按预设的编码器和参数转码
+func getFfmpegArgs(ext string, processing ProcessingDecision, preset *config.PresetConfig) string {
+	codecs, ok := containerCodecs[ext]
+	if !ok && preset == nil {
 		return "ffmpeg:-c copy"
 	}
+
+	var args []string
+	if processing.Video != "" {
+		if processing.Video == ProcessingCopy {
+			args = append(args, "-c:v", "copy")
+		} else {
+			// 预设和容器都没有指定编码器时由 ffmpeg 使用容器的默认编码器
+			if encoder := presetVideoEncoder(preset, codecs.videoEncoder); encoder != "" {
+				args = append(args, "-c:v", encoder)
+			}
+			if presetHasVideo(preset) {
+				args = append(args, getPresetVideoArgs(preset)...)
+			}
+		}
+	}
+	if processing.Audio == ProcessingCopy {
+		args = append(args, "-c:a", "copy")
+	} else {
+		if encoder := presetAudioEncoder(preset, codecs.audioEncoder); encoder != "" {
+			args = append(args, "-c:a", encoder)
+		}
+		if presetHasAudio(preset) {
+			args = append(args, getPresetAudioArgs(preset)...)
+		}
+	}
+	return "ffmpeg:" + strings.Join(args, " ")
 }
+
 func (s *Service) getDownloadUrl(s3Location string) string {
-	return s.config.S3Prefix + s3Location
+	return s.storage.URL(s3Location)
 }
 
-// moveFile 安全地移动文件，支持跨文件系统操作
-func (s *Service) moveFile(src, dst string) error {
-	// 确保目标目录存在
-	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
-		return fmt.Errorf("failed to create destination directory: %w", err)
+// GetDownloadURL 返回任务的下载地址
+// 开启 signed_url 时为已完成的任务生成新的签名地址并返回其过期时间，否则返回任务保存的永久地址
+func (s *Service) GetDownloadURL(task *DownloadTask) (string, *time.Time) {
+	if task.State != "completed" || !s.config.Storage.SignedURL.Enabled {
+		return task.DownloadUrl, nil
 	}
 
-	// 打开源文件
-	srcFile, err := os.Open(src)
+	key, err := utils.FromHex(task.ID)
 	if err != nil {
-		return fmt.Errorf("failed to open source file: %w", err)
+		return "", nil
 	}
-	defer srcFile.Close()
-
-	// 创建目标文件
-	dstFile, err := os.Create(dst)
-	if err != nil {
-		return fmt.Errorf("failed to create destination file: %w", err)
+	s.access.touch(key)
+	expiry := s.config.Storage.SignedURL.Expiry
+	if expiry <= 0 {
+		expiry = defaultSignedURLExpiry
 	}
-	defer dstFile.Close()
-
-	// 复制文件内容
-	_, err = io.Copy(dstFile, srcFile)
+	expiresAt := time.Now().Add(expiry).Truncate(time.Second)
+	signedURL, err := s.storage.SignedURL(key, expiresAt)
 	if err != nil {
-		return fmt.Errorf("failed to copy file content: %w", err)
+		s.logger.Error("Failed to sign download url",
+			zap.String("task_id", task.ID),
+			zap.Error(err))
+		return "", nil
 	}
+	return signedURL, &expiresAt
+}
 
-	// 确保数据写入磁盘
-	if err := dstFile.Sync(); err != nil {
-		return fmt.Errorf("failed to sync destination file: %w", err)
+// OpenSignedFile 校验本地存储签名地址中的 expires 和 signature 并打开文件
+// 签名错误或过期时返回 storage.ErrInvalidSignature 或 storage.ErrURLExpired，使用对象存储时返回 storage.ErrNotFound
+func (s *Service) OpenSignedFile(ctx context.Context, key, expires, signature string) (io.ReadSeekCloser, storage.ObjectInfo, error) {
+	local, ok := s.storage.(*storage.LocalStorage)
+	if !ok {
+		return nil, storage.ObjectInfo{}, storage.ErrNotFound
+	}
+	if err := local.VerifySignature(key, expires, signature); err != nil {
+		return nil, storage.ObjectInfo{}, err
 	}
 
-	// 复制文件权限
-	if srcInfo, err := srcFile.Stat(); err == nil {
-		if err := os.Chmod(dst, srcInfo.Mode()); err != nil {
-			s.logger.Warn("Failed to copy file permissions",
-				zap.String("dst", dst),
-				zap.Error(err))
-		}
+	info, err := local.Stat(ctx, key)
+	if err != nil {
+		return nil, storage.ObjectInfo{}, err
+	}
+	reader, err := local.Open(ctx, key)
+	if err != nil {
+		return nil, storage.ObjectInfo{}, err
 	}
+	s.access.touch(key)
+	return reader, info, nil
+}
 
-	// 删除源文件
-	if err := os.Remove(src); err != nil {
-		return fmt.Errorf("failed to remove source file: %w", err)
+// uploadFile 将下载完成的文件上传到存储，返回文件大小
+func (s *Service) uploadFile(ctx context.Context, src, key string) (int64, error) {
+	file, err := os.Open(src)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open source file: %w", err)
 	}
+	defer file.Close()
 
-	return nil
+	stat, err := file.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat source file: %w", err)
+	}
+	if err := s.storage.Put(ctx, key, file); err != nil {
+		return 0, err
+	}
+	return stat.Size(), nil
 }
 
-// processOutput 处理命令输出
-func (s *Service) processOutput(task *DownloadTask, stdout, stderr io.ReadCloser) {
+// maxOutputLineSize yt-dlp 输出中单行的最大长度，更长的行之后的输出不再解析
+const maxOutputLineSize = 1 << 20
+
+// processOutput 处理命令输出，标准输出和标准错误都读完后返回，错误输出说明格式信息已失效时返回 true
+// exec.Cmd 要求读完管道后才能调用 Wait，否则 Wait 会关闭管道，丢失尚未读取的输出
+func (s *Service) processOutput(task *DownloadTask, stdout, stderr io.Reader) bool {
+	var wg sync.WaitGroup
+	wg.Add(2)
+
 	// 处理标准输出
 	go func() {
+		defer wg.Done()
 		scanner := bufio.NewScanner(stdout)
+		scanner.Buffer(make([]byte, 64<<10), maxOutputLineSize)
 		for scanner.Scan() {
 			line := scanner.Text()
 			s.logger.Info("yt-dlp download task stdout",
@@ -889,18 +1487,30 @@ func (s *Service) processOutput(task *DownloadTask, stdout, stderr io.ReadCloser
 				zap.String("line", line))
 			s.parseProgressLine(task, line)
 		}
+		// 行过长时扫描会提前结束，继续读完输出，避免 yt-dlp 阻塞在写入上
+		io.Copy(io.Discard, stdout)
 	}()
 
 	// 处理标准错误
+	staleFormats := false
 	go func() {
+		defer wg.Done()
 		scanner := bufio.NewScanner(stderr)
+		scanner.Buffer(make([]byte, 64<<10), maxOutputLineSize)
 		for scanner.Scan() {
 			line := scanner.Text()
 			s.logger.Info("yt-dlp download task stderr",
 				zap.String("task_id", task.ID),
 				zap.String("line", line))
+			if isStaleFormatError(line) {
+				staleFormats = true
+			}
 		}
+		io.Copy(io.Discard, stderr)
 	}()
+
+	wg.Wait()
+	return staleFormats
 }
 
 // parseProgressLine 解析进度行
@@ -908,14 +1518,18 @@ func (s *Service) parseProgressLine(task *DownloadTask, line string) {
 	s.logger.Debug("yt-dlp stdout", zap.String("line", line))
 
 	// 解析进度信息
-	if strings.Contains(line, "% of") {
+	if !strings.Contains(line, "% of") {
+		return
+	}
+
+	s.updateTask(task, func(t *DownloadTask) {
 		// 提取进度百分比
 		progressRegex := regexp.MustCompile(`(\d+\.\d+)%`)
 		matches := progressRegex.FindStringSubmatch(line)
 		if len(matches) > 1 {
 			progress, err := strconv.ParseFloat(matches[1], 64)
 			if err == nil {
-				task.Progress = progress
+				t.Progress = progress
 			}
 		}
 
@@ -923,246 +1537,160 @@ func (s *Service) parseProgressLine(task *DownloadTask, line string) {
 		speedRegex := regexp.MustCompile(`at\s+([\d\.]+\s*[KMGTP]?i?B/s)`)
 		matches = speedRegex.FindStringSubmatch(line)
 		if len(matches) > 1 {
-			task.Speed = matches[1]
+			t.Speed = matches[1]
 		}
 
 		// 提取剩余时间
 		etaRegex := regexp.MustCompile(`ETA\s+(\d+:\d+)`)
 		matches = etaRegex.FindStringSubmatch(line)
 		if len(matches) > 1 {
-			task.ETA = matches[1]
+			t.ETA = matches[1]
 		}
-	}
-}
-
-// 辅助函数
-
-func getStringValue(data map[string]interface{}, key string) string {
-	if value, ok := data[key].(string); ok {
-		return value
-	}
-	return ""
-}
-
-func getIntValue(data map[string]interface{}, key string) int {
-	switch value := data[key].(type) {
-	case int:
-		return value
-	case float64:
-		return int(value)
-	case string:
-		if i, err := strconv.Atoi(value); err == nil {
-			return i
-		}
-	}
-	return 0
-}
-
-func getInt64Value(data map[string]interface{}, key string) int64 {
-	switch value := data[key].(type) {
-	case int64:
-		return value
-	case int:
-		return int64(value)
-	case float64:
-		return int64(value)
-	case string:
-		if i, err := strconv.ParseInt(value, 10, 64); err == nil {
-			return i
-		}
-	}
-	return 0
+	})
 }
 
-func getResolution(data map[string]interface{}) string {
-	// 尝试从 resolution 字段获取
-	if res, ok := data["resolution"].(string); ok && res != "" {
-		return res
-	}
-
-	// 尝试从 width 和 height 字段构建
-	width := getIntValue(data, "width")
-	height := getIntValue(data, "height")
-	if width > 0 && height > 0 {
-		return fmt.Sprintf("%dx%d", width, height)
-	}
-
-	// 尝试从格式描述中提取
-	if format, ok := data["format"].(string); ok {
-		resRegex := regexp.MustCompile(`(\d+x\d+|\d+p)`)
-		matches := resRegex.FindStringSubmatch(format)
-		if len(matches) > 0 {
-			return matches[0]
+// extractOptimalFormats 提取音频和视频的最优格式
+// 音频按采样率分组，视频按分辨率分组，相同条件下按排序规则选择最好的
+// 结果按采样率、分辨率从高到低排序
+func (s *Service) extractOptimalFormats(rawInfo *RawVideoInfo, ranker formatRanker) ([]AudioFormat, []VideoFormat) {
+	// 按采样率分组的音频格式
+	audioByAsr := make(map[int64]*RawFormat)
+	// 按分辨率分组的视频格式
+	videoByResolution := make(map[string]*RawFormat)
+
+	for i := range rawInfo.Formats {
+		format := &rawInfo.Formats[i]
+
+		// 跳过 storyboard 格式
+		if format.IsStoryboard() {
+			continue
 		}
-	}
 
-	return "unknown"
-}
-
-// getStringArrayValue 从数据中提取字符串数组
-func getStringArrayValue(data map[string]interface{}, key string) []string {
-	var result []string
+		// 处理纯音频格式 (vcodec == "none" && acodec != "none")
+		if format.IsAudioOnly() {
+			// asr字段为0时跳过该格式
+			if format.Asr == 0 {
+				continue
+			}
 
-	if value, ok := data[key].([]interface{}); ok {
-		for _, item := range value {
-			if strItem, ok := item.(string); ok {
-				result = append(result, strItem)
+			// 检查是否已存在相同采样率的格式，存在时选择更好的
+			if existing, exists := audioByAsr[format.Asr]; !exists || ranker.isAudioBetter(format, existing) {
+				audioByAsr[format.Asr] = format
 			}
 		}
-	}
-
-	return result
-}
 
-// extractOptimalFormats 提取音频和视频的最优格式
-// 音频按采样率分组，视频按分辨率分组，相同条件下选择最高质量的
-func (s *Service) extractOptimalFormats(rawInfo map[string]interface{}) ([]AudioFormat, []VideoFormat) {
-	// 用于存储按采样率分组的音频格式原始数据
-	audioByAsr := make(map[string]map[string]interface{})
-	// 用于存储按分辨率分组的视频格式原始数据
-	videoByResolution := make(map[string]map[string]interface{})
-
-	if formatsRaw, ok := rawInfo["formats"].([]interface{}); ok {
-		for _, formatRaw := range formatsRaw {
-			if formatMap, ok := formatRaw.(map[string]interface{}); ok {
-				vcodec := getStringValue(formatMap, "vcodec")
-				acodec := getStringValue(formatMap, "acodec")
-
-				// 跳过 storyboard 格式
-				if strings.Contains(getStringValue(formatMap, "format_note"), "storyboard") {
-					continue
-				}
+		// 处理纯视频格式 (acodec == "none" && vcodec != "none")
+		if format.IsVideoOnly() {
+			resolution := format.GetResolution()
 
-				// 处理纯音频格式 (vcodec == "none" && acodec != "none")
-				if vcodec == "none" && acodec != "none" && acodec != "" {
-					asr := getInt64Value(formatMap, "asr")
-					if asr == 0 {
-						// asr字段为0时跳过该格式
-						continue
-					}
-
-					// 使用asr数值作为key
-					asrKey := fmt.Sprintf("%d", asr)
-					// 检查是否已存在相同采样率的格式
-					if existingMap, exists := audioByAsr[asrKey]; exists {
-						// 比较质量，选择更好的格式
-						if s.isAudioFormatMapBetter(formatMap, existingMap) {
-							audioByAsr[asrKey] = formatMap
-						}
-					} else {
-						audioByAsr[asrKey] = formatMap
-					}
-				}
-
-				// 处理纯视频格式 (acodec == "none" && vcodec != "none")
-				if acodec == "none" && vcodec != "none" && vcodec != "" {
-					resolution := getResolution(formatMap)
-					if resolution == "" {
-						resolution = "unknown"
-					}
-
-					// 检查是否已存在相同分辨率的格式
-					if existingMap, exists := videoByResolution[resolution]; exists {
-						// 比较质量，选择更好的格式
-						if s.isVideoFormatMapBetter(formatMap, existingMap) {
-							videoByResolution[resolution] = formatMap
-						}
-					} else {
-						videoByResolution[resolution] = formatMap
-					}
-				}
+			// 检查是否已存在相同分辨率的格式，存在时选择更好的
+			if existing, exists := videoByResolution[resolution]; !exists || ranker.isVideoBetter(format, existing) {
+				videoByResolution[resolution] = format
 			}
 		}
 	}
 
-	// 将map转换为slice，同时转换为目标结构体
+	// 转换为目标结构体
 	var audioFormats []AudioFormat
-	for _, formatMap := range audioByAsr {
-		audioFormat := AudioFormat{
-			FormatID: getStringValue(formatMap, "format_id"),
-			Ext:      getStringValue(formatMap, "ext"),
-			Asr:      getInt64Value(formatMap, "asr"),
-		}
-		audioFormats = append(audioFormats, audioFormat)
+	for _, format := range audioByAsr {
+		audioFormats = append(audioFormats, newAudioFormat(format, rawInfo.Duration))
 	}
+	sort.Slice(audioFormats, func(i, j int) bool {
+		return audioFormats[i].Asr > audioFormats[j].Asr
+	})
 
-	var videoFormats []VideoFormat
-	for _, formatMap := range videoByResolution {
-		videoFormat := VideoFormat{
-			FormatID:   getStringValue(formatMap, "format_id"),
-			Ext:        getStringValue(formatMap, "ext"),
-			Resolution: getResolution(formatMap),
+	videoRaw := make([]*RawFormat, 0, len(videoByResolution))
+	for _, format := range videoByResolution {
+		videoRaw = append(videoRaw, format)
+	}
+	sort.Slice(videoRaw, func(i, j int) bool {
+		if videoRaw[i].Height != videoRaw[j].Height {
+			return videoRaw[i].Height > videoRaw[j].Height
 		}
-		videoFormats = append(videoFormats, videoFormat)
+		if videoRaw[i].Width != videoRaw[j].Width {
+			return videoRaw[i].Width > videoRaw[j].Width
+		}
+		return videoRaw[i].GetResolution() < videoRaw[j].GetResolution()
+	})
+
+	var videoFormats []VideoFormat
+	for _, format := range videoRaw {
+		videoFormats = append(videoFormats, newVideoFormat(format, rawInfo.Duration))
 	}
 
 	return audioFormats, videoFormats
 }
 
-// isAudioFormatMapBetter 比较两个音频格式的质量（基于原始formatMap）
-// 返回 true 表示 a 比 b 更好
-func (s *Service) isAudioFormatMapBetter(a, b map[string]interface{}) bool {
-	// 1. 优先比较比特率（abr字段）
-	aAbr := getInt64Value(a, "abr")
-	bAbr := getInt64Value(b, "abr")
-	if aAbr != bAbr {
-		return aAbr > bAbr
+// selectBestAudio 按排序规则从所有纯音频格式中选出与视频合并的音频，没有可用音频时返回 nil
+func (s *Service) selectBestAudio(rawInfo *RawVideoInfo, ranker formatRanker) *AudioFormat {
+	var best *RawFormat
+	for i := range rawInfo.Formats {
+		format := &rawInfo.Formats[i]
+		if format.IsStoryboard() || !format.IsAudioOnly() || format.Asr == 0 {
+			continue
+		}
+		if best == nil || ranker.isAudioBetter(format, best) {
+			best = format
+		}
 	}
-
-	// 2. 比较文件大小（更大通常意味着更高质量）
-	aFilesize := getInt64Value(a, "filesize")
-	bFilesize := getInt64Value(b, "filesize")
-	if aFilesize != bFilesize {
-		return aFilesize > bFilesize
+	if best == nil {
+		return nil
 	}
 
-	return true
+	audio := newAudioFormat(best, rawInfo.Duration)
+	return &audio
 }
 
-// isVideoFormatMapBetter 比较两个视频格式的质量（基于原始formatMap）
-// 返回 true 表示 a 比 b 更好
-func (s *Service) isVideoFormatMapBetter(a, b map[string]interface{}) bool {
-	// 1. 优先比较比特率（vbr字段）
-	aVbr := getInt64Value(a, "vbr")
-	bVbr := getInt64Value(b, "vbr")
-	if aVbr != bVbr {
-		return aVbr > bVbr
+// newAudioFormat 根据源音频格式构建音频格式信息
+func newAudioFormat(format *RawFormat, duration float64) AudioFormat {
+	return AudioFormat{
+		FormatID:      format.FormatID,
+		Ext:           format.Ext,
+		Asr:           format.Asr,
+		Acodec:        format.Acodec,
+		Abr:           format.Abr,
+		AudioChannels: format.AudioChannels,
+		Language:      format.Language,
+		Filesize:      format.EstimateSize(duration),
 	}
+}
 
-	// 2. 比较帧率（fps字段）
-	aFps := getFloat64Value(a, "fps")
-	bFps := getFloat64Value(b, "fps")
-	if aFps != bFps {
-		return aFps > bFps
+// newVideoFormat 根据源视频格式构建视频格式信息
+func newVideoFormat(format *RawFormat, duration float64) VideoFormat {
+	return VideoFormat{
+		FormatID:     format.FormatID,
+		Ext:          format.Ext,
+		Resolution:   format.GetResolution(),
+		Fps:          format.Fps,
+		DynamicRange: format.DynamicRange,
+		Vcodec:       format.Vcodec,
+		Tbr:          format.Tbr,
+		Vbr:          format.Vbr,
+		Filesize:     format.EstimateSize(duration),
 	}
+}
 
-	// 3. 比较文件大小（更大通常意味着更高质量）
-	aFilesize := getInt64Value(a, "filesize")
-	bFilesize := getInt64Value(b, "filesize")
-	if aFilesize != bFilesize {
-		return aFilesize > bFilesize
+// mergeVideoAudioFormat 将纯视频格式与音频格式合并，补全音频信息并累加码率和预估大小
+// 任一部分大小未知时合并后的大小也视为未知
+func mergeVideoAudioFormat(video VideoFormat, audio *AudioFormat) VideoFormat {
+	merged := video
+	if audio == nil {
+		return merged
 	}
 
-	return true
-}
-
-// getFloat64Value 从数据中提取float64值
-func getFloat64Value(data map[string]interface{}, key string) float64 {
-	switch value := data[key].(type) {
-	case float64:
-		return value
-	case float32:
-		return float64(value)
-	case int:
-		return float64(value)
-	case int64:
-		return float64(value)
-	case string:
-		if f, err := strconv.ParseFloat(value, 64); err == nil {
-			return f
-		}
+	merged.Acodec = audio.Acodec
+	merged.Abr = audio.Abr
+	merged.AudioChannels = audio.AudioChannels
+	merged.Language = audio.Language
+	if merged.Tbr > 0 {
+		merged.Tbr += audio.Abr
 	}
-	return 0
+	if merged.Filesize > 0 && audio.Filesize > 0 {
+		merged.Filesize += audio.Filesize
+	} else {
+		merged.Filesize = 0
+	}
+	return merged
 }
 
 // startCleanupRoutine 启动清理例程，定期清理已完成的下载任务
@@ -1187,8 +1715,8 @@ func (s *Service) cleanupCompletedTasks() {
 	var tasksToDelete []string
 
 	for taskID, task := range s.downloads {
-		// 检查任务是否已完成（completed 或 failed）且超过10分钟
-		if (task.State == "completed" || task.State == "failed") &&
+		// 检查任务是否已结束（completed、failed 或 cancelled）且超过10分钟
+		if IsTaskFinished(task) &&
 			!task.EndTime.IsZero() &&
 			now.Sub(task.EndTime) > 10*time.Minute {
 			tasksToDelete = append(tasksToDelete, taskID)
@@ -1202,6 +1730,11 @@ func (s *Service) cleanupCompletedTasks() {
 			zap.String("state", s.downloads[taskID].State),
 			zap.Duration("age", now.Sub(s.downloads[taskID].EndTime)))
 		delete(s.downloads, taskID)
+		if err := s.store.Delete(taskID); err != nil {
+			s.logger.Error("Failed to delete download task from store",
+				zap.String("task_id", taskID),
+				zap.Error(err))
+		}
 	}
 
 	if len(tasksToDelete) > 0 {
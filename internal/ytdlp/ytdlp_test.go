@@ -1,11 +1,21 @@
 package ytdlp
 
 import (
+	"context"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 
 	"go.uber.org/zap"
 
 	"github.com/self-made-boy/youtube-tools/internal/config"
+	"github.com/self-made-boy/youtube-tools/internal/storage"
+	"github.com/self-made-boy/youtube-tools/internal/utils"
 )
 
 // TestService_CheckUrl 测试CheckUrl方法
@@ -13,7 +23,7 @@ func TestService_CheckUrl(t *testing.T) {
 	// 创建测试服务实例
 	cfg := &config.Config{}
 	logger := zap.NewNop() // 使用无操作日志器避免测试输出
-	service := New(cfg, logger)
+	service := New(cfg, logger, nil)
 
 	// 测试有效URL
 	validURL := "https://www.youtube.com/watch?v=1234567890"
@@ -35,3 +45,1156 @@ func TestService_CheckUrl(t *testing.T) {
 		t.Errorf("CheckUrl for invalid URL did not return error")
 	}
 }
+
+// TestService_CheckUrl_Normalize 测试不同形式的链接规范化为同一个视频链接
+func TestService_CheckUrl_Normalize(t *testing.T) {
+	service := New(&config.Config{}, zap.NewNop(), nil)
+
+	const canonical = "https://www.youtube.com/watch?v=dQw4w9WgXcQ"
+
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{name: "标准链接", input: "https://www.youtube.com/watch?v=dQw4w9WgXcQ"},
+		{name: "http协议", input: "http://www.youtube.com/watch?v=dQw4w9WgXcQ"},
+		{name: "无协议", input: "www.youtube.com/watch?v=dQw4w9WgXcQ"},
+		{name: "无www", input: "https://youtube.com/watch?v=dQw4w9WgXcQ"},
+		{name: "移动端", input: "https://m.youtube.com/watch?v=dQw4w9WgXcQ"},
+		{name: "YouTube Music", input: "https://music.youtube.com/watch?v=dQw4w9WgXcQ&feature=share"},
+		{name: "大写域名", input: "https://WWW.YouTube.com/watch?v=dQw4w9WgXcQ"},
+		{name: "前后空格", input: "  https://www.youtube.com/watch?v=dQw4w9WgXcQ \n"},
+		{name: "带时间参数", input: "https://www.youtube.com/watch?v=dQw4w9WgXcQ&t=42s"},
+		{name: "带播放列表参数", input: "https://www.youtube.com/watch?v=dQw4w9WgXcQ&list=PL123&index=2"},
+		{name: "参数顺序不同", input: "https://www.youtube.com/watch?feature=youtu.be&v=dQw4w9WgXcQ"},
+		{name: "短链接", input: "https://youtu.be/dQw4w9WgXcQ"},
+		{name: "短链接带si参数", input: "https://youtu.be/dQw4w9WgXcQ?si=AbCdEfGh123"},
+		{name: "短链接带时间参数", input: "youtu.be/dQw4w9WgXcQ?t=10"},
+		{name: "Shorts", input: "https://www.youtube.com/shorts/dQw4w9WgXcQ"},
+		{name: "Shorts带feature参数", input: "https://youtube.com/shorts/dQw4w9WgXcQ?feature=share"},
+		{name: "Shorts结尾斜杠", input: "https://www.youtube.com/shorts/dQw4w9WgXcQ/"},
+		{name: "直播", input: "https://www.youtube.com/live/dQw4w9WgXcQ?si=xyz"},
+		{name: "嵌入", input: "https://www.youtube.com/embed/dQw4w9WgXcQ"},
+		{name: "无cookie嵌入", input: "https://www.youtube-nocookie.com/embed/dQw4w9WgXcQ?start=30"},
+		{name: "旧版v路径", input: "https://www.youtube.com/v/dQw4w9WgXcQ"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			normalizedURL, videoID, err := service.CheckUrl(tt.input)
+			if err != nil {
+				t.Fatalf("CheckUrl(%q) returned error: %v", tt.input, err)
+			}
+			if normalizedURL != canonical {
+				t.Errorf("CheckUrl(%q) normalized URL = %q, expected %q", tt.input, normalizedURL, canonical)
+			}
+			if videoID != "dQw4w9WgXcQ" {
+				t.Errorf("CheckUrl(%q) video ID = %q, expected %q", tt.input, videoID, "dQw4w9WgXcQ")
+			}
+		})
+	}
+}
+
+// TestService_CheckUrl_Invalid 测试无效链接
+func TestService_CheckUrl_Invalid(t *testing.T) {
+	service := New(&config.Config{}, zap.NewNop(), nil)
+
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{name: "空字符串", input: ""},
+		{name: "其他网站", input: "https://vimeo.com/123456"},
+		{name: "不支持的协议", input: "ftp://www.youtube.com/watch?v=dQw4w9WgXcQ"},
+		{name: "缺少v参数", input: "https://www.youtube.com/watch?feature=share"},
+		{name: "不支持的频道标签页", input: "https://www.youtube.com/@RickAstleyYT/community"},
+		{name: "短链接缺少ID", input: "https://youtu.be/"},
+		{name: "Shorts缺少ID", input: "https://www.youtube.com/shorts/"},
+		{name: "ID包含非法字符", input: "https://www.youtube.com/watch?v=dQw4w9WgXcQ%3Cscript%3E"},
+		{name: "ID包含路径", input: "https://youtu.be/dQw4w9WgXcQ/extra"},
+		{name: "相似域名", input: "https://youtube.com.evil.com/watch?v=dQw4w9WgXcQ"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := service.CheckUrl(tt.input); err == nil {
+				t.Errorf("CheckUrl(%q) expected error, but got none", tt.input)
+			}
+		})
+	}
+}
+
+// TestService_CheckUrl_Playlist 测试 CheckUrl 接受播放列表和频道链接，CheckVideoUrl 对其返回 ErrPlaylistURL
+func TestService_CheckUrl_Playlist(t *testing.T) {
+	service := New(&config.Config{}, zap.NewNop(), nil)
+
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+		id       string
+		playlist bool
+	}{
+		{name: "播放列表", input: "https://www.youtube.com/playlist?list=PL123_abc", expected: "https://www.youtube.com/playlist?list=PL123_abc", id: "PL123_abc", playlist: true},
+		{name: "频道首页", input: "https://www.youtube.com/@RickAstleyYT", expected: "https://www.youtube.com/@RickAstleyYT/videos", id: "@RickAstleyYT/videos", playlist: true},
+		{name: "频道ID", input: "youtube.com/channel/UCuAXFkgsw1L7xaCfnd5JJOw/streams", expected: "https://www.youtube.com/channel/UCuAXFkgsw1L7xaCfnd5JJOw/streams", id: "channel/UCuAXFkgsw1L7xaCfnd5JJOw/streams", playlist: true},
+		{name: "观看页带播放列表视为视频", input: "https://www.youtube.com/watch?v=dQw4w9WgXcQ&list=PL123_abc", expected: "https://www.youtube.com/watch?v=dQw4w9WgXcQ", id: "dQw4w9WgXcQ"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			normalizedURL, id, err := service.CheckUrl(tt.input)
+			if err != nil {
+				t.Fatalf("CheckUrl(%q) returned error: %v", tt.input, err)
+			}
+			if normalizedURL != tt.expected || id != tt.id {
+				t.Errorf("CheckUrl(%q) = %q, %q, expected %q, %q", tt.input, normalizedURL, id, tt.expected, tt.id)
+			}
+
+			_, _, err = service.CheckVideoUrl(tt.input)
+			if tt.playlist != errors.Is(err, ErrPlaylistURL) {
+				t.Errorf("CheckVideoUrl(%q) error = %v, expected ErrPlaylistURL %v", tt.input, err, tt.playlist)
+			}
+			if !tt.playlist && err != nil {
+				t.Errorf("CheckVideoUrl(%q) returned error: %v", tt.input, err)
+			}
+		})
+	}
+}
+
+// TestService_CheckPlaylistUrl 测试播放列表和频道链接的规范化
+func TestService_CheckPlaylistUrl(t *testing.T) {
+	service := New(&config.Config{}, zap.NewNop(), nil)
+
+	tests := []struct {
+		name       string
+		input      string
+		expected   string
+		playlistID string
+		expectErr  bool
+	}{
+		{name: "播放列表", input: "https://www.youtube.com/playlist?list=PL123_abc", expected: "https://www.youtube.com/playlist?list=PL123_abc", playlistID: "PL123_abc"},
+		{name: "观看页带播放列表", input: "https://youtube.com/watch?v=dQw4w9WgXcQ&list=PL123_abc&index=3", expected: "https://www.youtube.com/playlist?list=PL123_abc", playlistID: "PL123_abc"},
+		{name: "频道handle", input: "https://www.youtube.com/@RickAstleyYT", expected: "https://www.youtube.com/@RickAstleyYT/videos", playlistID: "@RickAstleyYT/videos"},
+		{name: "频道handle视频页", input: "youtube.com/@RickAstleyYT/videos/", expected: "https://www.youtube.com/@RickAstleyYT/videos", playlistID: "@RickAstleyYT/videos"},
+		{name: "频道shorts页", input: "https://m.youtube.com/@RickAstleyYT/shorts", expected: "https://www.youtube.com/@RickAstleyYT/shorts", playlistID: "@RickAstleyYT/shorts"},
+		{name: "频道ID", input: "https://www.youtube.com/channel/UCuAXFkgsw1L7xaCfnd5JJOw", expected: "https://www.youtube.com/channel/UCuAXFkgsw1L7xaCfnd5JJOw/videos", playlistID: "channel/UCuAXFkgsw1L7xaCfnd5JJOw/videos"},
+		{name: "观看页缺少播放列表", input: "https://www.youtube.com/watch?v=dQw4w9WgXcQ", expectErr: true},
+		{name: "不支持的标签页", input: "https://www.youtube.com/@RickAstleyYT/community", expectErr: true},
+		{name: "短链接", input: "https://youtu.be/dQw4w9WgXcQ", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			normalizedURL, playlistID, err := service.CheckPlaylistUrl(tt.input)
+			if tt.expectErr {
+				if err == nil {
+					t.Errorf("CheckPlaylistUrl(%q) expected error, but got none", tt.input)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CheckPlaylistUrl(%q) returned error: %v", tt.input, err)
+			}
+			if normalizedURL != tt.expected {
+				t.Errorf("CheckPlaylistUrl(%q) normalized URL = %q, expected %q", tt.input, normalizedURL, tt.expected)
+			}
+			if playlistID != tt.playlistID {
+				t.Errorf("CheckPlaylistUrl(%q) playlist ID = %q, expected %q", tt.input, playlistID, tt.playlistID)
+			}
+		})
+	}
+}
+
+// TestService_GetPlaylistInfo_Truncated 测试播放列表条目超过 max_playlist_entries 时标记为截断
+func TestService_GetPlaylistInfo_Truncated(t *testing.T) {
+	// 播放列表共 3 个条目，按 --playlist-end 输出
+	ytdlpPath := writeFakeYtdlp(t, `
+end=0
+while [ $# -gt 0 ]; do
+	if [ "$1" = "--playlist-end" ]; then end="$2"; fi
+	shift
+done
+entries=""
+for id in aaaaaaaaaa1 aaaaaaaaaa2 aaaaaaaaaa3; do
+	[ "$end" -le 0 ] && break
+	end=$((end - 1))
+	[ -n "$entries" ] && entries="$entries,"
+	entries="$entries{\"id\":\"$id\",\"ie_key\":\"Youtube\"}"
+done
+echo "{\"id\":\"PL123_abc\",\"entries\":[$entries]}"
+`)
+
+	tests := []struct {
+		name       string
+		maxEntries int
+		total      int
+		truncated  bool
+	}{
+		{name: "超过上限", maxEntries: 2, total: 2, truncated: true},
+		{name: "等于上限", maxEntries: 3, total: 3, truncated: false},
+		{name: "低于上限", maxEntries: 5, total: 3, truncated: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.Ytdlp.Path = ytdlpPath
+			cfg.Ytdlp.MaxPlaylistEntries = tt.maxEntries
+			service := New(cfg, zap.NewNop(), nil)
+
+			info, err := service.GetPlaylistInfo("https://www.youtube.com/playlist?list=PL123_abc", 1, 10)
+			if err != nil {
+				t.Fatalf("GetPlaylistInfo returned error: %v", err)
+			}
+			if info.Total != tt.total || len(info.Entries) != tt.total {
+				t.Errorf("GetPlaylistInfo total = %d, entries = %d, expected %d", info.Total, len(info.Entries), tt.total)
+			}
+			if info.Truncated != tt.truncated {
+				t.Errorf("GetPlaylistInfo truncated = %v, expected %v", info.Truncated, tt.truncated)
+			}
+		})
+	}
+}
+
+// TestService_ParseSubtitleFormatID 测试字幕格式ID的构建与解析
+func TestService_ParseSubtitleFormatID(t *testing.T) {
+	service := New(&config.Config{}, zap.NewNop(), nil)
+
+	tests := []struct {
+		name      string
+		formatID  string
+		ext       string
+		lang      string
+		auto      bool
+		expectErr bool
+	}{
+		{name: "上传字幕", formatID: buildSubtitleFormatID("vtt", "en", false), ext: "vtt", lang: "en"},
+		{name: "自动字幕", formatID: buildSubtitleFormatID("srt", "zh-Hans", true), ext: "srt", lang: "zh-Hans", auto: true},
+		{name: "不支持的格式", formatID: buildSubtitleFormatID("ttml", "en", false), expectErr: true},
+		{name: "非法语言代码", formatID: buildSubtitleFormatID("vtt", "../en", false), expectErr: true},
+		{name: "音频格式ID", formatID: buildAudioFormatID("mp3", 48000, "251"), expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ext, lang, auto, err := service.ParseSubtitleFormatID(tt.formatID)
+			if tt.expectErr {
+				if err == nil {
+					t.Errorf("ParseSubtitleFormatID(%q) expected error, but got none", tt.formatID)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseSubtitleFormatID(%q) returned error: %v", tt.formatID, err)
+			}
+			if ext != tt.ext || lang != tt.lang || auto != tt.auto {
+				t.Errorf("ParseSubtitleFormatID(%q) = (%q, %q, %v), expected (%q, %q, %v)", tt.formatID, ext, lang, auto, tt.ext, tt.lang, tt.auto)
+			}
+		})
+	}
+}
+
+// TestParseClipRange 测试片段起止时间的解析
+func TestParseClipRange(t *testing.T) {
+	tests := []struct {
+		name      string
+		start     string
+		end       string
+		expected  *ClipRange
+		expectErr bool
+	}{
+		{name: "未指定片段", expected: nil},
+		{name: "秒数", start: "60", end: "90", expected: &ClipRange{Start: 60, End: 90}},
+		{name: "时分秒", start: "00:01:00", end: "1:01:30", expected: &ClipRange{Start: 60, End: 3690}},
+		{name: "只有开始时间", start: "1:30", expected: &ClipRange{Start: 90}},
+		{name: "只有结束时间", end: "30", expected: &ClipRange{End: 30}},
+		{name: "从头开始且没有结束时间视为完整视频", start: "0", expected: nil},
+		{name: "开始时间为 00:00:00 且没有结束时间", start: "00:00:00", expected: nil},
+		{name: "从头开始到指定时间", start: "0", end: "30", expected: &ClipRange{End: 30}},
+		{name: "结束早于开始", start: "90", end: "60", expectErr: true},
+		{name: "分钟超过59", start: "1:60", expectErr: true},
+		{name: "负数", start: "-5", expectErr: true},
+		{name: "非法格式", start: "1:2:3:4", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clip, err := ParseClipRange(tt.start, tt.end)
+			if tt.expectErr {
+				if err == nil {
+					t.Errorf("ParseClipRange(%q, %q) expected error, but got none", tt.start, tt.end)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseClipRange(%q, %q) returned error: %v", tt.start, tt.end, err)
+			}
+			if (clip == nil) != (tt.expected == nil) || (clip != nil && *clip != *tt.expected) {
+				t.Errorf("ParseClipRange(%q, %q) = %v, expected %v", tt.start, tt.end, clip, tt.expected)
+			}
+		})
+	}
+}
+
+// TestService_ResolveClip 测试按视频时长补全和校验片段，覆盖完整视频的片段返回 nil
+func TestService_ResolveClip(t *testing.T) {
+	service := New(&config.Config{}, zap.NewNop(), nil)
+	service.infoLRU.add("dQw4w9WgXcQ", &RawVideoInfo{ID: "dQw4w9WgXcQ", Duration: 213.6}, 1, time.Now().Add(time.Minute))
+	service.infoLRU.add("liveStream1", &RawVideoInfo{ID: "liveStream1"}, 1, time.Now().Add(time.Minute))
+	const url = "https://www.youtube.com/watch?v=dQw4w9WgXcQ"
+	const liveURL = "https://www.youtube.com/watch?v=liveStream1"
+
+	tests := []struct {
+		name      string
+		url       string
+		clip      ClipRange
+		expected  *ClipRange
+		expectErr bool
+	}{
+		{name: "补全结束时间", url: url, clip: ClipRange{Start: 10}, expected: &ClipRange{Start: 10, End: 214}},
+		{name: "结束时间覆盖不足一秒的结尾", url: url, clip: ClipRange{Start: 10, End: 214}, expected: &ClipRange{Start: 10, End: 214}},
+		{name: "从头开始到结尾视为完整视频", url: url, clip: ClipRange{Start: 0, End: 214}, expected: nil},
+		{name: "从头开始到结尾之前", url: url, clip: ClipRange{Start: 0, End: 213}, expected: &ClipRange{Start: 0, End: 213}},
+		{name: "结束时间超过时长", url: url, clip: ClipRange{Start: 0, End: 215}, expectErr: true},
+		{name: "开始时间超过时长", url: url, clip: ClipRange{Start: 300}, expectErr: true},
+		{name: "未知时长必须指定结束时间", url: liveURL, clip: ClipRange{Start: 10}, expectErr: true},
+		{name: "未知时长从头开始", url: liveURL, clip: ClipRange{Start: 0, End: 30}, expected: &ClipRange{Start: 0, End: 30}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clip := tt.clip
+			resolved, err := service.resolveClip(tt.url, &clip)
+			if tt.expectErr {
+				if !errors.Is(err, ErrInvalidClip) {
+					t.Errorf("resolveClip(%v) error = %v, expected ErrInvalidClip", tt.clip, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveClip(%v) returned error: %v", tt.clip, err)
+			}
+			if (resolved == nil) != (tt.expected == nil) || (resolved != nil && *resolved != *tt.expected) {
+				t.Errorf("resolveClip(%v) = %v, expected %v", tt.clip, resolved, tt.expected)
+			}
+			if clip != tt.clip {
+				t.Errorf("resolveClip modified the input clip to %v", clip)
+			}
+		})
+	}
+}
+
+// TestGetFfmpegArgs 测试兼容的流直接复制，其余按容器或预设的编码器转码
+func TestGetFfmpegArgs(t *testing.T) {
+	copyAll := ProcessingDecision{Video: ProcessingCopy, Audio: ProcessingCopy}
+	transcodeAll := ProcessingDecision{Video: ProcessingTranscode, Audio: ProcessingTranscode}
+	crf := 28
+	mobile := &config.PresetConfig{CRF: &crf, MaxHeight: 480, AudioBitrate: "96k"}
+	podcast := &config.PresetConfig{AudioBitrate: "64k", AudioChannels: 1, SampleRate: 44100}
+	lossless := &config.PresetConfig{VideoCodec: "ffv1", AudioCodec: "flac"}
+
+	tests := []struct {
+		name       string
+		ext        string
+		processing ProcessingDecision
+		preset     *config.PresetConfig
+		expected   string
+	}{
+		{name: "mp4转码", ext: "mp4", processing: transcodeAll, expected: "ffmpeg:-c:v libx264 -c:a aac"},
+		{name: "mp4复制", ext: "mp4", processing: copyAll, expected: "ffmpeg:-c:v copy -c:a copy"},
+		{name: "mp4只转码音频", ext: "mp4", processing: ProcessingDecision{Video: ProcessingCopy, Audio: ProcessingTranscode}, expected: "ffmpeg:-c:v copy -c:a aac"},
+		{name: "webm转码", ext: "webm", processing: transcodeAll, expected: "ffmpeg:-c:v libvpx-vp9 -c:a libopus"},
+		{name: "mp3转码", ext: "mp3", processing: ProcessingDecision{Audio: ProcessingTranscode}, expected: "ffmpeg:-c:a libmp3lame"},
+		{name: "m4a复制", ext: "m4a", processing: ProcessingDecision{Audio: ProcessingCopy}, expected: "ffmpeg:-c:a copy"},
+		{name: "未知容器", ext: "mkv", processing: transcodeAll, expected: "ffmpeg:-c copy"},
+		{name: "预设缩放视频", ext: "mp4", processing: transcodeAll, preset: mobile, expected: `ffmpeg:-c:v libx264 -crf 28 -vf 'scale=-2:min(ih\,480)' -c:a aac -b:a 96k`},
+		{name: "预设单声道音频", ext: "mp3", processing: ProcessingDecision{Audio: ProcessingTranscode}, preset: podcast, expected: "ffmpeg:-c:a libmp3lame -b:a 64k -ac 1 -ar 44100"},
+		{name: "预设只转码音频", ext: "mp4", processing: ProcessingDecision{Video: ProcessingCopy, Audio: ProcessingTranscode}, preset: podcast, expected: "ffmpeg:-c:v copy -c:a aac -b:a 64k -ac 1 -ar 44100"},
+		{name: "预设指定编码器", ext: "mkv", processing: transcodeAll, preset: lossless, expected: "ffmpeg:-c:v ffv1 -c:a flac"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getFfmpegArgs(tt.ext, tt.processing, tt.preset); got != tt.expected {
+				t.Errorf("getFfmpegArgs(%q, %+v) = %q, expected %q", tt.ext, tt.processing, got, tt.expected)
+			}
+		})
+	}
+}
+
+// TestService_GetTaskLocation_Preset 测试不同预设和片段的结果保存在不同路径下
+func TestService_GetTaskLocation_Preset(t *testing.T) {
+	service := &Service{}
+	formatID := buildVideoFormatID("mp4", "1920x1080", "137", "140")
+	clip := &ClipRange{Start: 60, End: 90}
+
+	tests := []struct {
+		name     string
+		clip     *ClipRange
+		preset   string
+		expected string
+	}{
+		{name: "无预设", expected: "abc/video/1920x1080/abc.mp4"},
+		{name: "预设", preset: "mobile-480p", expected: "abc/preset/mobile-480p/video/1920x1080/abc.mp4"},
+		{name: "片段加预设", clip: clip, preset: "mobile-480p", expected: "abc/clip/60-90/preset/mobile-480p/video/1920x1080/abc.mp4"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := service.getTaskLocation("abc", formatID, tt.clip, tt.preset); got != tt.expected {
+				t.Errorf("getTaskLocation() = %q, expected %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+// TestParseVersion 测试从 yt-dlp 和 ffmpeg 的版本输出中提取版本号
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		expected string
+	}{
+		{name: "yt-dlp", output: "2025.01.15\n", expected: "2025.01.15"},
+		{name: "ffmpeg", output: "ffmpeg version 6.1.1 Copyright (c) 2000-2023 the FFmpeg developers\nbuilt with gcc 12\n", expected: "6.1.1"},
+		{name: "空输出", output: "", expected: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseVersion(tt.output); got != tt.expected {
+				t.Errorf("parseVersion(%q) = %q, expected %q", tt.output, got, tt.expected)
+			}
+		})
+	}
+}
+
+// TestCheckBinaries_Missing 测试程序不存在时返回 ErrBinaryNotFound
+func TestCheckBinaries_Missing(t *testing.T) {
+	_, err := CheckBinaries(config.YtdlpConfig{
+		Path:       "/nonexistent/yt-dlp",
+		FfmpegPath: "/nonexistent/ffmpeg",
+	})
+	if !errors.Is(err, ErrBinaryNotFound) {
+		t.Errorf("CheckBinaries() error = %v, expected ErrBinaryNotFound", err)
+	}
+}
+
+// TestService_CheckReadiness 测试就绪检查返回每项检查的结果
+func TestService_CheckReadiness(t *testing.T) {
+	dir := t.TempDir()
+	service := &Service{
+		config: &config.Config{
+			S3Mount: dir,
+			Ytdlp: config.YtdlpConfig{
+				Path:        "/nonexistent/yt-dlp",
+				FfmpegPath:  "/nonexistent/ffmpeg",
+				DownloadDir: dir,
+				CookiesPath: dir + "/cookies.txt",
+			},
+			Readiness: config.ReadinessConfig{MinFreeSpace: 1},
+		},
+		queue:   newDownloadQueue(),
+		storage: storage.NewLocal(dir, ""),
+	}
+
+	report := service.CheckReadiness()
+	if report.Ready {
+		t.Fatal("CheckReadiness().Ready = true, expected false")
+	}
+
+	expected := map[string]bool{
+		"yt_dlp":       false,
+		"ffmpeg":       false,
+		"storage":      true,
+		"download_dir": true,
+		"cookies":      false,
+		"queue":        true,
+	}
+	if len(report.Checks) != len(expected) {
+		t.Fatalf("CheckReadiness() returned %d checks, expected %d", len(report.Checks), len(expected))
+	}
+	for _, check := range report.Checks {
+		if check.OK != expected[check.Name] {
+			t.Errorf("check %s ok = %v, expected %v (%s)", check.Name, check.OK, expected[check.Name], check.Message)
+		}
+	}
+}
+
+// TestService_ReadCachedInfo 测试视频信息缓存的有效期和删除
+func TestService_ReadCachedInfo(t *testing.T) {
+	dir := t.TempDir()
+	service := &Service{
+		config: &config.Config{
+			Ytdlp: config.YtdlpConfig{InfoCacheTTL: time.Minute},
+		},
+		logger:  zap.NewNop(),
+		storage: storage.NewLocal(dir, ""),
+	}
+
+	service.writeCachedInfo("abc", []byte(`{"id":"abc"}`))
+	if content, _, ok := service.readCachedInfo("abc"); !ok || string(content) != `{"id":"abc"}` {
+		t.Fatalf("readCachedInfo() = %q, %v, expected cached content", content, ok)
+	}
+
+	// 修改时间早于有效期时视为过期
+	expired := time.Now().Add(-2 * time.Minute)
+	if err := os.Chtimes(filepath.Join(dir, getVideoJsonKey("abc")), expired, expired); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := service.readCachedInfo("abc"); ok {
+		t.Error("readCachedInfo() returned expired cache")
+	}
+
+	if purged, err := service.PurgeVideoInfo("abc"); err != nil || !purged {
+		t.Errorf("PurgeVideoInfo() = %v, %v, expected true, nil", purged, err)
+	}
+	if purged, err := service.PurgeVideoInfo("abc"); err != nil || purged {
+		t.Errorf("PurgeVideoInfo() after purge = %v, %v, expected false, nil", purged, err)
+	}
+}
+
+// TestIsStaleFormatError 测试识别格式信息失效的错误输出
+func TestIsStaleFormatError(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		expected bool
+	}{
+		{"格式地址过期", "ERROR: unable to download video data: HTTP Error 403: Forbidden", true},
+		{"格式不可用", "ERROR: [youtube] abc: Requested format is not available. Use --list-formats for a list of available formats", true},
+		{"签名解析失败", "WARNING: [youtube] Signature extraction failed: Some formats may be missing", true},
+		{"其他错误", "ERROR: [youtube] abc: Video unavailable", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isStaleFormatError(tt.line); got != tt.expected {
+				t.Errorf("isStaleFormatError(%q) = %v, expected %v", tt.line, got, tt.expected)
+			}
+		})
+	}
+}
+
+// TestInfoLRU 测试视频信息内存缓存的淘汰、过期和删除
+func TestInfoLRU(t *testing.T) {
+	lru := newInfoLRU(config.InfoMemoryCacheConfig{MaxEntries: 2, MaxBytes: 100})
+	expiresAt := time.Now().Add(time.Minute)
+
+	lru.add("a", &RawVideoInfo{ID: "a"}, 10, expiresAt)
+	lru.add("b", &RawVideoInfo{ID: "b"}, 10, expiresAt)
+	// 访问 a 后 b 成为最久未使用的缓存
+	if _, ok := lru.get("a"); !ok {
+		t.Fatal("get(a) missed")
+	}
+	lru.add("c", &RawVideoInfo{ID: "c"}, 10, expiresAt)
+	if _, ok := lru.get("b"); ok {
+		t.Error("get(b) hit, expected evicted by entry count")
+	}
+
+	// 超过容量时连续淘汰最久未使用的缓存，只保留 d
+	lru.add("d", &RawVideoInfo{ID: "d"}, 95, expiresAt)
+	for _, id := range []string{"a", "c"} {
+		if _, ok := lru.get(id); ok {
+			t.Errorf("get(%s) hit, expected evicted by size", id)
+		}
+	}
+	if info, ok := lru.get("d"); !ok || info.ID != "d" {
+		t.Errorf("get(d) = %v, %v, expected d", info, ok)
+	}
+
+	// 大于容量的视频信息不缓存
+	lru.add("e", &RawVideoInfo{ID: "e"}, 101, expiresAt)
+	if _, ok := lru.get("e"); ok {
+		t.Error("get(e) hit, expected oversized entry not cached")
+	}
+
+	lru.add("f", &RawVideoInfo{ID: "f"}, 1, time.Now().Add(-time.Second))
+	if _, ok := lru.get("f"); ok {
+		t.Error("get(f) hit, expected expired")
+	}
+
+	if !lru.remove("d") || lru.remove("d") {
+		t.Error("remove(d) should succeed once")
+	}
+
+	// 禁用内存缓存时所有操作都是空操作
+	disabled := newInfoLRU(config.InfoMemoryCacheConfig{MaxEntries: -1})
+	disabled.add("a", &RawVideoInfo{ID: "a"}, 1, expiresAt)
+	if _, ok := disabled.get("a"); ok {
+		t.Error("disabled cache returned a hit")
+	}
+}
+
+// TestSanitizeFilename 测试由视频标题生成文件名
+func TestSanitizeFilename(t *testing.T) {
+	tests := []struct {
+		name     string
+		title    string
+		expected string
+	}{
+		{"普通标题", "Rick Astley - Never Gonna Give You Up", "Rick Astley - Never Gonna Give You Up"},
+		{"路径分隔符和特殊字符", `AC/DC: "Back\In*Black"?`, "AC DC Back In Black"},
+		{"控制字符和连续空白", "line1\nline2\t\t end", "line1 line2 end"},
+		{"首尾的点和空白", " ..hidden. ", "hidden"},
+		{"中文标题", "周杰伦《晴天》官方MV", "周杰伦《晴天》官方MV"},
+		{"只有特殊字符", `???`, ""},
+		{"超长标题", strings.Repeat("长", maxFilenameLength+10), strings.Repeat("长", maxFilenameLength)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeFilename(tt.title); got != tt.expected {
+				t.Errorf("sanitizeFilename(%q) = %q, expected %q", tt.title, got, tt.expected)
+			}
+		})
+	}
+}
+
+// TestService_GetStreamFfmpegArgs 测试流式下载是否需要 ffmpeg 以及复制或转码音频流
+func TestService_GetStreamFfmpegArgs(t *testing.T) {
+	service := New(&config.Config{}, zap.NewNop(), nil)
+	service.infoLRU.add("dQw4w9WgXcQ", &RawVideoInfo{
+		ID: "dQw4w9WgXcQ",
+		Formats: []RawFormat{
+			{FormatID: "140", Ext: "m4a", Acodec: "mp4a.40.2", Vcodec: "none"},
+			{FormatID: "251", Ext: "webm", Acodec: "opus", Vcodec: "none"},
+		},
+	}, 1, time.Now().Add(time.Minute))
+	const url = "https://www.youtube.com/watch?v=dQw4w9WgXcQ"
+
+	tests := []struct {
+		name     string
+		ext      string
+		formatID string
+		expected []string
+		noFfmpeg bool
+	}{
+		{name: "源格式相同直接输出", ext: "m4a", formatID: "140", noFfmpeg: true},
+		{name: "编码兼容只转换容器", ext: "opus", formatID: "251", expected: []string{"-c:a", "copy", "-f", "opus"}},
+		{name: "编码不兼容时转码", ext: "mp3", formatID: "251", expected: []string{"-c:a", "libmp3lame", "-f", "mp3"}},
+		{name: "m4a 使用分片封装", ext: "m4a", formatID: "251", expected: []string{"-c:a", "aac", "-movflags", "frag_keyframe+empty_moov", "-f", "ipod"}},
+		{name: "固定编码器的格式总是转码", ext: "vorbis", formatID: "251", expected: []string{"-c:a", "libvorbis", "-f", "ogg"}},
+		{name: "格式选择器总是转码", ext: "m4a", formatID: "bestaudio", expected: []string{"-c:a", "aac", "-movflags", "frag_keyframe+empty_moov", "-f", "ipod"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := service.getStreamFfmpegArgs(url, tt.ext, tt.formatID, streamMuxers[tt.ext])
+			if tt.noFfmpeg {
+				if args != nil {
+					t.Errorf("getStreamFfmpegArgs() = %v, expected nil", args)
+				}
+				return
+			}
+			joined := strings.Join(args, " ")
+			if !strings.HasPrefix(joined, "-hide_banner -loglevel error -i pipe:0 -vn ") || !strings.HasSuffix(joined, " pipe:1") {
+				t.Fatalf("getStreamFfmpegArgs() = %v, expected to read stdin and write stdout", args)
+			}
+			if expected := strings.Join(tt.expected, " "); !strings.Contains(joined, " "+expected+" ") {
+				t.Errorf("getStreamFfmpegArgs() = %s, expected to contain %s", joined, expected)
+			}
+		})
+	}
+}
+
+// TestService_RunRetention 测试按最后访问时间和总大小清理存储，以及清理 download_dir 中的残留临时文件
+func TestService_RunRetention(t *testing.T) {
+	root := t.TempDir()
+	downloadDir := t.TempDir()
+	activeID := utils.ToHex("active/active.mp3")
+	completedID := utils.ToHex("a/a.mp3")
+	orphanID := utils.ToHex("gone/gone.mp3")
+	service := &Service{
+		config: &config.Config{
+			Ytdlp: config.YtdlpConfig{DownloadDir: downloadDir},
+			Retention: config.RetentionConfig{
+				MaxAge:       24 * time.Hour,
+				MaxTotalSize: 1000,
+			},
+		},
+		logger:  zap.NewNop(),
+		storage: storage.NewLocal(root, ""),
+		store:   NewMemoryTaskStore(),
+		downloads: map[string]*DownloadTask{
+			activeID:    {ID: activeID, State: "downloading"},
+			completedID: {ID: completedID, State: "completed"},
+		},
+	}
+
+	now := time.Now()
+	writeFile := func(path string, size int, age time.Duration) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, now.Add(-age), now.Add(-age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(filepath.Join(root, "old/old.mp3"), 100, 48*time.Hour)
+	writeFile(filepath.Join(root, "touched/touched.mp3"), 100, 48*time.Hour)
+	writeFile(filepath.Join(root, "a/a.mp3"), 300, 3*time.Hour)
+	writeFile(filepath.Join(root, "b/b.mp3"), 300, 2*time.Hour)
+	writeFile(filepath.Join(root, "c/c.mp3"), 300, time.Hour)
+	writeFile(filepath.Join(root, "active/active.mp3"), 500, 72*time.Hour)
+	writeFile(filepath.Join(downloadDir, orphanID, "x.mp3.part"), 10, 2*time.Hour)
+	writeFile(filepath.Join(downloadDir, activeID, "x.mp3.part"), 10, 2*time.Hour)
+	writeFile(filepath.Join(downloadDir, utils.ToHex("fresh/fresh.mp3"), "x.mp3.part"), 10, 0)
+	service.access.touch("touched/touched.mp3")
+
+	expected := map[string]string{
+		"old/old.mp3":                        RetentionExpired,
+		"a/a.mp3":                            RetentionQuota,
+		"b/b.mp3":                            RetentionQuota,
+		filepath.Join(downloadDir, orphanID): RetentionOrphan,
+	}
+	checkReport := func(report *RetentionReport, expected map[string]string) {
+		t.Helper()
+		got := make(map[string]string)
+		for _, item := range report.Items {
+			if item.Error != "" {
+				t.Errorf("item %s failed: %s", item.Key, item.Error)
+			}
+			got[item.Key] = item.Reason
+		}
+		if len(got) != len(expected) {
+			t.Errorf("report items = %v, expected %v", got, expected)
+		}
+		for key, reason := range expected {
+			if got[key] != reason {
+				t.Errorf("item %s reason = %q, expected %q", key, got[key], reason)
+			}
+		}
+	}
+
+	// 试运行不删除文件
+	report, err := service.RunRetention(context.Background(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkReport(report, expected)
+	if report.TotalObjects != 6 || report.TotalBytes != 1600 || report.ReclaimedBytes != 710 {
+		t.Errorf("report totals = %d objects, %d bytes, %d reclaimed", report.TotalObjects, report.TotalBytes, report.ReclaimedBytes)
+	}
+	if _, err := os.Stat(filepath.Join(root, "old/old.mp3")); err != nil {
+		t.Errorf("dry run deleted old/old.mp3: %v", err)
+	}
+
+	report, err = service.RunRetention(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkReport(report, expected)
+	for key := range expected {
+		path := key
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(root, key)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s still exists after retention", key)
+		}
+	}
+	if _, err := service.GetDownloadStatus(completedID); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("completed task of deleted file not forgotten: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, accessIndexKey)); err != nil {
+		t.Errorf("access index not saved: %v", err)
+	}
+
+	// 再次清理时没有需要删除的文件
+	report, err = service.RunRetention(context.Background(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkReport(report, map[string]string{})
+}
+
+// TestService_RunRetention_SharedDir 测试 download_dir、存储目录和 cookies 文件在同一目录时
+// 只把任务临时目录当作残留文件，cookies 文件和 .part 文件不参与按时间和总大小的清理
+func TestService_RunRetention_SharedDir(t *testing.T) {
+	root := t.TempDir()
+	orphanID := utils.ToHex("gone/audio/48000/gone.mp3")
+	service := &Service{
+		config: &config.Config{
+			Ytdlp: config.YtdlpConfig{
+				DownloadDir: root,
+				CookiesPath: filepath.Join(root, "cookies.txt"),
+			},
+			Retention: config.RetentionConfig{
+				MaxAge:       time.Hour,
+				MaxTotalSize: 1,
+			},
+		},
+		logger:  zap.NewNop(),
+		storage: storage.NewLocal(root, ""),
+		store:   NewMemoryTaskStore(),
+	}
+
+	old := time.Now().Add(-48 * time.Hour)
+	for _, name := range []string{
+		"cookies.txt",
+		"abc/abc.json",
+		"abc/audio/48000/abc.mp3",
+		"deadbeef/x.mp3",
+		orphanID + "/gone.mp3.part",
+	} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, make([]byte, 10), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := service.RunRetention(context.Background(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]string)
+	for _, item := range report.Items {
+		got[item.Key] = item.Reason
+	}
+	if got[filepath.Join(root, orphanID)] != RetentionOrphan {
+		t.Errorf("task work dir not reported as orphan: %v", got)
+	}
+	for key, reason := range got {
+		if reason == RetentionOrphan && key != filepath.Join(root, orphanID) {
+			t.Errorf("%s reported as orphan", key)
+		}
+		if strings.HasSuffix(key, ".part") || strings.HasSuffix(key, "cookies.txt") {
+			t.Errorf("%s reported as %s", key, reason)
+		}
+	}
+	// .part 文件和 cookies 文件不计入存储总大小
+	if report.TotalObjects != 3 {
+		t.Errorf("report.TotalObjects = %d, expected 3", report.TotalObjects)
+	}
+}
+
+// TestService_OpenTaskFile_Signed 测试开启 signed_url 时下载任务结果文件需要有效的签名
+func TestService_OpenTaskFile_Signed(t *testing.T) {
+	root := t.TempDir()
+	cfg := &config.Config{
+		S3Mount: root,
+		Storage: config.StorageConfig{
+			SignedURL: config.SignedURLConfig{
+				Enabled: true,
+				Secret:  "secret",
+				BaseURL: "https://api.example.com/api/yt/files/",
+			},
+		},
+	}
+	fileStorage, err := storage.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := "abc/audio/48000/abc.mp3"
+	if err := fileStorage.Put(context.Background(), key, strings.NewReader("mp3")); err != nil {
+		t.Fatal(err)
+	}
+	taskID := utils.ToHex(key)
+	service := &Service{
+		config:    cfg,
+		logger:    zap.NewNop(),
+		storage:   fileStorage,
+		infoLRU:   newInfoLRU(config.InfoMemoryCacheConfig{}),
+		downloads: map[string]*DownloadTask{taskID: {ID: taskID, State: "completed"}},
+	}
+
+	signedURL, err := fileStorage.SignedURL(key, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(signedURL)
+	expires, signature := u.Query().Get("expires"), u.Query().Get("signature")
+
+	tests := []struct {
+		name      string
+		taskID    string
+		expires   string
+		signature string
+		expected  error
+	}{
+		{"签名正确", taskID, expires, signature, nil},
+		{"缺少签名", taskID, "", "", storage.ErrInvalidSignature},
+		{"其他文件的签名", utils.ToHex("def/audio/48000/def.mp3"), expires, signature, storage.ErrInvalidSignature},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, err := service.OpenTaskFile(context.Background(), tt.taskID, tt.expires, tt.signature)
+			if !errors.Is(err, tt.expected) {
+				t.Fatalf("OpenTaskFile() error = %v, expected %v", err, tt.expected)
+			}
+			if file != nil {
+				file.Reader.Close()
+			}
+		})
+	}
+}
+
+// writeFakeYtdlp 在临时目录中写入模拟 yt-dlp 的 shell 脚本，返回脚本路径
+func writeFakeYtdlp(t *testing.T, script string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake yt-dlp requires a unix shell")
+	}
+	path := filepath.Join(t.TempDir(), "yt-dlp")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// newTestTask 创建等待执行的下载任务
+func newTestTask(url, formatID string) *DownloadTask {
+	ctx, cancel := context.WithCancel(context.Background())
+	taskID, _ := (&Service{}).getTaskId(url, formatID, nil, "")
+	return &DownloadTask{
+		ID:     taskID,
+		URL:    url,
+		Format: formatID,
+		State:  "pending",
+		Ctx:    ctx,
+		Cancel: cancel,
+	}
+}
+
+// waitFor 等待 condition 成立，超时后测试失败
+func waitFor(t *testing.T, message string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", message)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestService_RunDownload_CancelThenRestart 测试任务取消后以相同的任务ID重新开始时，
+// 仍在退出的上一次执行不会删除新执行的临时文件
+func TestService_RunDownload_CancelThenRestart(t *testing.T) {
+	// 写入 .part 文件后等待同目录下出现 release 文件再完成下载
+	ytdlpPath := writeFakeYtdlp(t, `
+out=""
+while [ $# -gt 0 ]; do
+	if [ "$1" = "-o" ]; then out="$2"; fi
+	shift
+done
+echo partial > "$out.part"
+while [ ! -e "$(dirname "$out")/release" ]; do sleep 0.02; done
+mv "$out.part" "$out"
+`)
+	downloadDir := t.TempDir()
+	service := &Service{
+		config: &config.Config{
+			Ytdlp: config.YtdlpConfig{Path: ytdlpPath, DownloadDir: downloadDir},
+		},
+		logger:    zap.NewNop(),
+		downloads: make(map[string]*DownloadTask),
+		queue:     newDownloadQueue(),
+		store:     NewMemoryTaskStore(),
+		infoLRU:   newInfoLRU(config.InfoMemoryCacheConfig{}),
+		storage:   storage.NewLocal(t.TempDir(), ""),
+	}
+
+	url, formatID := "https://www.youtube.com/watch?v=abc", buildAudioFormatID("mp3", 48000, "bestaudio")
+	partFiles := func() []string {
+		matches, _ := filepath.Glob(filepath.Join(downloadDir, "*", "*", "abc.mp3.part"))
+		return matches
+	}
+	run := func(task *DownloadTask) chan struct{} {
+		done := make(chan struct{})
+		go func() {
+			service.runDownload(task)
+			close(done)
+		}()
+		return done
+	}
+
+	first := newTestTask(url, formatID)
+	service.downloads[first.ID] = first
+	firstDone := run(first)
+	waitFor(t, "first run to start", func() bool { return len(partFiles()) == 1 })
+	firstPart := partFiles()[0]
+	if _, err := service.CancelDownload(first.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// 上一次执行仍在退出时重新开始
+	second := newTestTask(url, formatID)
+	service.mutex.Lock()
+	service.downloads[second.ID] = second
+	service.mutex.Unlock()
+	secondDone := run(second)
+	waitFor(t, "second run to start", func() bool {
+		for _, part := range partFiles() {
+			if part != firstPart {
+				return true
+			}
+		}
+		return false
+	})
+
+	<-firstDone
+	parts := partFiles()
+	if len(parts) != 1 {
+		t.Fatalf("part files after cancelled run exited = %v, expected only the second run's file", parts)
+	}
+	if err := os.WriteFile(filepath.Join(filepath.Dir(parts[0]), "release"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	<-secondDone
+
+	task, err := service.GetDownloadStatus(second.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.State != "completed" {
+		t.Fatalf("second run state = %s (%s), expected completed", task.State, task.Error)
+	}
+	if entries, _ := os.ReadDir(downloadDir); len(entries) != 0 {
+		t.Errorf("download_dir not cleaned up: %v", entries)
+	}
+}
+
+// TestService_ProcessOutput 测试读完输出后才返回是否出现了格式信息失效的错误
+func TestService_ProcessOutput(t *testing.T) {
+	tests := []struct {
+		name     string
+		stdout   string
+		stderr   string
+		expected bool
+	}{
+		{"格式地址过期", "[download]  10.0% of 1.00MiB at 1.00MiB/s ETA 00:01\n", "WARNING: retrying\nERROR: unable to download video data: HTTP Error 403: Forbidden\n", true},
+		{"错误在最后一行且没有换行", "", "ERROR: [youtube] abc: Requested format is not available", true},
+		{"其他错误", "", "ERROR: [youtube] abc: Video unavailable\n", false},
+		{"较长的输出行", strings.Repeat("x", 128<<10) + "\n", strings.Repeat("y", 128<<10) + "\nERROR: unable to download video data: HTTP Error 403: Forbidden\n", true},
+		{"超过最大长度的输出行", "", strings.Repeat("y", maxOutputLineSize+1) + "\nERROR: unable to download video data: HTTP Error 403: Forbidden\n", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &Service{logger: zap.NewNop()}
+			task := &DownloadTask{ID: "abc"}
+			if got := service.processOutput(task, strings.NewReader(tt.stdout), strings.NewReader(tt.stderr)); got != tt.expected {
+				t.Errorf("processOutput() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+// TestService_RunDownload_StaleFormats 测试格式地址过期导致下载失败时重新获取视频信息，
+// 所选格式仍然存在时重新执行一次下载，已不存在时以 FORMAT_UNAVAILABLE 失败
+func TestService_RunDownload_StaleFormats(t *testing.T) {
+	tests := []struct {
+		name          string
+		format        string
+		expectedState string
+		expectedCode  string
+		expectedRuns  int
+	}{
+		{name: "格式仍然存在", format: "140", expectedState: "completed", expectedRuns: 2},
+		{name: "格式选择器", format: "bestaudio", expectedState: "completed", expectedRuns: 2},
+		{name: "格式已不存在", format: "251", expectedState: "failed", expectedCode: ErrorCodeFormatUnavailable, expectedRuns: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 获取视频信息时只返回格式 140；第一次下载输出格式地址过期的错误并失败，之后成功
+			runs := filepath.Join(t.TempDir(), "runs")
+			ytdlpPath := writeFakeYtdlp(t, `
+out=""
+while [ $# -gt 0 ]; do
+	if [ "$1" = "--dump-json" ]; then
+		echo '{"id":"abc","duration":10,"formats":[{"format_id":"140","ext":"m4a","acodec":"mp4a.40.2","vcodec":"none"}]}'
+		exit 0
+	fi
+	if [ "$1" = "-o" ]; then out="$2"; fi
+	shift
+done
+echo run >> "`+runs+`"
+if [ "$(wc -l < "`+runs+`")" -eq 1 ]; then
+	echo "[download] Destination: $out"
+	echo "ERROR: unable to download video data: HTTP Error 403: Forbidden" >&2
+	exit 1
+fi
+echo done > "$out"
+`)
+			service := &Service{
+				config: &config.Config{
+					Ytdlp: config.YtdlpConfig{Path: ytdlpPath, DownloadDir: t.TempDir()},
+				},
+				logger:    zap.NewNop(),
+				downloads: make(map[string]*DownloadTask),
+				store:     NewMemoryTaskStore(),
+				infoLRU:   newInfoLRU(config.InfoMemoryCacheConfig{}),
+				storage:   storage.NewLocal(t.TempDir(), ""),
+			}
+
+			task := newTestTask("https://www.youtube.com/watch?v=abc", buildAudioFormatID("mp3", 48000, tt.format))
+			service.downloads[task.ID] = task
+			service.runDownload(task)
+
+			if task.State != tt.expectedState || task.ErrorCode != tt.expectedCode {
+				t.Fatalf("task = state %s code %q (%s), expected state %s code %q",
+					task.State, task.ErrorCode, task.Error, tt.expectedState, tt.expectedCode)
+			}
+			data, _ := os.ReadFile(runs)
+			if got := strings.Count(string(data), "run"); got != tt.expectedRuns {
+				t.Errorf("download runs = %d, expected %d", got, tt.expectedRuns)
+			}
+			if _, ok := service.infoLRU.get("abc"); !ok {
+				t.Error("fresh video info not cached")
+			}
+		})
+	}
+}
+
+// TestService_RunDownload_StaleFormats_RetryOnce 测试重新执行后仍然失败时不再重试
+func TestService_RunDownload_StaleFormats_RetryOnce(t *testing.T) {
+	runs := filepath.Join(t.TempDir(), "runs")
+	ytdlpPath := writeFakeYtdlp(t, `
+for arg in "$@"; do
+	if [ "$arg" = "--dump-json" ]; then
+		echo '{"id":"abc","formats":[{"format_id":"140","ext":"m4a","acodec":"mp4a.40.2","vcodec":"none"}]}'
+		exit 0
+	fi
+done
+echo run >> "`+runs+`"
+echo "ERROR: unable to download video data: HTTP Error 403: Forbidden" >&2
+exit 1
+`)
+	service := &Service{
+		config: &config.Config{
+			Ytdlp: config.YtdlpConfig{Path: ytdlpPath, DownloadDir: t.TempDir()},
+		},
+		logger:    zap.NewNop(),
+		downloads: make(map[string]*DownloadTask),
+		store:     NewMemoryTaskStore(),
+		infoLRU:   newInfoLRU(config.InfoMemoryCacheConfig{}),
+		storage:   storage.NewLocal(t.TempDir(), ""),
+	}
+
+	task := newTestTask("https://www.youtube.com/watch?v=abc", buildAudioFormatID("mp3", 48000, "140"))
+	service.downloads[task.ID] = task
+	service.runDownload(task)
+
+	if task.State != "failed" || task.ErrorCode != "" {
+		t.Fatalf("task = state %s code %q, expected failed without code", task.State, task.ErrorCode)
+	}
+	data, _ := os.ReadFile(runs)
+	if got := strings.Count(string(data), "run"); got != 2 {
+		t.Errorf("download runs = %d, expected 2", got)
+	}
+}